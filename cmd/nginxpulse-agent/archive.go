@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// fingerprintSize 指纹最多覆盖的文件开头字节数
+	fingerprintSize = 1024
+	// archiveSettleTime 压缩文件最近修改后等待的时间，避免读到 logrotate 尚未写完的文件
+	archiveSettleTime = 5 * time.Second
+)
+
+// isArchive 轮转后压缩的日志文件，内容不再变化，读完一次即可
+func isArchive(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".gz")
+}
+
+// headFingerprint 读取一次文件开头（压缩文件为解压后内容），按长度计算指纹
+type headFingerprint struct {
+	path   string
+	loaded bool
+	data   []byte
+}
+
+func newHeadFingerprint(path string) *headFingerprint {
+	return &headFingerprint{path: path}
+}
+
+func (h *headFingerprint) load() []byte {
+	if h.loaded {
+		return h.data
+	}
+	h.loaded = true
+
+	file, err := os.Open(h.path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if isArchive(h.path) {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+	buf := make([]byte, fingerprintSize)
+	n, _ := io.ReadFull(reader, buf)
+	h.data = buf[:n]
+	return h.data
+}
+
+// available 可用于计算指纹的开头字节数
+func (h *headFingerprint) available() int64 {
+	return int64(len(h.load()))
+}
+
+// sum 返回开头 length 字节的指纹，内容不足 length 字节时返回 false
+func (h *headFingerprint) sum(length int64) (string, bool) {
+	data := h.load()
+	if length <= 0 || int64(len(data)) < length {
+		return "", false
+	}
+	hash := sha1.Sum(data[:length])
+	return hex.EncodeToString(hash[:]), true
+}
+
+// archiveCursor 压缩文件的读取进度，多次轮询之间保持打开，避免每次从头解压
+type archiveCursor struct {
+	file   *os.File
+	gz     *gzip.Reader
+	reader *bufio.Reader
+	offset int64
+}
+
+// openArchive 打开压缩文件并跳过解压后的前 offset 字节
+func openArchive(path string, offset int64) (*archiveCursor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	cursor := &archiveCursor{file: file, gz: gzReader, offset: offset}
+	if _, err := io.CopyN(io.Discard, gzReader, offset); err != nil {
+		cursor.close()
+		return nil, err
+	}
+	cursor.reader = bufio.NewReader(gzReader)
+	return cursor, nil
+}
+
+func (c *archiveCursor) close() {
+	if c == nil {
+		return
+	}
+	c.gz.Close()
+	c.file.Close()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/version"
+	"github.com/sirupsen/logrus"
+)
+
+type registerInput struct {
+	WebsiteID string   `json:"website_id"`
+	SourceID  string   `json:"source_id"`
+	Paths     []string `json:"paths"`
+}
+
+type registerRequest struct {
+	AgentID           string          `json:"agent_id"`
+	Hostname          string          `json:"hostname"`
+	Version           string          `json:"version"`
+	HeartbeatInterval int64           `json:"heartbeat_interval"`
+	Inputs            []registerInput `json:"inputs"`
+}
+
+// registerResponse 服务端的注册响应，旧版本服务端没有 content_encodings
+type registerResponse struct {
+	ContentEncodings []string `json:"content_encodings"`
+}
+
+type fileStatus struct {
+	Path      string `json:"path"`
+	WebsiteID string `json:"website_id"`
+	SourceID  string `json:"source_id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Lag       int64  `json:"lag"`
+}
+
+type heartbeatRequest struct {
+	AgentID      string       `json:"agent_id"`
+	Files        []fileStatus `json:"files"`
+	Lag          int64        `json:"lag"`
+	SpoolBatches int          `json:"spool_batches"`
+	SpoolBytes   int64        `json:"spool_bytes"`
+	LastError    string       `json:"last_error,omitempty"`
+	LastErrorAt  int64        `json:"last_error_at,omitempty"`
+}
+
+// lastError 最近一次读取或推送失败的原因，随心跳上报
+type lastError struct {
+	mu      sync.Mutex
+	message string
+	at      time.Time
+}
+
+func (e *lastError) set(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.message = err.Error()
+	e.at = time.Now()
+}
+
+func (e *lastError) get() (string, int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.message == "" {
+		return "", 0
+	}
+	return e.message, e.at.Unix()
+}
+
+// controller 启动时向服务端注册，之后定期发送心跳上报各文件的推送进度
+type controller struct {
+	server    string
+	accessKey string
+	agentID   string
+	hostname  string
+	inputs    []agentInput
+	interval  time.Duration
+	states    *stateStore
+	queue     *spool
+	failures  *lastError
+	encodings *encodingSupport
+
+	registered bool
+}
+
+func (c *controller) run(ctx context.Context) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		err := c.report(ctx, client)
+		var pushErr *pushError
+		if !c.registered && errors.As(err, &pushErr) && pushErr.status == http.StatusNotFound {
+			logrus.Warn("服务端不支持 agent 注册，不再发送心跳")
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warn("向服务端发送心跳失败")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// report 发送心跳，服务端重启后不认识该 agent 时重新注册
+func (c *controller) report(ctx context.Context, client *http.Client) error {
+	if !c.registered {
+		if err := c.register(ctx, client); err != nil {
+			return err
+		}
+	}
+	err := postJSON(ctx, client, c.server+"/api/agents/heartbeat", c.accessKey, c.heartbeat(), nil)
+	var pushErr *pushError
+	if errors.As(err, &pushErr) && pushErr.status == http.StatusNotFound {
+		c.registered = false
+		if err := c.register(ctx, client); err != nil {
+			return err
+		}
+		return postJSON(ctx, client, c.server+"/api/agents/heartbeat", c.accessKey, c.heartbeat(), nil)
+	}
+	return err
+}
+
+func (c *controller) register(ctx context.Context, client *http.Client) error {
+	req := registerRequest{
+		AgentID:           c.agentID,
+		Hostname:          c.hostname,
+		Version:           version.Version,
+		HeartbeatInterval: int64(c.interval / time.Second),
+	}
+	for _, input := range c.inputs {
+		req.Inputs = append(req.Inputs, registerInput{
+			WebsiteID: input.WebsiteID,
+			SourceID:  input.SourceID,
+			Paths:     input.Paths,
+		})
+	}
+	var resp registerResponse
+	if err := postJSON(ctx, client, c.server+"/api/agents/register", c.accessKey, req, &resp); err != nil {
+		return err
+	}
+	c.encodings.set(resp.ContentEncodings)
+	c.registered = true
+	logrus.Infof("已向服务端注册，agent ID: %s", c.agentID)
+	return nil
+}
+
+// heartbeat 汇总各输入匹配到的文件的已确认位置。压缩文件的位置为解压后的字节数，
+// 未读完时按压缩后大小计入积压。
+func (c *controller) heartbeat() heartbeatRequest {
+	req := heartbeatRequest{AgentID: c.agentID, Files: []fileStatus{}}
+	seen := make(map[string]bool)
+	for _, input := range c.inputs {
+		for _, path := range input.match(seen) {
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			offset, _ := c.states.get(path)
+			status := fileStatus{
+				Path:      path,
+				WebsiteID: input.WebsiteID,
+				SourceID:  input.SourceID,
+				Offset:    offset.Offset,
+				Size:      info.Size(),
+			}
+			switch {
+			case isArchive(path):
+				if !offset.Complete {
+					status.Lag = info.Size()
+				}
+			case info.Size() > offset.Offset:
+				status.Lag = info.Size() - offset.Offset
+			}
+			req.Lag += status.Lag
+			req.Files = append(req.Files, status)
+		}
+	}
+	req.SpoolBatches, req.SpoolBytes = c.queue.stats()
+	req.LastError, req.LastErrorAt = c.failures.get()
+	return req
+}
+
+// loadAgentID 读取 agent ID，首次启动时生成并保存，重启后保持不变
+func loadAgentID(dataDir string) (string, error) {
+	path := filepath.Join(dataDir, "agent_id")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", err
+	}
+	return id, writeFileSync(path, []byte(id+"\n"))
+}
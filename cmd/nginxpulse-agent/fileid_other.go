@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileID 当前平台无法获取 inode，返回 0 表示未知，仅靠文件变小识别轮转
+func fileID(info os.FileInfo) (uint64, uint64) {
+	_ = info
+	return 0, 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID 返回文件的设备号与 inode，用于识别轮转后的新文件
+func fileID(info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -1,45 +1,67 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/enrich"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
 	"github.com/sirupsen/logrus"
 )
 
 type agentConfig struct {
-	Server        string   `json:"server"`
-	AccessKey     string   `json:"accessKey"`
-	WebsiteID     string   `json:"websiteID"`
-	SourceID      string   `json:"sourceID"`
-	Paths         []string `json:"paths"`
-	PollInterval  string   `json:"pollInterval"`
-	BatchSize     int      `json:"batchSize"`
-	FlushInterval string   `json:"flushInterval"`
+	Server    string `json:"server"`
+	AccessKey string `json:"accessKey"`
+	// WebsiteID、SourceID、Paths 为只有一个输入时的简写，与 Inputs 同时配置时作为第一个输入
+	WebsiteID         string       `json:"websiteID"`
+	SourceID          string       `json:"sourceID"`
+	Paths             []string     `json:"paths"`
+	Inputs            []agentInput `json:"inputs"`
+	PollInterval      string       `json:"pollInterval"`
+	BatchSize         int          `json:"batchSize"`
+	FlushInterval     string       `json:"flushInterval"`
+	DataDir           string       `json:"dataDir"`           // 读取位置与磁盘队列的保存目录
+	SpoolMaxSize      int          `json:"spoolMaxSize"`      // 磁盘队列上限（MB）
+	RetryMaxInterval  string       `json:"retryMaxInterval"`  // 推送失败后重试间隔的上限
+	HeartbeatInterval string       `json:"heartbeatInterval"` // 向服务端发送心跳的间隔
+	Compression       string       `json:"compression"`       // 推送请求体的压缩方式：gzip（默认）、zstd、none
+	// PVFilter 本地解析时计算 PV 标记使用的过滤规则，格式与服务端的 pvFilter 相同，留空的项使用默认值
+	PVFilter config.PVFilterConfig `json:"pvFilter"`
 }
 
-type ingestRequest struct {
-	WebsiteID string   `json:"website_id"`
-	SourceID  string   `json:"source_id"`
-	Lines     []string `json:"lines"`
-}
+// agentInput 一组日志文件及其所属的站点与来源
+type agentInput struct {
+	WebsiteID string   `json:"websiteID"`
+	SourceID  string   `json:"sourceID"`
+	Paths     []string `json:"paths"`   // 日志文件路径，支持通配符，轮转后压缩的 .gz 文件也会读取
+	Exclude   []string `json:"exclude"` // 排除的文件，匹配完整路径或文件名
+	// Parse 设置后在本地按该配置解析日志，推送结构化记录，服务端不再逐行解析
+	Parse         *config.ParseConfig `json:"parse"`
+	PageviewsOnly bool                `json:"pageviewsOnly"` // 本地解析时只推送计入 PV 的记录
 
-type fileState struct {
-	offset   int64
-	lastSize int64
-	partial  string
+	parser *parse.LineParser
 }
 
+const (
+	defaultDataDir      = "data/agent"
+	defaultSpoolMaxSize = 256
+	defaultSourceID     = "agent"
+
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
 func main() {
 	configPath := flag.String("config", "configs/nginxpulse_agent.json", "agent config path")
 	flag.Parse()
@@ -50,20 +72,83 @@ func main() {
 		os.Exit(1)
 	}
 
+	enrich.ApplyPVFilters(cfg.PVFilter)
 	pollInterval := parseDuration(cfg.PollInterval, time.Second)
 	flushInterval := parseDuration(cfg.FlushInterval, 2*time.Second)
 	batchSize := cfg.BatchSize
 	if batchSize <= 0 {
 		batchSize = 200
 	}
-	sourceID := strings.TrimSpace(cfg.SourceID)
-	if sourceID == "" {
-		sourceID = "agent"
+	dataDir := strings.TrimSpace(cfg.DataDir)
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	heartbeatInterval := parseDuration(cfg.HeartbeatInterval, 30*time.Second)
+	if heartbeatInterval < time.Second {
+		heartbeatInterval = time.Second
+	}
+	spoolMaxSize := cfg.SpoolMaxSize
+	if spoolMaxSize <= 0 {
+		spoolMaxSize = defaultSpoolMaxSize
+	}
+
+	states, err := loadState(filepath.Join(dataDir, "state.json"))
+	if err != nil {
+		logrus.WithError(err).Error("加载 agent 读取位置失败")
+		os.Exit(1)
+	}
+	queue, err := openSpool(filepath.Join(dataDir, "spool"), int64(spoolMaxSize)<<20)
+	if err != nil {
+		logrus.WithError(err).Error("打开磁盘队列失败")
+		os.Exit(1)
+	}
+	queue.dropCommitted(states)
+	if pending := queue.len(); pending > 0 {
+		logrus.Infof("磁盘队列中有 %d 批未推送的日志，将继续推送", pending)
 	}
+	agentID, err := loadAgentID(dataDir)
+	if err != nil {
+		logrus.WithError(err).Error("加载 agent ID 失败")
+		os.Exit(1)
+	}
+	hostname, _ := os.Hostname()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := strings.TrimRight(cfg.Server, "/")
+	failures := &lastError{}
+	encodings := &encodingSupport{}
+	sender := &batchSender{
+		endpoint:  server + "/api/ingest/logs",
+		records:   server + "/api/ingest/records",
+		accessKey: cfg.AccessKey,
+		fallback:  cfg.Inputs[0],
+		states:    states,
+		queue:     queue,
+		retryMax:  parseDuration(cfg.RetryMaxInterval, time.Minute),
+		failures:  failures,
+
+		compression: cfg.Compression,
+		encodings:   encodings,
+	}
+	go sender.run(ctx)
 
-	endpoint := strings.TrimRight(cfg.Server, "/") + "/api/ingest/logs"
-	states := make(map[string]*fileState)
-	pending := make([]string, 0, batchSize)
+	control := &controller{
+		server:    server,
+		accessKey: cfg.AccessKey,
+		agentID:   agentID,
+		hostname:  hostname,
+		inputs:    cfg.Inputs,
+		interval:  heartbeatInterval,
+		states:    states,
+		queue:     queue,
+		failures:  failures,
+		encodings: encodings,
+	}
+	go control.run(ctx)
+
+	reader := newLogReader(cfg.Inputs, batchSize, states, queue, failures)
 
 	pollTicker := time.NewTicker(pollInterval)
 	flushTicker := time.NewTicker(flushInterval)
@@ -72,42 +157,17 @@ func main() {
 
 	for {
 		select {
+		case <-ctx.Done():
+			// 未写入磁盘队列的日志没有推进持久化的位置，重启后会重新读取
+			logrus.Info("agent 已退出")
+			return
 		case <-pollTicker.C:
-			for _, path := range cfg.Paths {
-				if strings.HasSuffix(strings.ToLower(path), ".gz") {
-					continue
-				}
-				state := states[path]
-				if state == nil {
-					state = &fileState{}
-					states[path] = state
-				}
-				lines, err := readNewLines(path, state)
-				if err != nil {
-					logrus.WithError(err).Warnf("读取日志失败: %s", path)
-					continue
-				}
-				if len(lines) == 0 {
-					continue
-				}
-				pending = append(pending, lines...)
-				if len(pending) >= batchSize {
-					if err := pushLines(endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
-						logrus.WithError(err).Warn("日志推送失败，将在下次重试")
-						continue
-					}
-					pending = pending[:0]
-				}
-			}
+			reader.poll()
 		case <-flushTicker.C:
-			if len(pending) == 0 {
-				continue
-			}
-			if err := pushLines(endpoint, cfg.AccessKey, cfg.WebsiteID, sourceID, pending); err != nil {
-				logrus.WithError(err).Warn("日志推送失败，将在下次重试")
-				continue
+			if err := reader.flush(); err != nil {
+				logrus.WithError(err).Warn("写入磁盘队列失败")
+				failures.set(err)
 			}
-			pending = pending[:0]
 		}
 	}
 }
@@ -130,97 +190,70 @@ func loadConfig(path string) (*agentConfig, error) {
 	if strings.TrimSpace(cfg.Server) == "" {
 		return nil, errors.New("server 不能为空")
 	}
-	if strings.TrimSpace(cfg.WebsiteID) == "" {
-		return nil, errors.New("websiteID 不能为空")
+	if len(cfg.Paths) > 0 || strings.TrimSpace(cfg.WebsiteID) != "" {
+		shorthand := agentInput{WebsiteID: cfg.WebsiteID, SourceID: cfg.SourceID, Paths: cfg.Paths}
+		cfg.Inputs = append([]agentInput{shorthand}, cfg.Inputs...)
 	}
-	if len(cfg.Paths) == 0 {
-		return nil, errors.New("paths 不能为空")
-	}
-	return cfg, nil
-}
-
-func readNewLines(path string, state *fileState) ([]string, error) {
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, err
+	if len(cfg.Inputs) == 0 {
+		return nil, errors.New("inputs 不能为空")
 	}
-	size := info.Size()
-	if size < state.offset {
-		state.offset = 0
-		state.partial = ""
+	switch strings.ToLower(strings.TrimSpace(cfg.Compression)) {
+	case "", compressionGzip:
+		cfg.Compression = compressionGzip
+	case compressionZstd:
+		cfg.Compression = compressionZstd
+	case "none":
+		cfg.Compression = ""
+	default:
+		return nil, errors.New("compression 仅支持 gzip、zstd、none")
 	}
-	if size == state.offset {
-		return nil, nil
+	for i := range cfg.Inputs {
+		if err := normalizeInput(&cfg.Inputs[i]); err != nil {
+			return nil, fmt.Errorf("inputs[%d]: %w", i, err)
+		}
 	}
-
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+	defaults := config.DefaultConfig().PVFilter
+	if len(cfg.PVFilter.StatusCodeInclude) == 0 {
+		cfg.PVFilter.StatusCodeInclude = defaults.StatusCodeInclude
 	}
-	defer file.Close()
-
-	if _, err := file.Seek(state.offset, io.SeekStart); err != nil {
-		return nil, err
+	if len(cfg.PVFilter.ExcludePatterns) == 0 {
+		cfg.PVFilter.ExcludePatterns = defaults.ExcludePatterns
 	}
-
-	reader := bufio.NewReader(file)
-	lines := []string{}
-	for {
-		line, err := reader.ReadString('\n')
-		if len(line) == 0 && err != nil {
-			break
-		}
-		state.offset += int64(len(line))
-		line = strings.TrimRight(line, "\r\n")
-		if state.partial != "" {
-			line = state.partial + line
-			state.partial = ""
-		}
-		if err == io.EOF {
-			if line != "" {
-				state.partial = line
-			}
-			break
-		}
-		if line != "" {
-			lines = append(lines, line)
-		}
-		if err != nil {
-			break
+	for _, pattern := range cfg.PVFilter.ExcludePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("pvFilter.excludePatterns %q 无效: %w", pattern, err)
 		}
 	}
-	state.lastSize = size
-	return lines, nil
+	return cfg, nil
 }
 
-func pushLines(endpoint, accessKey, websiteID, sourceID string, lines []string) error {
-	payload := ingestRequest{
-		WebsiteID: websiteID,
-		SourceID:  sourceID,
-		Lines:     lines,
+func normalizeInput(input *agentInput) error {
+	input.WebsiteID = strings.TrimSpace(input.WebsiteID)
+	if input.WebsiteID == "" {
+		return errors.New("websiteID 不能为空")
 	}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return err
+	input.SourceID = strings.TrimSpace(input.SourceID)
+	if input.SourceID == "" {
+		input.SourceID = defaultSourceID
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if strings.TrimSpace(accessKey) != "" {
-		req.Header.Set("X-NginxPulse-Key", strings.TrimSpace(accessKey))
+	if len(input.Paths) == 0 {
+		return errors.New("paths 不能为空")
 	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	for _, patterns := range [][]string{input.Paths, input.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("路径 %q 无效: %w", pattern, err)
+			}
+		}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("http status %d", resp.StatusCode)
+	if input.Parse != nil {
+		parser, err := parse.NewFromConfig(*input.Parse)
+		if err != nil {
+			return fmt.Errorf("parse: %w", err)
+		}
+		input.parser = parser
+	} else if input.PageviewsOnly {
+		return errors.New("pageviewsOnly 需要同时配置 parse")
 	}
 	return nil
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+)
+
+// parseLines 在本地解析日志行：解析成功的作为结构化记录推送，解析失败的原样推送，
+// 由服务端按自身规则再解析并记录解析失败；开启 pageviewsOnly 时丢弃不计入 PV 的记录
+func (in agentInput) parseLines(lines []string) ([]parse.Record, []string) {
+	if in.parser == nil {
+		return nil, lines
+	}
+	records := make([]parse.Record, 0, len(lines))
+	var unparsed []string
+	for _, line := range lines {
+		record, err := in.parser.Parse(line)
+		if err != nil {
+			unparsed = append(unparsed, line)
+			continue
+		}
+		if in.PageviewsOnly && record.PageviewFlag == 0 {
+			continue
+		}
+		records = append(records, *record)
+	}
+	return records, unparsed
+}
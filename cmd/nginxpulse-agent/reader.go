@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inputReader 一个输入正在凑批的日志，批次按输入的站点与来源推送
+type inputReader struct {
+	agentInput
+	pending []string
+	offsets map[string]batchOffset // pending 中每个文件读到的位置
+}
+
+// match 展开输入的路径通配符，跳过排除的文件与已被前面的输入匹配的文件
+func (in agentInput) match(seen map[string]bool) []string {
+	var paths []string
+	for _, pattern := range in.Paths {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		for _, path := range matches {
+			if seen[path] || in.excluded(path) {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// excluded 排除规则匹配完整路径或文件名
+func (in agentInput) excluded(path string) bool {
+	for _, pattern := range in.Exclude {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fileState 文件当前的读取位置，读到的内容写入磁盘队列后即推进，服务端确认后才持久化
+type fileState struct {
+	fileOffset
+	size   int64          // 上次读取时的文件大小，未变化时跳过指纹校验
+	cursor *archiveCursor // 压缩文件的读取进度
+}
+
+func (s *fileState) closeCursor() {
+	s.cursor.close()
+	s.cursor = nil
+}
+
+// logReader 读取各输入匹配到的日志文件的新增内容，凑满一批后写入磁盘队列
+type logReader struct {
+	inputs    []*inputReader
+	batchSize int
+	states    *stateStore
+	queue     *spool
+
+	failures *lastError
+
+	files   map[string]*fileState
+	paused  bool
+	started bool
+}
+
+func newLogReader(inputs []agentInput, batchSize int, states *stateStore, queue *spool, failures *lastError) *logReader {
+	r := &logReader{
+		batchSize: batchSize,
+		states:    states,
+		queue:     queue,
+		failures:  failures,
+		files:     make(map[string]*fileState),
+	}
+	for _, input := range inputs {
+		r.inputs = append(r.inputs, &inputReader{agentInput: input, offsets: make(map[string]batchOffset)})
+	}
+	return r
+}
+
+func (r *logReader) poll() {
+	if r.paused {
+		if !r.queue.drained() {
+			return
+		}
+		logrus.Info("磁盘队列已有空间，继续读取日志")
+		r.paused = false
+	}
+
+	seen := make(map[string]bool)
+	matched := make([][]string, len(r.inputs))
+	for i, in := range r.inputs {
+		matched[i] = in.match(seen)
+	}
+	r.forgetMissing(seen)
+
+	for i, in := range r.inputs {
+		for _, path := range matched[i] {
+			if !r.readFile(in, path) {
+				return
+			}
+		}
+	}
+}
+
+// readFile 读取文件的新增内容，磁盘队列已满时返回 false
+func (r *logReader) readFile(in *inputReader, path string) bool {
+	for {
+		if r.queue.full() {
+			r.pause()
+			return false
+		}
+
+		lines, err := r.readNewLines(in, path, r.batchSize-len(in.pending))
+		if err != nil {
+			logrus.WithError(err).Warnf("读取日志失败: %s", path)
+			r.failures.set(err)
+			return true
+		}
+		if len(lines) == 0 {
+			return true
+		}
+		in.pending = append(in.pending, lines...)
+		if len(in.pending) >= r.batchSize {
+			if err := r.seal(in); err != nil {
+				logrus.WithError(err).Warn("写入磁盘队列失败")
+				r.failures.set(err)
+				return false
+			}
+			if r.paused {
+				return false
+			}
+		}
+	}
+}
+
+// forgetMissing 已不存在的文件（被改名、压缩或删除）不再跟踪，位置暂存起来供改名或压缩后的文件认领。
+// 首次轮询时同时清理 agent 停止期间消失的文件。
+func (r *logReader) forgetMissing(seen map[string]bool) {
+	if !r.started {
+		r.started = true
+		for _, path := range r.states.paths() {
+			if seen[path] {
+				continue
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			offset, _ := r.states.get(path)
+			if err := r.states.forget(path, offset); err != nil {
+				logrus.WithError(err).Warn("保存读取位置失败")
+			}
+		}
+	}
+	for path, state := range r.files {
+		if seen[path] {
+			continue
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := r.states.forget(path, state.fileOffset); err != nil {
+			logrus.WithError(err).Warn("保存读取位置失败")
+		}
+		state.closeCursor()
+		delete(r.files, path)
+	}
+}
+
+// pause 磁盘队列已满时暂停读取，已读取的日志留在内存中，队列消化到一半以下后再继续读取
+func (r *logReader) pause() {
+	if !r.paused {
+		logrus.Warn("磁盘队列已满，暂停读取日志，等待服务端恢复")
+		r.paused = true
+	}
+}
+
+// flush 将所有输入已读取的日志写入磁盘队列
+func (r *logReader) flush() error {
+	for _, in := range r.inputs {
+		if err := r.seal(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seal 将输入已读取的日志写入磁盘队列，队列已满时不返回错误，保留日志稍后重试
+func (r *logReader) seal(in *inputReader) error {
+	if len(in.pending) == 0 {
+		return nil
+	}
+	offsets := make([]batchOffset, 0, len(in.offsets))
+	for _, offset := range in.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Path < offsets[j].Path
+	})
+	records, lines := in.parseLines(in.pending)
+	if err := r.queue.append(in.WebsiteID, in.SourceID, lines, records, offsets); err != nil {
+		if errors.Is(err, errSpoolFull) {
+			r.pause()
+			return nil
+		}
+		return err
+	}
+	in.pending = nil
+	in.offsets = make(map[string]batchOffset)
+	return nil
+}
+
+// stateFor 返回文件的读取位置：首次读取时从已确认位置与磁盘队列中已读到的位置继续，
+// 新出现的文件若是已读取过的文件改名或压缩而来，则从该文件已读到的位置继续；
+// 文件被轮转（inode 或开头内容变化）或截断时从头读取
+func (r *logReader) stateFor(in *inputReader, path string, info os.FileInfo) (*fileState, error) {
+	dev, inode := fileID(info)
+	head := newHeadFingerprint(path)
+	state := r.files[path]
+	if state == nil {
+		offset, ok := r.states.get(path)
+		if ok {
+			if queued, found := r.queue.queuedOffset(path, offset.Epoch); found && queued > offset.Offset {
+				offset.Offset = queued
+			}
+		} else {
+			offset = fileOffset{Epoch: r.states.nextEpoch(path)}
+			r.claim(path, dev, inode, head, &offset)
+		}
+		state = &fileState{fileOffset: offset, size: -1}
+		r.files[path] = state
+	}
+
+	replaced := false
+	if info.Size() != state.size || (state.Inode != 0 && (state.Dev != dev || state.Inode != inode)) {
+		replaced = !state.sameFile(dev, inode, head)
+	}
+	if replaced || (!isArchive(path) && info.Size() < state.Offset) {
+		offset, err := r.states.reset(path, state.fileOffset)
+		if err != nil {
+			return nil, err
+		}
+		state.closeCursor()
+		state.fileOffset = offset
+		delete(in.offsets, path)
+		if replaced {
+			r.claim(path, dev, inode, head, &state.fileOffset)
+		}
+	}
+
+	state.refresh(dev, inode, head)
+	state.size = info.Size()
+	return state, nil
+}
+
+// claim 查找新文件的来源：读取期间被改名的文件、其他路径上已不在原处的文件或暂存的轮转文件，
+// 取其中已读到的最大位置（包括磁盘队列中尚未确认的批次）
+func (r *logReader) claim(path string, dev, inode uint64, head *headFingerprint, offset *fileOffset) {
+	var (
+		from     string
+		read     int64
+		complete bool
+	)
+	take := func(source string, candidate int64, done bool) {
+		if from == "" || candidate > read || candidate == read && done {
+			from, read, complete = source, candidate, done
+		}
+	}
+
+	for other, state := range r.files {
+		if other != path && state.claims(dev, inode, head) && !stillAtPath(other, state.fileOffset) {
+			// 内存中的位置可能尚未推送，不据此标记为已完成
+			take(other, state.Offset, false)
+		}
+	}
+	files, rotated := r.states.records(path)
+	for _, record := range files {
+		if !record.claims(dev, inode, head) || stillAtPath(record.Path, record.fileOffset) {
+			continue
+		}
+		queued, _ := r.queue.queuedOffset(record.Path, record.Epoch)
+		take(record.Path, max(record.Offset, queued), record.Complete && queued <= record.Offset)
+	}
+	for _, record := range rotated {
+		if !record.claims(dev, inode, head) {
+			continue
+		}
+		queued, _ := r.queue.queuedOffset(record.Path, record.Epoch)
+		take(record.Path, max(record.Offset, queued), record.Complete && queued <= record.Offset)
+	}
+	if from == "" {
+		return
+	}
+
+	logrus.Infof("日志文件 %s 与已读取过的 %s 为同一文件，从第 %d 字节继续读取", path, from, read)
+	offset.Offset, offset.Complete = read, complete
+	if complete {
+		// 已全部推送的压缩文件直接记录到新路径，不必在每次重启后重新认领
+		offset.refresh(dev, inode, head)
+		if err := r.states.adopt(path, *offset); err != nil {
+			logrus.WithError(err).Warn("保存读取位置失败")
+		}
+	}
+}
+
+// stillAtPath 判断记录中的文件是否仍在原路径上
+func stillAtPath(path string, offset fileOffset) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	dev, inode := fileID(info)
+	return offset.sameFile(dev, inode, newHeadFingerprint(path))
+}
+
+// readNewLines 从上次的位置读取最多 maxLines 行完整的日志，末尾未写完的行留到下次读取
+func (r *logReader) readNewLines(in *inputReader, path string, maxLines int) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, nil
+	}
+	archive := isArchive(path)
+	if archive && time.Since(info.ModTime()) < archiveSettleTime {
+		return nil, nil
+	}
+	state, err := r.stateFor(in, path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	if archive {
+		lines, err = readArchiveLines(path, state, maxLines)
+	} else {
+		lines, err = readPlainLines(path, state, info.Size(), maxLines)
+	}
+	if err != nil {
+		return nil, err
+	}
+	in.offsets[path] = batchOffset{Path: path, fileOffset: state.fileOffset}
+	return lines, nil
+}
+
+func readPlainLines(path string, state *fileState, size int64, maxLines int) ([]string, error) {
+	if size == state.Offset {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(state.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	lines := []string{}
+	for len(lines) < maxLines {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		state.Offset += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// readArchiveLines 按解压后的位置读取压缩文件，读到末尾后标记为已完成，最后一行没有换行符也会读取
+func readArchiveLines(path string, state *fileState, maxLines int) ([]string, error) {
+	if state.Complete || maxLines <= 0 {
+		return nil, nil
+	}
+	if state.cursor == nil || state.cursor.offset != state.Offset {
+		state.closeCursor()
+		cursor, err := openArchive(path, state.Offset)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logrus.WithError(err).Warnf("压缩日志 %s 无法读取，已跳过", path)
+			}
+			state.Complete = true
+			return nil, nil
+		}
+		state.cursor = cursor
+	}
+
+	cursor := state.cursor
+	lines := []string{}
+	for len(lines) < maxLines {
+		line, err := cursor.reader.ReadString('\n')
+		cursor.offset += int64(len(line))
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			lines = append(lines, line)
+		}
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			logrus.WithError(err).Warnf("压缩日志 %s 已损坏，跳过剩余内容", path)
+		}
+		state.Complete = true
+		state.closeCursor()
+		break
+	}
+	state.Offset = cursor.offset
+	return lines, nil
+}
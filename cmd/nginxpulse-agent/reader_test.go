@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// identify 返回文件当前的身份（设备号、inode 与开头指纹），模拟读取时记录的位置
+func identify(t *testing.T, path string, offset int64, complete bool) fileOffset {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, inode := fileID(info)
+	record := fileOffset{Offset: offset, Complete: complete}
+	record.refresh(dev, inode, newHeadFingerprint(path))
+	return record
+}
+
+func newTestLogReader(t *testing.T, states *stateStore) *logReader {
+	t.Helper()
+	queue, err := openSpool(filepath.Join(t.TempDir(), "spool"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &logReader{states: states, queue: queue, files: make(map[string]*fileState)}
+}
+
+// claimFile 模拟新出现的文件被发现时的认领
+func claimFile(t *testing.T, r *logReader, path string) fileOffset {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, inode := fileID(info)
+	var offset fileOffset
+	r.claim(path, dev, inode, newHeadFingerprint(path), &offset)
+	return offset
+}
+
+func TestLogReaderClaim(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("127.0.0.1 - - [18/Oct/2026:10:00:00 +0000] \"GET / HTTP/1.1\" 200 0\n", 20)
+	current := filepath.Join(dir, "access.log")
+	rotated := filepath.Join(dir, "access.log.1")
+
+	t.Run("renamed file continues from committed offset", func(t *testing.T) {
+		if err := os.WriteFile(current, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		states := newTestStateStore(t, map[string]fileOffset{current: identify(t, current, 100, false)}, nil)
+		r := newTestLogReader(t, states)
+
+		// 仍在原路径时不认领
+		if got := claimFile(t, r, current); got.Offset != 0 {
+			t.Fatalf("文件仍在原路径，不应被认领: %+v", got)
+		}
+		if err := os.Rename(current, rotated); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(rotated)
+		if got := claimFile(t, r, rotated); got.Offset != 100 || got.Complete {
+			t.Fatalf("claimed = %+v, 期望从第 100 字节继续且未完成", got)
+		}
+	})
+
+	t.Run("in-memory offset of renamed file is not complete", func(t *testing.T) {
+		if err := os.WriteFile(current, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		states := newTestStateStore(t, nil, nil)
+		r := newTestLogReader(t, states)
+		r.files[current] = &fileState{fileOffset: identify(t, current, 300, true)}
+		if err := os.Rename(current, rotated); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(rotated)
+		if got := claimFile(t, r, rotated); got.Offset != 300 || got.Complete {
+			t.Fatalf("claimed = %+v, 期望从第 300 字节继续且未完成", got)
+		}
+	})
+
+	t.Run("completed archive is adopted", func(t *testing.T) {
+		if err := os.WriteFile(current, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		previous := identify(t, current, int64(len(content)), true)
+		previous.Epoch = 3
+		states := newTestStateStore(t, nil, []batchOffset{{Path: current, fileOffset: previous}})
+		r := newTestLogReader(t, states)
+
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write([]byte(content))
+		writer.Close()
+		archive := filepath.Join(dir, "access.log.2.gz")
+		if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(current); err != nil {
+			t.Fatal(err)
+		}
+
+		got := claimFile(t, r, archive)
+		if got.Offset != int64(len(content)) || !got.Complete {
+			t.Fatalf("claimed = %+v, 期望压缩文件已全部读取", got)
+		}
+		if saved, ok := states.get(archive); !ok || !saved.Complete {
+			t.Fatalf("压缩文件应直接记录为已完成: %+v", saved)
+		}
+		if _, rotated := states.records(archive); len(rotated) != 0 {
+			t.Fatalf("rotated = %+v, 认领后不应保留暂存的旧位置", rotated)
+		}
+	})
+
+	t.Run("unrelated file is not claimed", func(t *testing.T) {
+		other := filepath.Join(dir, "other.log")
+		if err := os.WriteFile(other, []byte("other content\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(current, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		previous := identify(t, current, 100, false)
+		os.Remove(current)
+		states := newTestStateStore(t, nil, []batchOffset{{Path: current, fileOffset: previous}})
+		r := newTestLogReader(t, states)
+		if got := claimFile(t, r, other); got.Offset != 0 || got.Complete {
+			t.Fatalf("claimed = %+v, 不相关的文件不应被认领", got)
+		}
+	})
+}
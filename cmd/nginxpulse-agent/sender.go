@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/sirupsen/logrus"
+)
+
+const retryBaseInterval = time.Second
+
+type ingestRequest struct {
+	WebsiteID string   `json:"website_id"`
+	SourceID  string   `json:"source_id"`
+	Lines     []string `json:"lines"`
+}
+
+type recordsRequest struct {
+	WebsiteID string         `json:"website_id"`
+	SourceID  string         `json:"source_id"`
+	Records   []parse.Record `json:"records"`
+	Lines     []string       `json:"lines,omitempty"`
+}
+
+// pushError 服务端返回的非 2xx 状态
+type pushError struct {
+	status     int
+	retryAfter time.Duration // 服务端通过 Retry-After 建议的等待时间
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("http status %d", e.status)
+}
+
+// retryable 参数错误、站点不存在等客户端错误重试也不会成功；
+// 超时、限流、认证失败（修正密钥后可恢复）与服务端错误需要重试
+func (e *pushError) retryable() bool {
+	switch e.status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return e.status >= 500
+}
+
+// batchSender 按顺序推送磁盘队列中的批次，服务端确认后才持久化读取位置并删除批次
+type batchSender struct {
+	endpoint  string // 推送日志行
+	records   string // 推送本地解析的记录
+	accessKey string
+	fallback  agentInput // 旧版本写入的批次没有记录站点与来源，推送到第一个输入
+	states    *stateStore
+	queue     *spool
+	retryMax  time.Duration
+	failures  *lastError
+	// compression 请求体的压缩方式，为空时不压缩；服务端声明支持后才使用
+	compression string
+	encodings   *encodingSupport
+}
+
+// encodingSupport 服务端在注册响应中声明支持的请求体压缩方式。注册成功前，
+// 以及不支持 agent 注册或未声明压缩方式的旧版本服务端，推送时都不压缩
+type encodingSupport struct {
+	mu        sync.Mutex
+	supported map[string]bool
+}
+
+func (e *encodingSupport) set(encodings []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.supported = make(map[string]bool, len(encodings))
+	for _, encoding := range encodings {
+		e.supported[strings.ToLower(strings.TrimSpace(encoding))] = true
+	}
+}
+
+func (e *encodingSupport) allows(encoding string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.supported[encoding]
+}
+
+// disable 服务端拒绝该压缩方式（415）后不再使用，直到重新注册时服务端再次声明
+func (e *encodingSupport) disable(encoding string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.supported, encoding)
+}
+
+func (s *batchSender) run(ctx context.Context) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	attempt := 0
+	for {
+		batch := s.queue.peek()
+		if batch == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.queue.notify:
+			}
+			continue
+		}
+
+		websiteID, sourceID := batch.WebsiteID, batch.SourceID
+		if websiteID == "" {
+			websiteID, sourceID = s.fallback.WebsiteID, s.fallback.SourceID
+		}
+		var err error
+		if len(batch.Lines) > 0 || len(batch.Records) > 0 {
+			compression := ""
+			if s.compression != "" && s.encodings.allows(s.compression) {
+				compression = s.compression
+			}
+			err = s.push(ctx, client, compression, websiteID, sourceID, batch)
+			if compression != "" && unsupportedEncoding(err) {
+				logrus.Warnf("服务端拒绝了 %s 压缩的请求体，改为不压缩推送", compression)
+				s.encodings.disable(compression)
+				err = s.push(ctx, client, "", websiteID, sourceID, batch)
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			s.failures.set(err)
+		}
+		var pushErr *pushError
+		// 旧版本服务端没有记录推送接口，保留批次等待服务端升级
+		unsupported := len(batch.Records) > 0 && errors.As(err, &pushErr) && pushErr.status == http.StatusNotFound
+		if unsupported {
+			err = fmt.Errorf("服务端不支持本地解析的记录，请升级服务端: %w", err)
+		}
+		if err != nil && (unsupported || !errors.As(err, &pushErr) || pushErr.retryable()) {
+			delay := retryDelay(attempt, s.retryMax)
+			if pushErr != nil && pushErr.retryAfter > delay {
+				delay = pushErr.retryAfter
+			}
+			attempt++
+			logrus.WithError(err).Warnf("日志推送失败，%s 后重试（队列中 %d 批）", delay.Round(time.Millisecond), s.queue.len())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("服务端拒绝了站点 %s 的 %d 行日志，已丢弃该批次", websiteID, len(batch.Lines)+len(batch.Records))
+		}
+		attempt = 0
+
+		// 先持久化位置再删除批次，中途退出时重启后由 dropCommitted 清理
+		if err := s.states.commit(batch.Offsets); err != nil {
+			logrus.WithError(err).Warn("保存读取位置失败")
+		}
+		if err := s.queue.remove(batch.seq); err != nil {
+			logrus.WithError(err).Warnf("删除磁盘队列批次 %d 失败", batch.seq)
+		}
+	}
+}
+
+// retryDelay 指数退避，取 [d/2, d] 之间的随机值，避免多个 agent 同时重试
+func retryDelay(attempt int, max time.Duration) time.Duration {
+	delay := max
+	if attempt < 16 {
+		if next := retryBaseInterval << attempt; next < max {
+			delay = next
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// push 推送一个批次，包含本地解析的记录时推送到记录接口
+func (s *batchSender) push(ctx context.Context, client *http.Client, compression, websiteID, sourceID string, batch *spoolBatch) error {
+	if len(batch.Records) == 0 {
+		return pushLines(ctx, client, s.endpoint, s.accessKey, compression, websiteID, sourceID, batch.Lines)
+	}
+	payload := recordsRequest{
+		WebsiteID: websiteID,
+		SourceID:  sourceID,
+		Records:   batch.Records,
+		Lines:     batch.Lines,
+	}
+	return postPayload(ctx, client, s.records, s.accessKey, compression, payload)
+}
+
+func pushLines(ctx context.Context, client *http.Client, endpoint, accessKey, compression, websiteID, sourceID string, lines []string) error {
+	payload := ingestRequest{
+		WebsiteID: websiteID,
+		SourceID:  sourceID,
+		Lines:     lines,
+	}
+	return postPayload(ctx, client, endpoint, accessKey, compression, payload)
+}
+
+// unsupportedEncoding 服务端不支持请求体的 Content-Encoding（415）。
+// 其他 4xx 与压缩无关，不压缩重试也不会成功
+func unsupportedEncoding(err error) bool {
+	var pushErr *pushError
+	return errors.As(err, &pushErr) && pushErr.status == http.StatusUnsupportedMediaType
+}
+
+// postJSON 以 JSON 发送请求，非 2xx 状态返回 pushError；out 不为空时解析响应
+func postJSON(ctx context.Context, client *http.Client, endpoint, accessKey string, payload, out interface{}) error {
+	return sendPayload(ctx, client, endpoint, accessKey, "", payload, out)
+}
+
+// postPayload 以 JSON 发送请求，compression 不为空时按其压缩请求体
+func postPayload(ctx context.Context, client *http.Client, endpoint, accessKey, compression string, payload interface{}) error {
+	return sendPayload(ctx, client, endpoint, accessKey, compression, payload, nil)
+}
+
+func sendPayload(ctx context.Context, client *http.Client, endpoint, accessKey, compression string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if body, err = compressBody(body, compression); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if compression != "" {
+		req.Header.Set("Content-Encoding", compression)
+	}
+	if strings.TrimSpace(accessKey) != "" {
+		req.Header.Set("X-NginxPulse-Key", strings.TrimSpace(accessKey))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &pushError{status: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func compressBody(body []byte, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch compression {
+	case "":
+		return body, nil
+	case compressionGzip:
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case compressionZstd:
+		writer, err := zstd.NewWriter(&buf, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(body); err != nil {
+			writer.Close()
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的压缩方式: %s", compression)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseRetryAfter 解析秒数或 HTTP 日期格式的 Retry-After
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/sirupsen/logrus"
+)
+
+const spoolFileSuffix = ".json"
+
+var errSpoolFull = errors.New("磁盘队列已满")
+
+// spoolBatch 待推送的一批日志及其覆盖的文件位置。本地解析的输入中，
+// Records 为解析后的记录，Lines 为解析失败的行；两者都为空时只需确认位置
+type spoolBatch struct {
+	WebsiteID string         `json:"websiteID,omitempty"`
+	SourceID  string         `json:"sourceID,omitempty"`
+	Lines     []string       `json:"lines"`
+	Records   []parse.Record `json:"records,omitempty"`
+	Offsets   []batchOffset  `json:"offsets"`
+
+	seq uint64
+}
+
+// spoolEntry 内存中只保留批次的元数据，日志内容在推送时再从磁盘读取
+type spoolEntry struct {
+	seq     uint64
+	size    int64
+	offsets []batchOffset
+}
+
+// spool 磁盘队列：每个批次一个文件，按序号先进先出，总大小超过 maxBytes 时拒绝写入
+type spool struct {
+	dir      string
+	maxBytes int64
+	notify   chan struct{}
+
+	mu      sync.Mutex
+	entries []spoolEntry
+	size    int64
+	nextSeq uint64
+}
+
+// openSpool 打开磁盘队列并载入上次未推送的批次，无法解析的文件直接删除
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes, notify: make(chan struct{}, 1), nextSeq: 1}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+spoolFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), spoolFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		batch, size, err := readSpoolFile(name)
+		if err != nil {
+			logrus.WithError(err).Warnf("磁盘队列文件损坏，已删除: %s", name)
+			os.Remove(name)
+			continue
+		}
+		s.entries = append(s.entries, spoolEntry{seq: seq, size: size, offsets: batch.Offsets})
+		s.size += size
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	return s, nil
+}
+
+func readSpoolFile(name string) (*spoolBatch, int64, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	batch := &spoolBatch{}
+	if err := json.Unmarshal(data, batch); err != nil {
+		return nil, 0, err
+	}
+	return batch, int64(len(data)), nil
+}
+
+func (s *spool) fileName(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolFileSuffix))
+}
+
+// append 写入一个批次。队列为空时总能写入，避免单个批次超过上限后永远无法发送。
+func (s *spool) append(websiteID, sourceID string, lines []string, records []parse.Record, offsets []batchOffset) error {
+	data, err := json.Marshal(spoolBatch{
+		WebsiteID: websiteID,
+		SourceID:  sourceID,
+		Lines:     lines,
+		Records:   records,
+		Offsets:   offsets,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if len(s.entries) > 0 && s.size+int64(len(data)) > s.maxBytes {
+		s.mu.Unlock()
+		return errSpoolFull
+	}
+	seq := s.nextSeq
+	s.nextSeq++
+	s.mu.Unlock()
+
+	name := s.fileName(seq)
+	tmpPath := name + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.entries = append(s.entries, spoolEntry{seq: seq, size: int64(len(data)), offsets: offsets})
+	s.size += int64(len(data))
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// full 队列已达上限，读取端暂停读取新日志
+func (s *spool) full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries) > 0 && s.size >= s.maxBytes
+}
+
+// drained 队列已消化到上限的一半以下，暂停的读取端可以继续读取
+func (s *spool) drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size <= s.maxBytes/2
+}
+
+// peek 读取最早的批次，队列为空时返回 nil。无法读取的批次删除后继续读取下一个。
+func (s *spool) peek() *spoolBatch {
+	for {
+		s.mu.Lock()
+		if len(s.entries) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		seq := s.entries[0].seq
+		s.mu.Unlock()
+
+		batch, _, err := readSpoolFile(s.fileName(seq))
+		if err == nil {
+			batch.seq = seq
+			return batch
+		}
+		logrus.WithError(err).Errorf("读取磁盘队列批次 %d 失败，已删除", seq)
+		if err := s.remove(seq); err != nil {
+			logrus.WithError(err).Warnf("删除磁盘队列批次 %d 失败", seq)
+			return nil
+		}
+	}
+}
+
+// remove 删除已确认的批次
+func (s *spool) remove(seq uint64) error {
+	s.mu.Lock()
+	for i, entry := range s.entries {
+		if entry.seq == seq {
+			s.size -= entry.size
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	if err := os.Remove(s.fileName(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// dropCommitted 删除位置已全部确认的批次：确认位置后、删除批次前退出时会留下这类批次
+func (s *spool) dropCommitted(states *stateStore) {
+	s.mu.Lock()
+	var committed []uint64
+	for _, entry := range s.entries {
+		if states.covers(entry.offsets) {
+			committed = append(committed, entry.seq)
+		}
+	}
+	s.mu.Unlock()
+	for _, seq := range committed {
+		if err := s.remove(seq); err != nil {
+			logrus.WithError(err).Warnf("删除已确认的磁盘队列批次 %d 失败", seq)
+		}
+	}
+}
+
+// queuedOffset 返回队列中某个文件在指定 Epoch 下已读到的最大位置，重启后从这里继续读取
+func (s *spool) queuedOffset(path string, epoch int64) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		offset int64
+		found  bool
+	)
+	for _, entry := range s.entries {
+		for _, item := range entry.offsets {
+			if item.Path == path && item.Epoch == epoch && item.Offset > offset {
+				offset, found = item.Offset, true
+			}
+		}
+	}
+	return offset, found
+}
+
+// stats 返回队列中的批次数与总大小
+func (s *spool) stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries), s.size
+}
+
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// maxRotatedFiles 暂存的轮转文件位置上限，按序号改名的压缩文件较多时一次轮询会暂存多条
+const maxRotatedFiles = 128
+
+// fileOffset 文件的读取位置。文件被截断或轮转后 Epoch 递增，
+// 旧 Epoch 的批次确认后不会覆盖新文件的位置。
+type fileOffset struct {
+	Dev            uint64 `json:"dev"`
+	Inode          uint64 `json:"inode"`
+	Fingerprint    string `json:"fingerprint,omitempty"` // 文件开头 FingerprintLen 字节（压缩文件为解压后内容）的 sha1
+	FingerprintLen int64  `json:"fingerprintLen,omitempty"`
+	Epoch          int64  `json:"epoch"`
+	Offset         int64  `json:"offset"`             // 压缩文件为解压后的位置
+	Complete       bool   `json:"complete,omitempty"` // 压缩文件已全部读取
+}
+
+// batchOffset 批次中某个文件读到的位置
+type batchOffset struct {
+	Path string `json:"path"`
+	fileOffset
+}
+
+// sameFile 判断路径上的当前文件是否仍是记录中的文件：
+// 设备号+inode 变化（create 方式轮转），或开头内容与指纹不一致（copytruncate 后重新写入）都视为新文件
+func (o fileOffset) sameFile(dev, inode uint64, head *headFingerprint) bool {
+	if o.Inode != 0 && inode != 0 && (o.Dev != dev || o.Inode != inode) {
+		return false
+	}
+	if o.Fingerprint != "" {
+		fingerprint, ok := head.sum(o.FingerprintLen)
+		return ok && fingerprint == o.Fingerprint
+	}
+	return true
+}
+
+// claims 判断记录中的文件是否就是给定文件（改名后 inode 不变，压缩后开头内容不变）。
+// 有指纹时以指纹为准，避免删除后被复用的 inode 误认。
+func (o fileOffset) claims(dev, inode uint64, head *headFingerprint) bool {
+	if o.Fingerprint != "" {
+		fingerprint, ok := head.sum(o.FingerprintLen)
+		return ok && fingerprint == o.Fingerprint
+	}
+	return o.Inode != 0 && o.Dev == dev && o.Inode == inode
+}
+
+// sameIdentity 两条记录是否为同一文件
+func (o fileOffset) sameIdentity(other fileOffset) bool {
+	if o.Fingerprint != "" || other.Fingerprint != "" {
+		return o.Fingerprint == other.Fingerprint && o.FingerprintLen == other.FingerprintLen
+	}
+	return o.Inode != 0 && o.Dev == other.Dev && o.Inode == other.Inode
+}
+
+// refresh 记录当前文件的身份，指纹随文件增长覆盖到 fingerprintSize 字节为止
+func (o *fileOffset) refresh(dev, inode uint64, head *headFingerprint) {
+	if inode != 0 {
+		o.Dev, o.Inode = dev, inode
+	}
+	if o.FingerprintLen >= fingerprintSize {
+		return
+	}
+	length := head.available()
+	if length <= o.FingerprintLen {
+		return
+	}
+	if fingerprint, ok := head.sum(length); ok {
+		o.Fingerprint = fingerprint
+		o.FingerprintLen = length
+	}
+}
+
+// stateStore 持久化服务端已确认的读取位置，写入时先写临时文件再改名
+type stateStore struct {
+	path string
+
+	mu        sync.Mutex
+	committed map[string]fileOffset
+	rotated   []batchOffset // 已被轮转或删除的文件，等改名、压缩后的文件出现时认领
+}
+
+type stateFile struct {
+	Files   map[string]fileOffset `json:"files"`
+	Rotated []batchOffset         `json:"rotated,omitempty"`
+}
+
+func loadState(path string) (*stateStore, error) {
+	store := &stateStore{path: path, committed: make(map[string]fileOffset)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved stateFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	for file, offset := range saved.Files {
+		store.committed[file] = offset
+	}
+	store.rotated = saved.Rotated
+	return store, nil
+}
+
+func (s *stateStore) get(path string) (fileOffset, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok := s.committed[path]
+	return offset, ok
+}
+
+// nextEpoch 新文件的 Epoch，需大于该路径上所有旧文件的 Epoch
+func (s *stateStore) nextEpoch(path string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextEpochLocked(path)
+}
+
+func (s *stateStore) nextEpochLocked(path string) int64 {
+	epoch := int64(-1)
+	if current, ok := s.committed[path]; ok {
+		epoch = current.Epoch
+	}
+	for _, record := range s.rotated {
+		if record.Path == path && record.Epoch > epoch {
+			epoch = record.Epoch
+		}
+	}
+	return epoch + 1
+}
+
+// reset 文件被截断或轮转后从头读取新文件，旧文件的位置暂存起来
+func (s *stateStore) reset(path string, previous fileOffset) (fileOffset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retireLocked(path, previous)
+	offset := fileOffset{Epoch: s.nextEpochLocked(path)}
+	s.committed[path] = offset
+	return offset, s.saveLocked()
+}
+
+// forget 文件已不在原路径（被改名、压缩或删除），位置暂存起来
+func (s *stateStore) forget(path string, previous fileOffset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retireLocked(path, previous)
+	delete(s.committed, path)
+	return s.saveLocked()
+}
+
+// retireLocked 暂存旧文件的身份与已确认的位置，队列中旧文件的批次确认后仍会推进该位置
+func (s *stateStore) retireLocked(path string, previous fileOffset) {
+	if previous.Inode == 0 && previous.Fingerprint == "" {
+		return
+	}
+	record := batchOffset{Path: path, fileOffset: previous}
+	record.Offset, record.Complete = 0, false
+	if current, ok := s.committed[path]; ok && current.Epoch == previous.Epoch {
+		record.Offset, record.Complete = current.Offset, current.Complete
+	}
+	if record.Complete {
+		// 已被其他路径认领的压缩文件不必暂存
+		for file, offset := range s.committed {
+			if file != path && offset.Complete && offset.sameIdentity(record.fileOffset) {
+				return
+			}
+		}
+	}
+	s.rotated = append(s.rotated, record)
+	if len(s.rotated) > maxRotatedFiles {
+		s.rotated = s.rotated[len(s.rotated)-maxRotatedFiles:]
+	}
+}
+
+// paths 返回所有已记录位置的文件
+func (s *stateStore) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.committed))
+	for path := range s.committed {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// records 返回其他路径上已确认的位置与暂存的轮转文件位置，用于认领新出现的文件
+func (s *stateStore) records(path string) (files []batchOffset, rotated []batchOffset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for file, offset := range s.committed {
+		if file != path {
+			files = append(files, batchOffset{Path: file, fileOffset: offset})
+		}
+	}
+	return files, append([]batchOffset(nil), s.rotated...)
+}
+
+// adopt 新路径认领了已全部读取的压缩文件，直接记录为已完成，不再保留暂存的旧位置
+func (s *stateStore) adopt(path string, offset fileOffset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed[path] = offset
+	kept := s.rotated[:0]
+	for _, record := range s.rotated {
+		if record.Complete && record.sameIdentity(offset) {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	s.rotated = kept
+	return s.saveLocked()
+}
+
+// commit 服务端确认批次后推进读取位置
+func (s *stateStore) commit(offsets []batchOffset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, item := range offsets {
+		if record := s.rotatedLocked(item.Path, item.Epoch); record != nil {
+			if item.Offset > record.Offset || item.Complete && !record.Complete {
+				record.Offset, record.Complete = item.Offset, item.Complete
+				changed = true
+			}
+			continue
+		}
+		current, ok := s.committed[item.Path]
+		if ok && (item.Epoch != current.Epoch || item.Offset < current.Offset ||
+			item.Offset == current.Offset && (current.Complete || !item.Complete)) {
+			continue
+		}
+		s.committed[item.Path] = item.fileOffset
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+func (s *stateStore) rotatedLocked(path string, epoch int64) *batchOffset {
+	for i := range s.rotated {
+		if s.rotated[i].Path == path && s.rotated[i].Epoch == epoch {
+			return &s.rotated[i]
+		}
+	}
+	return nil
+}
+
+// covers 批次中的所有位置都已确认
+func (s *stateStore) covers(offsets []batchOffset) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range offsets {
+		current, ok := s.committed[item.Path]
+		if record := s.rotatedLocked(item.Path, item.Epoch); record != nil {
+			current, ok = record.fileOffset, true
+		}
+		if !ok || current.Epoch != item.Epoch || current.Offset < item.Offset {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *stateStore) saveLocked() error {
+	payload, err := json.MarshalIndent(stateFile{Files: s.committed, Rotated: s.rotated}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, payload); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// writeFileSync 写入并落盘，避免断电后改名得到空文件
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestStateStore(t *testing.T, committed map[string]fileOffset, rotated []batchOffset) *stateStore {
+	t.Helper()
+	store, err := loadState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, offset := range committed {
+		store.committed[path] = offset
+	}
+	store.rotated = append(store.rotated, rotated...)
+	if err := store.saveLocked(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStateStoreCommit(t *testing.T) {
+	const path = "/var/log/nginx/access.log"
+	current := fileOffset{Inode: 1, Epoch: 2, Offset: 100}
+
+	tests := []struct {
+		name    string
+		rotated []batchOffset
+		commit  batchOffset
+		want    fileOffset
+		wantOld *fileOffset // 暂存的轮转文件提交后的位置
+	}{
+		{
+			name:   "advance",
+			commit: batchOffset{Path: path, fileOffset: fileOffset{Inode: 1, Epoch: 2, Offset: 200}},
+			want:   fileOffset{Inode: 1, Epoch: 2, Offset: 200},
+		},
+		{
+			name:   "smaller offset ignored",
+			commit: batchOffset{Path: path, fileOffset: fileOffset{Inode: 1, Epoch: 2, Offset: 50}},
+			want:   current,
+		},
+		{
+			name:   "stale epoch ignored",
+			commit: batchOffset{Path: path, fileOffset: fileOffset{Inode: 9, Epoch: 1, Offset: 500}},
+			want:   current,
+		},
+		{
+			name:   "same offset marks complete",
+			commit: batchOffset{Path: path, fileOffset: fileOffset{Inode: 1, Epoch: 2, Offset: 100, Complete: true}},
+			want:   fileOffset{Inode: 1, Epoch: 2, Offset: 100, Complete: true},
+		},
+		{
+			name:    "rotated file advanced",
+			rotated: []batchOffset{{Path: path, fileOffset: fileOffset{Inode: 7, Epoch: 1, Offset: 300}}},
+			commit:  batchOffset{Path: path, fileOffset: fileOffset{Inode: 7, Epoch: 1, Offset: 400, Complete: true}},
+			want:    current,
+			wantOld: &fileOffset{Inode: 7, Epoch: 1, Offset: 400, Complete: true},
+		},
+		{
+			name:    "rotated file not moved backwards",
+			rotated: []batchOffset{{Path: path, fileOffset: fileOffset{Inode: 7, Epoch: 1, Offset: 300}}},
+			commit:  batchOffset{Path: path, fileOffset: fileOffset{Inode: 7, Epoch: 1, Offset: 200}},
+			want:    current,
+			wantOld: &fileOffset{Inode: 7, Epoch: 1, Offset: 300},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStateStore(t, map[string]fileOffset{path: current}, tt.rotated)
+			if err := store.commit([]batchOffset{tt.commit}); err != nil {
+				t.Fatalf("commit 返回错误: %v", err)
+			}
+			if got, _ := store.get(path); got != tt.want {
+				t.Fatalf("committed = %+v, want %+v", got, tt.want)
+			}
+			if tt.wantOld != nil {
+				record := store.rotatedLocked(path, tt.wantOld.Epoch)
+				if record == nil || record.fileOffset != *tt.wantOld {
+					t.Fatalf("rotated = %+v, want %+v", record, *tt.wantOld)
+				}
+			}
+
+			// 重新加载后位置不变
+			reloaded, err := loadState(store.path)
+			if err != nil {
+				t.Fatalf("加载读取位置失败: %v", err)
+			}
+			if got, _ := reloaded.get(path); got != tt.want {
+				t.Fatalf("reloaded = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateStoreCommitNewPath(t *testing.T) {
+	store := newTestStateStore(t, nil, nil)
+	item := batchOffset{Path: "/var/log/nginx/new.log", fileOffset: fileOffset{Inode: 3, Offset: 10}}
+	if err := store.commit([]batchOffset{item}); err != nil {
+		t.Fatalf("commit 返回错误: %v", err)
+	}
+	if got, ok := store.get(item.Path); !ok || got != item.fileOffset {
+		t.Fatalf("committed = %+v, want %+v", got, item.fileOffset)
+	}
+}
+
+func TestStateStoreCovers(t *testing.T) {
+	const path = "/var/log/nginx/access.log"
+	store := newTestStateStore(t,
+		map[string]fileOffset{path: {Inode: 1, Epoch: 2, Offset: 100}},
+		[]batchOffset{{Path: path, fileOffset: fileOffset{Inode: 7, Epoch: 1, Offset: 300}}},
+	)
+
+	tests := []struct {
+		name    string
+		offsets []batchOffset
+		want    bool
+	}{
+		{name: "empty", want: true},
+		{name: "committed", offsets: []batchOffset{{Path: path, fileOffset: fileOffset{Epoch: 2, Offset: 100}}}, want: true},
+		{name: "beyond committed", offsets: []batchOffset{{Path: path, fileOffset: fileOffset{Epoch: 2, Offset: 101}}}},
+		{name: "rotated", offsets: []batchOffset{{Path: path, fileOffset: fileOffset{Epoch: 1, Offset: 300}}}, want: true},
+		{name: "beyond rotated", offsets: []batchOffset{{Path: path, fileOffset: fileOffset{Epoch: 1, Offset: 301}}}},
+		{name: "unknown epoch", offsets: []batchOffset{{Path: path, fileOffset: fileOffset{Epoch: 5, Offset: 1}}}},
+		{name: "unknown path", offsets: []batchOffset{{Path: "/var/log/nginx/other.log", fileOffset: fileOffset{Offset: 1}}}},
+		{
+			name: "one of many not committed",
+			offsets: []batchOffset{
+				{Path: path, fileOffset: fileOffset{Epoch: 2, Offset: 50}},
+				{Path: path, fileOffset: fileOffset{Epoch: 1, Offset: 400}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := store.covers(tt.offsets); got != tt.want {
+				t.Fatalf("covers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
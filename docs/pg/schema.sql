@@ -67,6 +67,8 @@ CREATE TABLE IF NOT EXISTS "{{website_id}}_nginx_logs" (
   referer_id BIGINT NOT NULL,
   ua_id BIGINT NOT NULL,
   location_id BIGINT NOT NULL,
+  request_time REAL,
+  upstream_time REAL,
   PRIMARY KEY (id, timestamp)
 ) PARTITION BY RANGE (timestamp);
 
@@ -93,7 +95,20 @@ CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_hourly" (
   s3xx BIGINT NOT NULL DEFAULT 0,
   s4xx BIGINT NOT NULL DEFAULT 0,
   s5xx BIGINT NOT NULL DEFAULT 0,
-  other BIGINT NOT NULL DEFAULT 0
+  other BIGINT NOT NULL DEFAULT 0,
+  latency_count BIGINT NOT NULL DEFAULT 0,
+  latency_sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+  latency_max REAL NOT NULL DEFAULT 0,
+  upstream_count BIGINT NOT NULL DEFAULT 0,
+  upstream_sum DOUBLE PRECISION NOT NULL DEFAULT 0
+);
+
+-- Latency histogram per hour (bin = width_bucket(ms, bounds))
+CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_hourly_latency" (
+  bucket BIGINT NOT NULL,
+  bin SMALLINT NOT NULL,
+  hits BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (bucket, bin)
 );
 
 CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_hourly_ip" (
@@ -158,6 +173,17 @@ CREATE TABLE IF NOT EXISTS "{{website_id}}_agg_entry_daily" (
   PRIMARY KEY (day, entry_url_id)
 );
 
+-- Quarantined lines that failed to parse (trimmed to the newest 10000 rows)
+CREATE TABLE IF NOT EXISTS "{{website_id}}_ingest_rejects" (
+  id BIGSERIAL PRIMARY KEY,
+  created_at BIGINT NOT NULL,
+  source_id TEXT NOT NULL DEFAULT '',
+  target TEXT NOT NULL DEFAULT '',
+  line_offset BIGINT NOT NULL DEFAULT -1,
+  reason TEXT NOT NULL,
+  line TEXT NOT NULL
+);
+
 -- Indexes (create on the partitioned parent; partitions inherit)
 CREATE INDEX IF NOT EXISTS "idx_{{website_id}}_timestamp"
   ON "{{website_id}}_nginx_logs"(timestamp);
@@ -175,3 +201,6 @@ CREATE INDEX IF NOT EXISTS "idx_{{website_id}}_sessions_start"
 
 CREATE INDEX IF NOT EXISTS "idx_{{website_id}}_sessions_key"
   ON "{{website_id}}_sessions"(ip_id, ua_id, end_ts);
+
+CREATE INDEX IF NOT EXISTS "idx_{{website_id}}_ingest_rejects_source"
+  ON "{{website_id}}_ingest_rejects"(source_id, id);
@@ -10,11 +10,15 @@ require (
 	github.com/gin-contrib/cors v1.7.4
 	github.com/gin-gonic/gin v1.10.0
 	github.com/jackc/pgx/v5 v5.8.0
+	github.com/klauspost/compress v1.17.11
 	github.com/lionsoul2014/ip2region/binding/golang v0.0.0-20260121081438-f2c988287c27
 	github.com/mileusna/useragent v1.3.5
 	github.com/pkg/sftp v1.13.6
 	github.com/sirupsen/logrus v1.9.3
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/crypto v0.36.0
+	golang.org/x/sys v0.31.0
+	google.golang.org/protobuf v1.36.1
 )
 
 require (
@@ -61,8 +65,6 @@ require (
 	golang.org/x/arch v0.12.0 // indirect
 	golang.org/x/net v0.37.0 // indirect
 	golang.org/x/sync v0.17.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.29.0 // indirect
-	google.golang.org/protobuf v1.36.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
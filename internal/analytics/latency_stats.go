@@ -0,0 +1,277 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/likaia/nginxpulse/internal/timeutil"
+)
+
+// SlowURLStat 慢请求 URL 统计（耗时单位：毫秒）
+type SlowURLStat struct {
+	URL      string  `json:"url"`
+	Requests int     `json:"requests"`
+	Avg      float64 `json:"avg"`
+	P90      float64 `json:"p90"`
+	Max      float64 `json:"max"`
+}
+
+// LatencyStats 请求延迟统计（耗时单位：毫秒）
+type LatencyStats struct {
+	Labels      []string      `json:"labels"`
+	Requests    []int64       `json:"requests"` // 记录了耗时的请求数
+	Avg         []float64     `json:"avg"`
+	P50         []float64     `json:"p50"`
+	P90         []float64     `json:"p90"`
+	P99         []float64     `json:"p99"`
+	SlowestURLs []SlowURLStat `json:"slowestUrls"`
+}
+
+// LatencyStats 实现 StatsResult 接口
+func (s LatencyStats) GetType() string {
+	return "latency"
+}
+
+type LatencyStatsManager struct {
+	repo *store.Repository
+}
+
+// NewLatencyStatsManager 创建一个新的 LatencyStatsManager 实例
+func NewLatencyStatsManager(userRepoPtr *store.Repository) *LatencyStatsManager {
+	return &LatencyStatsManager{
+		repo: userRepoPtr,
+	}
+}
+
+type latencyPoint struct {
+	hist  []int64
+	count int64
+	sum   float64
+	max   float64
+}
+
+// 实现 StatsManager 接口
+func (s *LatencyStatsManager) Query(query StatsQuery) (StatsResult, error) {
+	timeRange := query.ExtraParam["timeRange"].(string)
+	viewType := query.ExtraParam["viewType"].(string)
+	limit, _ := query.ExtraParam["limit"].(int)
+
+	timePoints, labels := timeutil.TimePointsAndLabels(timeRange, viewType)
+	size := len(timePoints)
+	result := LatencyStats{
+		Labels:      labels,
+		Requests:    make([]int64, size),
+		Avg:         make([]float64, size),
+		P50:         make([]float64, size),
+		P90:         make([]float64, size),
+		P99:         make([]float64, size),
+		SlowestURLs: make([]SlowURLStat, 0),
+	}
+	if size == 0 {
+		return result, nil
+	}
+
+	points, err := s.latencyByTimePoints(query.WebsiteID, timePoints, viewType)
+	if err != nil {
+		return result, fmt.Errorf("获取延迟分布失败: %v", err)
+	}
+	for i, point := range points {
+		result.Requests[i] = point.count
+		if point.count == 0 {
+			continue
+		}
+		result.Avg[i] = roundLatency(point.sum / float64(point.count))
+		result.P50[i] = histogramQuantile(point.hist, point.count, 0.50, point.max)
+		result.P90[i] = histogramQuantile(point.hist, point.count, 0.90, point.max)
+		result.P99[i] = histogramQuantile(point.hist, point.count, 0.99, point.max)
+	}
+
+	startTime, endTime, err := timeutil.TimePeriod(timeRange)
+	if err != nil {
+		return result, err
+	}
+	slowest, err := s.slowestURLs(query.WebsiteID, startTime, endTime, limit)
+	if err != nil {
+		return result, fmt.Errorf("查询慢请求 URL 失败: %v", err)
+	}
+	result.SlowestURLs = slowest
+
+	return result, nil
+}
+
+// latencyByTimePoints 读取小时延迟直方图并按时间点（小时或天）合并
+func (s *LatencyStatsManager) latencyByTimePoints(
+	websiteID string, timePoints []time.Time, viewType string) ([]latencyPoint, error) {
+
+	binCount := len(store.LatencyBinBounds()) + 1
+	points := make([]latencyPoint, len(timePoints))
+	for i := range points {
+		points[i].hist = make([]int64, binCount)
+	}
+
+	hourly := viewType == "hourly"
+	index := make(map[string]int, len(timePoints))
+	keyOf := func(bucket int64) string {
+		if hourly {
+			return strconv.FormatInt(bucket, 10)
+		}
+		return dayBucket(time.Unix(bucket, 0))
+	}
+	for i, point := range timePoints {
+		if hourly {
+			index[keyOf(hourBucket(point))] = i
+		} else {
+			index[dayBucket(point)] = i
+		}
+	}
+
+	startBucket := hourBucket(timePoints[0])
+	last := timePoints[len(timePoints)-1]
+	endBucket := hourBucket(last) + 3600
+	if !hourly {
+		local := last.In(time.Local)
+		endBucket = time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, time.Local).Unix()
+	}
+
+	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT bucket, latency_count, latency_sum, latency_max
+         FROM "%s_agg_hourly"
+         WHERE bucket >= ? AND bucket < ? AND latency_count > 0`,
+		websiteID,
+	)), startBucket, endBucket)
+	if err != nil {
+		return points, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			bucket int64
+			count  int64
+			sum    float64
+			max    float64
+		)
+		if err := rows.Scan(&bucket, &count, &sum, &max); err != nil {
+			return points, err
+		}
+		if idx, ok := index[keyOf(bucket)]; ok {
+			points[idx].count += count
+			points[idx].sum += sum
+			if max > points[idx].max {
+				points[idx].max = max
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return points, err
+	}
+
+	histRows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT bucket, bin, hits FROM "%s_agg_hourly_latency" WHERE bucket >= ? AND bucket < ?`,
+		websiteID,
+	)), startBucket, endBucket)
+	if err != nil {
+		return points, err
+	}
+	defer histRows.Close()
+	for histRows.Next() {
+		var (
+			bucket int64
+			bin    int
+			hits   int64
+		)
+		if err := histRows.Scan(&bucket, &bin, &hits); err != nil {
+			return points, err
+		}
+		if bin < 0 || bin >= binCount {
+			continue
+		}
+		if idx, ok := index[keyOf(bucket)]; ok {
+			points[idx].hist[bin] += hits
+		}
+	}
+	if err := histRows.Err(); err != nil {
+		return points, err
+	}
+
+	return points, nil
+}
+
+// slowestURLs 按 P90 耗时降序返回时间范围内最慢的 URL
+func (s *LatencyStatsManager) slowestURLs(
+	websiteID string, startTime, endTime time.Time, limit int) ([]SlowURLStat, error) {
+
+	results := make([]SlowURLStat, 0)
+	rows, err := s.repo.GetDB().Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
+        SELECT
+            u.url,
+            COUNT(*) AS requests,
+            AVG(COALESCE(l.request_time, l.upstream_time)) AS avg_ms,
+            percentile_cont(0.9) WITHIN GROUP (ORDER BY COALESCE(l.request_time, l.upstream_time)) AS p90_ms,
+            MAX(COALESCE(l.request_time, l.upstream_time)) AS max_ms
+        FROM "%[1]s_nginx_logs" l
+        JOIN "%[1]s_dim_url" u ON u.id = l.url_id
+        WHERE l.timestamp >= ? AND l.timestamp < ?
+            AND COALESCE(l.request_time, l.upstream_time) IS NOT NULL
+        GROUP BY u.url
+        ORDER BY p90_ms DESC, requests DESC
+        LIMIT ?`, websiteID)),
+		startTime.Unix(), endTime.Unix(), limit)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item SlowURLStat
+		if err := rows.Scan(&item.URL, &item.Requests, &item.Avg, &item.P90, &item.Max); err != nil {
+			return results, err
+		}
+		item.Avg = roundLatency(item.Avg)
+		item.P90 = roundLatency(item.P90)
+		item.Max = roundLatency(item.Max)
+		results = append(results, item)
+	}
+	return results, rows.Err()
+}
+
+// histogramQuantile 根据延迟直方图估算分位数，桶内按线性插值；
+// 最后一个桶没有上界，使用该时间段内的最大耗时作为上界
+func histogramQuantile(hist []int64, total int64, q float64, max float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	bounds := store.LatencyBinBounds()
+	rank := q * float64(total)
+	var cumulative int64
+	for bin, hits := range hist {
+		if hits == 0 {
+			continue
+		}
+		if float64(cumulative+hits) < rank {
+			cumulative += hits
+			continue
+		}
+		lower := 0.0
+		if bin > 0 {
+			lower = bounds[bin-1]
+		}
+		upper := max
+		if bin < len(bounds) && bounds[bin] < upper {
+			upper = bounds[bin]
+		}
+		if upper < lower {
+			upper = lower
+		}
+		fraction := (rank - float64(cumulative)) / float64(hits)
+		return roundLatency(lower + (upper-lower)*fraction)
+	}
+	return roundLatency(max)
+}
+
+func roundLatency(value float64) float64 {
+	return math.Round(value*100) / 100
+}
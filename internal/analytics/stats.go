@@ -75,6 +75,7 @@ func (f *StatsFactory) registerDefaultManagers() {
 	f.managers["session"] = NewSessionsStatsManager(f.repo)
 	f.managers["session_summary"] = NewSessionSummaryStatsManager(f.repo)
 	f.managers["realtime"] = NewRealtimeStatsManager(f.repo)
+	f.managers["latency"] = NewLatencyStatsManager(f.repo)
 }
 
 // GetManager 获取指定类型的统计管理器
@@ -199,6 +200,7 @@ func (f *StatsFactory) BuildQueryFromRequest(
 		"session":         {"id": "string", "page": "int", "pageSize": "int"},
 		"session_summary": {"id": "string", "timeRange": "string"},
 		"realtime":        {"id": "string"},
+		"latency":         {"id": "string", "timeRange": "string", "viewType": "string", "limit": "int"},
 	}
 
 	// 检查是否支持的统计类型
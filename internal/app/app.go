@@ -81,6 +81,9 @@ func Run() error {
 	}
 
 	go worker.RunScheduler(ctx, logParser, interval)
+	go logParser.RunSyslogReceivers(ctx)
+	go logParser.RunFluentReceivers(ctx)
+	go logParser.RunStreams(ctx)
 
 	return waitForShutdown(cancel, serverHandle)
 }
@@ -10,6 +10,7 @@ import (
 	"syscall"
 
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
 	"github.com/likaia/nginxpulse/internal/version"
 )
 
@@ -18,6 +19,8 @@ func ProcessCliCommands() bool {
 	// 命令行参数
 	cleanApp := flag.Bool("clean", false, "清理nginxpulse服务、释放端口和删除数据")
 	showVer := flag.Bool("v", false, "显示版本信息")
+	importNginx := flag.String("import-nginx", "", "从 nginx 配置（如 /etc/nginx/nginx.conf）生成站点配置")
+	importOutput := flag.String("import-output", "", "导入结果写入的文件，默认输出到标准输出")
 	flag.Parse()
 
 	// 显示版本信息
@@ -26,6 +29,12 @@ func ProcessCliCommands() bool {
 		return true
 	}
 
+	// 从 nginx 配置导入站点
+	if *importNginx != "" {
+		importNginxConfig(*importNginx, *importOutput)
+		return true
+	}
+
 	// 清理服务
 	if *cleanApp {
 		cleanService()
@@ -102,7 +111,8 @@ func validateConfig() bool {
 		return false
 	}
 	result := config.ValidateConfig(cfg, config.ValidateOptions{
-		CheckPaths: !cfg.System.DemoMode,
+		CheckPaths:   !cfg.System.DemoMode,
+		ExtractsHost: ingest.ParserExtractsHost,
 	})
 	if len(result.Errors) == 0 {
 		return false
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+// importNginxConfig 解析 nginx 配置并输出可直接写入配置文件的 websites 片段
+func importNginxConfig(confPath, output string) {
+	result, err := config.ImportNginxConfig(confPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导入 nginx 配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "警告: %s\n", warning)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Websites []config.WebsiteConfig `json:"websites"`
+	}{Websites: result.Websites}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "生成配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "写入文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("已导入 %d 个站点，写入 %s\n", len(result.Websites), output)
+}
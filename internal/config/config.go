@@ -38,31 +38,41 @@ type WebsiteConfig struct {
 	LogRegex   string         `json:"logRegex,omitempty"`
 	TimeLayout string         `json:"timeLayout,omitempty"`
 	Sources    []SourceConfig `json:"sources,omitempty"`
+	CatchAll   bool           `json:"catchAll,omitempty"` // 共享日志中未匹配任何站点 domains 的记录归入该站点
 }
 
 type SourceConfig struct {
-	ID           string            `json:"id"`
-	Type         string            `json:"type"`
-	Mode         string            `json:"mode,omitempty"`
-	PollInterval string            `json:"pollInterval,omitempty"`
-	Path         string            `json:"path,omitempty"`
-	Pattern      string            `json:"pattern,omitempty"`
-	Compression  string            `json:"compression,omitempty"`
-	Parse        *ParseConfig      `json:"parse,omitempty"`
-	Host         string            `json:"host,omitempty"`
-	Port         int               `json:"port,omitempty"`
-	User         string            `json:"user,omitempty"`
-	Auth         *SourceAuth       `json:"auth,omitempty"`
-	URL          string            `json:"url,omitempty"`
-	Headers      map[string]string `json:"headers,omitempty"`
-	RangePolicy  string            `json:"rangePolicy,omitempty"`
-	Index        *HTTPIndexConfig  `json:"index,omitempty"`
-	Endpoint     string            `json:"endpoint,omitempty"`
-	Region       string            `json:"region,omitempty"`
-	Bucket       string            `json:"bucket,omitempty"`
-	Prefix       string            `json:"prefix,omitempty"`
-	AccessKey    string            `json:"accessKey,omitempty"`
-	SecretKey    string            `json:"secretKey,omitempty"`
+	ID            string            `json:"id"`
+	Type          string            `json:"type"`
+	Mode          string            `json:"mode,omitempty"`
+	PollInterval  string            `json:"pollInterval,omitempty"`
+	Path          string            `json:"path,omitempty"`
+	Pattern       string            `json:"pattern,omitempty"`
+	Compression   string            `json:"compression,omitempty"`
+	Parse         *ParseConfig      `json:"parse,omitempty"`
+	Host          string            `json:"host,omitempty"`
+	Port          int               `json:"port,omitempty"`
+	User          string            `json:"user,omitempty"`
+	Auth          *SourceAuth       `json:"auth,omitempty"`
+	URL           string            `json:"url,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	RangePolicy   string            `json:"rangePolicy,omitempty"`
+	Index         *HTTPIndexConfig  `json:"index,omitempty"`
+	Endpoint      string            `json:"endpoint,omitempty"`
+	Region        string            `json:"region,omitempty"`
+	Bucket        string            `json:"bucket,omitempty"`
+	Prefix        string            `json:"prefix,omitempty"`
+	AccessKey     string            `json:"accessKey,omitempty"`
+	SecretKey     string            `json:"secretKey,omitempty"`
+	Protocol      string            `json:"protocol,omitempty"`      // syslog: udp / tcp / both，默认 udp
+	Tag           string            `json:"tag,omitempty"`           // syslog: 仅接收该 tag（APP-NAME）的消息
+	Hostname      string            `json:"hostname,omitempty"`      // syslog: 仅接收该主机名的消息
+	Container     string            `json:"container,omitempty"`     // docker: 按容器名匹配，支持通配符
+	Labels        map[string]string `json:"labels,omitempty"`        // docker: 按容器标签匹配，值支持通配符
+	Stream        string            `json:"stream,omitempty"`        // docker: stdout / stderr / both，默认 stdout
+	UseDockerTime bool              `json:"useDockerTime,omitempty"` // docker: 使用 Docker 记录的时间作为日志时间
+	RecordKey     string            `json:"recordKey,omitempty"`     // fluent: 日志内容所在的 record 字段，默认 log
+	SharedKey     string            `json:"sharedKey,omitempty"`     // fluent: 共享密钥，留空表示不认证
 }
 
 type SourceAuth struct {
@@ -78,10 +88,11 @@ type HTTPIndexConfig struct {
 }
 
 type ParseConfig struct {
-	LogType    string `json:"logType,omitempty"`
-	LogFormat  string `json:"logFormat,omitempty"`
-	LogRegex   string `json:"logRegex,omitempty"`
-	TimeLayout string `json:"timeLayout,omitempty"`
+	LogType    string            `json:"logType,omitempty"`
+	LogFormat  string            `json:"logFormat,omitempty"`
+	LogRegex   string            `json:"logRegex,omitempty"`
+	TimeLayout string            `json:"timeLayout,omitempty"`
+	FieldMap   map[string]string `json:"fieldMap,omitempty"`
 }
 
 type SystemConfig struct {
@@ -89,6 +100,8 @@ type SystemConfig struct {
 	TaskInterval     string   `json:"taskInterval"` // "5m" "25s"
 	LogRetentionDays int      `json:"logRetentionDays"`
 	ParseBatchSize   int      `json:"parseBatchSize"`
+	NginxConfDir     string   `json:"nginxConfDir"` // 导入接口允许读取的 nginx 配置目录
+	ScanWorkers      int      `json:"scanWorkers"`  // 同时扫描的网站/来源数
 	IPGeoCacheLimit  int      `json:"ipGeoCacheLimit"`
 	IPGeoAPIURL      string   `json:"ipGeoApiUrl"`
 	DemoMode         bool     `json:"demoMode"`
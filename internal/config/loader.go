@@ -16,6 +16,8 @@ const (
 	envTaskInterval      = "TASK_INTERVAL"
 	envLogRetentionDays  = "LOG_RETENTION_DAYS"
 	envLogParseBatchSize = "LOG_PARSE_BATCH_SIZE"
+	envNginxConfDir      = "NGINX_CONF_DIR"
+	envLogScanWorkers    = "LOG_SCAN_WORKERS"
 	envServerPort        = "SERVER_PORT"
 	envPVStatusCodes     = "PV_STATUS_CODES"
 	envPVExcludePatterns = "PV_EXCLUDE_PATTERNS"
@@ -52,6 +54,8 @@ var (
 		TaskInterval:     "1m",
 		LogRetentionDays: 30,
 		ParseBatchSize:   100,
+		NginxConfDir:     "/etc/nginx",
+		ScanWorkers:      4,
 		IPGeoCacheLimit:  1000000,
 		IPGeoAPIURL:      DefaultIPGeoAPIURL,
 		DemoMode:         false,
@@ -169,6 +173,19 @@ func applyEnvOverrides(cfg *Config) error {
 		}
 		cfg.System.ParseBatchSize = parsed
 	}
+	if raw, _ := getEnvValue(envNginxConfDir); raw != "" {
+		cfg.System.NginxConfDir = strings.TrimSpace(raw)
+	}
+	if raw, key := getEnvValue(envLogScanWorkers); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", key, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s 必须大于0", key)
+		}
+		cfg.System.ScanWorkers = parsed
+	}
 	if raw, key := getEnvValue(envIPGeoCacheLimit); raw != "" {
 		parsed, err := strconv.Atoi(raw)
 		if err != nil {
@@ -283,6 +300,12 @@ func applyDefaults(cfg *Config) {
 	if cfg.System.ParseBatchSize <= 0 {
 		cfg.System.ParseBatchSize = defaultSystem.ParseBatchSize
 	}
+	if cfg.System.NginxConfDir == "" {
+		cfg.System.NginxConfDir = defaultSystem.NginxConfDir
+	}
+	if cfg.System.ScanWorkers <= 0 {
+		cfg.System.ScanWorkers = defaultSystem.ScanWorkers
+	}
 	if cfg.System.IPGeoCacheLimit <= 0 {
 		cfg.System.IPGeoCacheLimit = defaultSystem.IPGeoCacheLimit
 	}
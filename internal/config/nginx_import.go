@@ -0,0 +1,607 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// nginxCombinedLogFormat nginx 内置的 combined 格式，未指定格式的 access_log 使用该格式
+const nginxCombinedLogFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+
+// nginxIncludeMaxDepth include 嵌套的最大层数，防止循环引用
+const nginxIncludeMaxDepth = 16
+
+// NginxImportResult nginx 配置导入结果
+type NginxImportResult struct {
+	Websites []WebsiteConfig `json:"websites"`
+	Warnings []string        `json:"warnings"`
+}
+
+type nginxDirective struct {
+	name  string
+	args  []string
+	block []nginxDirective
+	file  string
+	line  int
+}
+
+func (d nginxDirective) location() string {
+	return fmt.Sprintf("%s:%d", d.file, d.line)
+}
+
+type nginxToken struct {
+	value  string
+	quoted bool
+	line   int
+}
+
+// ImportNginxConfig 读取 nginx 主配置（跟随 include），按 server 块的 server_name、access_log
+// 及其引用的 log_format 生成站点配置
+func ImportNginxConfig(confPath string) (NginxImportResult, error) {
+	content, err := os.ReadFile(confPath)
+	if err != nil {
+		return NginxImportResult{}, fmt.Errorf("读取 nginx 配置失败: %w", err)
+	}
+	return ImportNginxConfigContent(string(content), confPath)
+}
+
+// ImportNginxConfigContent 解析 nginx 配置内容，confPath 用于解析相对路径的 include 与 access_log
+func ImportNginxConfigContent(content, confPath string) (NginxImportResult, error) {
+	return importNginxConfig(content, confPath, "")
+}
+
+// ImportNginxConfigWithin 供 HTTP 接口使用：confPath 及其 include 的文件都必须位于 rootDir 内，
+// 目录外的 include 跳过并给出警告。confPath 为空时使用 rootDir 下的 nginx.conf，相对路径相对 rootDir；
+// content 为空时读取 confPath。
+func ImportNginxConfigWithin(rootDir, confPath, content string) (NginxImportResult, error) {
+	root, err := resolveNginxPath(rootDir)
+	if err != nil {
+		return NginxImportResult{}, fmt.Errorf("nginx 配置目录无效: %w", err)
+	}
+	if strings.TrimSpace(confPath) == "" {
+		confPath = "nginx.conf"
+	}
+	if !filepath.IsAbs(confPath) {
+		confPath = filepath.Join(root, confPath)
+	}
+	resolved, err := resolveNginxPath(confPath)
+	if err != nil {
+		return NginxImportResult{}, fmt.Errorf("nginx 配置路径无效: %w", err)
+	}
+	if !pathWithin(root, resolved) {
+		return NginxImportResult{}, fmt.Errorf("只能导入 %s 目录下的 nginx 配置", root)
+	}
+	if strings.TrimSpace(content) == "" {
+		data, err := os.ReadFile(resolved)
+		if err != nil {
+			return NginxImportResult{}, fmt.Errorf("读取 nginx 配置失败: %w", err)
+		}
+		content = string(data)
+	}
+	return importNginxConfig(content, resolved, root)
+}
+
+// importNginxConfig root 不为空时只展开位于该目录内的 include
+func importNginxConfig(content, confPath, root string) (NginxImportResult, error) {
+	result := NginxImportResult{Websites: []WebsiteConfig{}, Warnings: []string{}}
+	if strings.TrimSpace(confPath) == "" {
+		confPath = "nginx.conf"
+	}
+	if abs, err := filepath.Abs(confPath); err == nil {
+		confPath = abs
+	}
+	importer := &nginxImporter{
+		root:    root,
+		prefix:  filepath.Dir(confPath),
+		visited: map[string]bool{confPath: true},
+	}
+
+	directives, err := importer.parse(content, confPath, 0)
+	if err != nil {
+		return result, err
+	}
+
+	httpBlocks := findNginxDirectives(directives, "http")
+	if len(httpBlocks) == 0 {
+		return result, errors.New("未找到 http 配置块")
+	}
+	for _, http := range httpBlocks {
+		importer.collectHTTP(http)
+	}
+
+	result.Websites = importer.buildWebsites()
+	result.Warnings = append(result.Warnings, importer.warnings...)
+	return result, nil
+}
+
+type nginxAccessLog struct {
+	path   string
+	format string
+}
+
+type nginxServer struct {
+	names []string
+	logs  []nginxAccessLog
+	where string
+}
+
+type nginxImporter struct {
+	root     string // 为空表示不限制 include 的目录
+	prefix   string
+	visited  map[string]bool
+	formats  map[string]string
+	servers  []nginxServer
+	warnings []string
+}
+
+func (im *nginxImporter) warn(format string, args ...any) {
+	im.warnings = append(im.warnings, fmt.Sprintf(format, args...))
+}
+
+// parse 解析配置内容并展开 include
+func (im *nginxImporter) parse(content, file string, depth int) ([]nginxDirective, error) {
+	tokens, err := tokenizeNginxConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	directives, rest, err := parseNginxDirectives(tokens, file, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%s:%d: 多余的 \"}\"", file, rest[0].line)
+	}
+	return im.expandIncludes(directives, depth)
+}
+
+func (im *nginxImporter) expandIncludes(directives []nginxDirective, depth int) ([]nginxDirective, error) {
+	expanded := make([]nginxDirective, 0, len(directives))
+	for _, directive := range directives {
+		if directive.name != "include" {
+			if directive.block != nil {
+				block, err := im.expandIncludes(directive.block, depth)
+				if err != nil {
+					return nil, err
+				}
+				directive.block = block
+			}
+			expanded = append(expanded, directive)
+			continue
+		}
+		if len(directive.args) != 1 {
+			im.warn("%s: include 参数无效", directive.location())
+			continue
+		}
+		if depth >= nginxIncludeMaxDepth {
+			return nil, fmt.Errorf("%s: include 嵌套层数超过 %d", directive.location(), nginxIncludeMaxDepth)
+		}
+
+		pattern := directive.args[0]
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(im.prefix, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			im.warn("%s: include 路径无效: %s", directive.location(), directive.args[0])
+			continue
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			im.warn("%s: include 文件不存在: %s", directive.location(), directive.args[0])
+			continue
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if im.root != "" {
+				resolved, err := resolveNginxPath(match)
+				if err != nil || !pathWithin(im.root, resolved) {
+					im.warn("%s: include 文件不在 %s 目录内，已跳过: %s", directive.location(), im.root, match)
+					continue
+				}
+				match = resolved
+			}
+			if im.visited[match] {
+				continue
+			}
+			im.visited[match] = true
+			content, err := os.ReadFile(match)
+			if err != nil {
+				im.warn("%s: 读取 include 文件失败: %v", directive.location(), err)
+				continue
+			}
+			included, err := im.parse(string(content), match, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, included...)
+		}
+	}
+	return expanded, nil
+}
+
+// collectHTTP 收集 http 块中的 log_format 与各 server 块的 server_name、access_log
+func (im *nginxImporter) collectHTTP(http nginxDirective) {
+	if im.formats == nil {
+		im.formats = map[string]string{"combined": nginxCombinedLogFormat}
+	}
+	for _, directive := range http.block {
+		if directive.name != "log_format" {
+			continue
+		}
+		if len(directive.args) < 2 {
+			im.warn("%s: log_format 参数不足", directive.location())
+			continue
+		}
+		name := directive.args[0]
+		parts := directive.args[1:]
+		prefix := ""
+		if strings.HasPrefix(parts[0], "escape=") {
+			// 只有 escape=json 由 JSON 格式的解析使用，其他取值作为前缀会被当作日志中的字面文本
+			if parts[0] == "escape=json" {
+				prefix = parts[0] + " "
+			}
+			parts = parts[1:]
+		}
+		im.formats[name] = prefix + strings.Join(parts, "")
+	}
+
+	httpLogs, httpOff := im.accessLogs(http.block)
+	for _, server := range findNginxDirectives(http.block, "server") {
+		logs, off := im.accessLogs(server.block)
+		if len(logs) == 0 && !off {
+			logs, off = httpLogs, httpOff
+		}
+		if len(logs) == 0 {
+			if !off {
+				// 未配置 access_log 时 nginx 使用编译时的默认路径，无法从配置中推断
+				im.warn("%s: server 未配置 access_log，已跳过", server.location())
+			}
+			continue
+		}
+
+		var names []string
+		for _, directive := range findNginxDirectives(server.block, "server_name") {
+			for _, name := range directive.args {
+				if name == "" || name == "_" || strings.HasPrefix(name, "~") || strings.HasPrefix(name, "$") {
+					continue
+				}
+				names = appendUniqueString(names, strings.ToLower(name))
+			}
+		}
+		im.servers = append(im.servers, nginxServer{names: names, logs: logs, where: server.location()})
+	}
+}
+
+// accessLogs 解析当前层级的 access_log 指令，off 表示显式关闭
+func (im *nginxImporter) accessLogs(block []nginxDirective) ([]nginxAccessLog, bool) {
+	var (
+		logs []nginxAccessLog
+		off  bool
+	)
+	for _, directive := range findNginxDirectives(block, "access_log") {
+		if len(directive.args) == 0 {
+			continue
+		}
+		path := directive.args[0]
+		if path == "off" {
+			off = true
+			continue
+		}
+		switch {
+		case strings.HasPrefix(path, "syslog:"):
+			im.warn("%s: access_log 输出到 syslog，无法导入: %s", directive.location(), path)
+			continue
+		case strings.Contains(path, "$"):
+			im.warn("%s: access_log 路径包含变量，无法导入: %s", directive.location(), path)
+			continue
+		case path == "/dev/stdout" || path == "/dev/stderr":
+			im.warn("%s: access_log 输出到 %s，无法作为日志文件导入", directive.location(), path)
+			continue
+		}
+		format := "combined"
+		if len(directive.args) > 1 && !isNginxAccessLogOption(directive.args[1]) {
+			format = directive.args[1]
+		}
+		logs = append(logs, nginxAccessLog{path: im.resolvePath(path), format: format})
+	}
+	return logs, off
+}
+
+func isNginxAccessLogOption(arg string) bool {
+	return arg == "gzip" || strings.HasPrefix(arg, "gzip=") || strings.HasPrefix(arg, "buffer=") ||
+		strings.HasPrefix(arg, "flush=") || strings.HasPrefix(arg, "if=")
+}
+
+func (im *nginxImporter) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(im.prefix, path)
+}
+
+// buildWebsites 按 (日志路径, 格式) 生成站点：共享同一份日志的多个 server 在格式记录了 Host 时
+// 各自生成站点并按 Host 分流，否则合并为一个站点
+func (im *nginxImporter) buildWebsites() []WebsiteConfig {
+	type logKey struct {
+		path   string
+		format string
+	}
+	var (
+		order  []logKey
+		groups = make(map[logKey][]nginxServer)
+	)
+	for _, server := range im.servers {
+		for _, log := range server.logs {
+			key := logKey{path: log.path, format: log.format}
+			if _, ok := groups[key]; !ok {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], server)
+		}
+	}
+
+	websites := make([]WebsiteConfig, 0, len(order))
+	usedNames := make(map[string]int)
+	for _, key := range order {
+		format, ok := im.formats[key.format]
+		if !ok {
+			im.warn("%s: 未找到 log_format %s，已跳过日志 %s", groups[key][0].where, key.format, key.path)
+			continue
+		}
+
+		servers := groups[key]
+		if len(servers) > 1 && !nginxFormatHasHost(format) {
+			im.warn("日志 %s 被 %d 个 server 共享，但 log_format %s 未记录 $host，已合并为一个站点",
+				key.path, len(servers), key.format)
+			var names []string
+			for _, server := range servers {
+				for _, name := range server.names {
+					names = appendUniqueString(names, name)
+				}
+			}
+			servers = []nginxServer{{names: names}}
+		}
+
+		for _, server := range servers {
+			websites = append(websites, WebsiteConfig{
+				Name:      uniqueWebsiteName(nginxWebsiteName(server.names, key.path), usedNames),
+				LogPath:   key.path,
+				Domains:   server.names,
+				LogType:   "nginx",
+				LogFormat: format,
+			})
+		}
+	}
+	return websites
+}
+
+// nginxFormatHasHost 判断日志格式是否记录了请求的 Host，用于共享日志按 Host 分流
+func nginxFormatHasHost(format string) bool {
+	for _, variable := range []string{"$host", "$http_host", "$server_name", "${host}", "${http_host}", "${server_name}"} {
+		idx := strings.Index(format, variable)
+		for idx >= 0 {
+			end := idx + len(variable)
+			if strings.HasPrefix(variable, "${") || end >= len(format) || !isNginxVarChar(format[end]) {
+				return true
+			}
+			next := strings.Index(format[end:], variable)
+			if next < 0 {
+				break
+			}
+			idx = end + next
+		}
+	}
+	return false
+}
+
+func isNginxVarChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func nginxWebsiteName(names []string, logPath string) string {
+	for _, name := range names {
+		if !strings.ContainsAny(name, "*") && !strings.HasPrefix(name, ".") {
+			return name
+		}
+	}
+	if len(names) > 0 {
+		return strings.TrimLeft(names[0], "*.")
+	}
+	base := filepath.Base(logPath)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func uniqueWebsiteName(name string, used map[string]int) string {
+	used[name]++
+	if used[name] == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, used[name])
+}
+
+func appendUniqueString(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+func findNginxDirectives(directives []nginxDirective, name string) []nginxDirective {
+	var found []nginxDirective
+	for _, directive := range directives {
+		if directive.name == name {
+			found = append(found, directive)
+		}
+	}
+	return found
+}
+
+// parseNginxDirectives 将 token 解析为指令树，nested 为 true 时遇到 "}" 返回
+func parseNginxDirectives(tokens []nginxToken, file string, nested bool) ([]nginxDirective, []nginxToken, error) {
+	var directives []nginxDirective
+	for len(tokens) > 0 {
+		token := tokens[0]
+		if !token.quoted && token.value == "}" {
+			if !nested {
+				return directives, tokens, nil
+			}
+			return directives, tokens[1:], nil
+		}
+		if !token.quoted && (token.value == ";" || token.value == "{") {
+			return nil, nil, fmt.Errorf("%s:%d: 意外的 %q", file, token.line, token.value)
+		}
+
+		directive := nginxDirective{name: token.value, file: file, line: token.line}
+		tokens = tokens[1:]
+		for {
+			if len(tokens) == 0 {
+				return nil, nil, fmt.Errorf("%s:%d: 指令 %s 缺少 \";\"", file, directive.line, directive.name)
+			}
+			next := tokens[0]
+			tokens = tokens[1:]
+			if next.quoted {
+				directive.args = append(directive.args, next.value)
+				continue
+			}
+			if next.value == ";" {
+				break
+			}
+			if next.value == "{" {
+				block, rest, err := parseNginxDirectives(tokens, file, true)
+				if err != nil {
+					return nil, nil, err
+				}
+				if block == nil {
+					block = []nginxDirective{}
+				}
+				directive.block = block
+				tokens = rest
+				break
+			}
+			if next.value == "}" {
+				return nil, nil, fmt.Errorf("%s:%d: 指令 %s 缺少 \";\"", file, directive.line, directive.name)
+			}
+			directive.args = append(directive.args, next.value)
+		}
+		directives = append(directives, directive)
+	}
+	if nested {
+		return nil, nil, fmt.Errorf("%s: 缺少 \"}\"", file)
+	}
+	return directives, nil, nil
+}
+
+// tokenizeNginxConfig 按 nginx 配置语法切分 token：支持注释、单双引号与 ${var} 变量
+func tokenizeNginxConfig(content string) ([]nginxToken, error) {
+	var (
+		tokens []nginxToken
+		line   = 1
+	)
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			tokens = append(tokens, nginxToken{value: string(c), line: line})
+			i++
+		case c == '"' || c == '\'':
+			start := line
+			var builder strings.Builder
+			i++
+			closed := false
+			for i < len(content) {
+				ch := content[i]
+				if ch == '\\' && i+1 < len(content) {
+					next := content[i+1]
+					switch next {
+					case '"', '\'', '\\':
+						builder.WriteByte(next)
+					case 'n':
+						builder.WriteByte('\n')
+					case 't':
+						builder.WriteByte('\t')
+					case 'r':
+						builder.WriteByte('\r')
+					default:
+						builder.WriteByte(ch)
+						builder.WriteByte(next)
+					}
+					i += 2
+					continue
+				}
+				if ch == c {
+					closed = true
+					i++
+					break
+				}
+				if ch == '\n' {
+					line++
+				}
+				builder.WriteByte(ch)
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("第 %d 行: 引号未闭合", start)
+			}
+			tokens = append(tokens, nginxToken{value: builder.String(), quoted: true, line: start})
+		default:
+			start := i
+			for i < len(content) {
+				ch := content[i]
+				if ch == '{' && i > start && content[i-1] == '$' {
+					end := strings.IndexByte(content[i:], '}')
+					if end < 0 {
+						return nil, fmt.Errorf("第 %d 行: 变量缺少 \"}\"", line)
+					}
+					i += end + 1
+					continue
+				}
+				if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == ';' || ch == '{' || ch == '}' {
+					break
+				}
+				if ch == '\\' && i+1 < len(content) {
+					i += 2
+					continue
+				}
+				i++
+			}
+			tokens = append(tokens, nginxToken{value: content[start:i], line: line})
+		}
+	}
+	return tokens, nil
+}
+
+// resolveNginxPath 返回绝对路径，文件存在时解析符号链接，避免借助链接读取目录外的文件
+func resolveNginxPath(path string) (string, error) {
+	abs, err := filepath.Abs(strings.TrimSpace(path))
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if errors.Is(err, os.ErrNotExist) {
+		return abs, nil
+	}
+	return resolved, err
+}
+
+// pathWithin 判断 path 是否位于目录 root 内（或就是 root）
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeNginxFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportNginxConfigWithin(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "nginx")
+	outside := filepath.Join(base, "secret.conf")
+
+	writeNginxFile(t, outside, "server { server_name secret.example.com; access_log /var/log/secret.log; }\n")
+	writeNginxFile(t, filepath.Join(root, "conf.d", "site.conf"),
+		"server { server_name www.example.com; access_log /var/log/www.log; }\n")
+	if err := os.Symlink(outside, filepath.Join(root, "conf.d", "link.conf")); err != nil {
+		t.Fatal(err)
+	}
+	writeNginxFile(t, filepath.Join(root, "nginx.conf"), `http {
+    include conf.d/*.conf;
+    include ../secret.conf;
+}
+`)
+
+	result, err := ImportNginxConfigWithin(root, "nginx.conf", "")
+	if err != nil {
+		t.Fatalf("ImportNginxConfigWithin 返回错误: %v", err)
+	}
+	if len(result.Websites) != 1 || result.Websites[0].LogPath != "/var/log/www.log" {
+		t.Fatalf("websites = %+v, 只应包含目录内的 www.example.com", result.Websites)
+	}
+	skipped := 0
+	for _, warning := range result.Warnings {
+		if strings.Contains(warning, "已跳过") {
+			skipped++
+		}
+	}
+	if skipped != 2 {
+		t.Fatalf("warnings = %q, 期望跳过符号链接与 ../secret.conf 两个 include", result.Warnings)
+	}
+
+	// content 模式同样只展开目录内的 include
+	result, err = ImportNginxConfigWithin(root, "", "http { include "+outside+"; }")
+	if err != nil {
+		t.Fatalf("ImportNginxConfigWithin 返回错误: %v", err)
+	}
+	if len(result.Websites) != 0 {
+		t.Fatalf("websites = %+v, 不应读取目录外的 include", result.Websites)
+	}
+
+	for _, path := range []string{outside, "../secret.conf", filepath.Join(root, "conf.d", "link.conf")} {
+		if _, err := ImportNginxConfigWithin(root, path, ""); err == nil {
+			t.Fatalf("path %s 位于目录外，应返回错误", path)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LogLocationKey 返回日志位置的归一化标识，用于判断多个站点是否共享同一份日志。
+// src 为空时使用站点的 logPath；agent 等推送型来源不参与共享判断，返回空字符串。
+func LogLocationKey(site WebsiteConfig, src *SourceConfig) string {
+	if src == nil {
+		path := strings.TrimSpace(site.LogPath)
+		if path == "" {
+			return ""
+		}
+		return "file:" + filepath.Clean(path)
+	}
+
+	pattern := strings.TrimSpace(src.Pattern)
+	switch strings.ToLower(strings.TrimSpace(src.Type)) {
+	case "local":
+		path := strings.TrimSpace(src.Path)
+		if path != "" && pattern == "" {
+			return "file:" + filepath.Clean(path)
+		}
+		return "local:" + path + "|" + pattern
+	case "sftp":
+		port := src.Port
+		if port == 0 {
+			port = 22
+		}
+		return "sftp:" + strings.ToLower(strings.TrimSpace(src.Host)) + ":" + strconv.Itoa(port) +
+			strings.TrimSpace(src.Path) + "|" + pattern
+	case "http":
+		return "http:" + strings.TrimSpace(src.URL)
+	case "s3":
+		return "s3:" + strings.TrimSpace(src.Endpoint) + "/" + strings.TrimSpace(src.Bucket) + "/" +
+			strings.TrimSpace(src.Prefix) + "|" + pattern
+	case "syslog":
+		// 监听同一端口且 tag/hostname 过滤条件相同的站点收到的是同一份日志
+		return "syslog:" + strconv.Itoa(src.Port) + "|" + strings.ToLower(strings.TrimSpace(src.Tag)) + "|" +
+			strings.ToLower(strings.TrimSpace(src.Hostname))
+	case "fluent":
+		// 监听同一端口、tag 与 recordKey 相同的站点收到的是同一份日志
+		return "fluent:" + strconv.Itoa(src.Port) + "|" + strings.TrimSpace(src.Tag) + "|" + strings.TrimSpace(src.RecordKey)
+	case "docker":
+		labels := make([]string, 0, len(src.Labels))
+		for key, value := range src.Labels {
+			labels = append(labels, key+"="+value)
+		}
+		sort.Strings(labels)
+		return "docker:" + filepath.Clean(DockerRoot(src)) + "|" + strings.TrimSpace(src.Container) + "|" +
+			strings.Join(labels, ",") + "|" + strings.ToLower(strings.TrimSpace(src.Stream))
+	default:
+		return ""
+	}
+}
+
+// DockerRoot 返回 docker 来源的 Docker 数据目录，path 留空时为 /var/lib/docker
+func DockerRoot(src *SourceConfig) string {
+	if root := strings.TrimSpace(src.Path); root != "" {
+		return root
+	}
+	return "/var/lib/docker"
+}
+
+// SharedLogGroups 按日志位置对站点分组，仅返回被 2 个及以上站点共享的位置（值为站点下标）
+func SharedLogGroups(websites []WebsiteConfig) map[string][]int {
+	members := make(map[string][]int)
+	for i, site := range websites {
+		seen := make(map[string]struct{})
+		keys := []string{}
+		if len(site.Sources) == 0 {
+			keys = append(keys, LogLocationKey(site, nil))
+		}
+		for sidx := range site.Sources {
+			keys = append(keys, LogLocationKey(site, &site.Sources[sidx]))
+		}
+		for _, key := range keys {
+			if key == "" {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			members[key] = append(members[key], i)
+		}
+	}
+
+	groups := make(map[string][]int)
+	for key, indexes := range members {
+		if len(indexes) > 1 {
+			groups[key] = indexes
+		}
+	}
+	return groups
+}
+
+// SharedLogRouted 共享日志中至少一个站点配置了 domains 时才按 Host 分流，否则各站点仍接收全部记录
+func SharedLogRouted(websites []WebsiteConfig, indexes []int) bool {
+	for _, idx := range indexes {
+		if idx >= 0 && idx < len(websites) && len(websites[idx].Domains) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeHost 归一化域名或 Host 头：去掉 scheme、路径、端口与末尾的点，并转为小写
+func NormalizeHost(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "-" {
+		return ""
+	}
+	if strings.Contains(raw, "://") {
+		if parsed, err := url.Parse(raw); err == nil && parsed.Host != "" {
+			raw = parsed.Host
+		}
+	}
+	raw = strings.TrimPrefix(raw, "//")
+	if idx := strings.IndexAny(raw, "/?"); idx >= 0 {
+		raw = raw[:idx]
+	}
+	if strings.HasPrefix(raw, "[") {
+		if idx := strings.Index(raw, "]"); idx > 0 {
+			raw = raw[1:idx]
+		}
+	} else if idx := strings.LastIndex(raw, ":"); idx >= 0 && strings.Count(raw, ":") == 1 {
+		raw = raw[:idx]
+	}
+	return strings.TrimSuffix(strings.ToLower(raw), ".")
+}
+
+// MatchDomain 判断 host 是否匹配 domains 中的任意一项。
+// 支持精确匹配、"*.example.com"（仅子域名）与 ".example.com"（主域名及子域名）。
+func MatchDomain(domains []string, host string) bool {
+	host = NormalizeHost(host)
+	if host == "" {
+		return false
+	}
+	for _, raw := range domains {
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(trimmed, "*."):
+			suffix := NormalizeHost(trimmed[2:])
+			if suffix != "" && strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		case strings.HasPrefix(trimmed, "."):
+			base := NormalizeHost(trimmed[1:])
+			if base != "" && (host == base || strings.HasSuffix(host, "."+base)) {
+				return true
+			}
+		default:
+			if domain := NormalizeHost(trimmed); domain != "" && domain == host {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type FieldError struct {
@@ -15,6 +18,8 @@ type FieldError struct {
 type ValidateOptions struct {
 	CheckPaths  bool
 	CheckRemote bool
+	// ExtractsHost 判断站点（或其来源）的解析配置能否从记录中取得 Host，用于校验按 Host 分流的共享日志，为空时不检查
+	ExtractsHost func(site WebsiteConfig, src *SourceConfig) bool
 }
 
 type ValidationResult struct {
@@ -40,6 +45,8 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 		addError("websites", "至少需要配置一个站点")
 	}
 
+	// fluent 来源按监听地址共用连接认证
+	fluentKeys := make(map[string]string)
 	for i, site := range cfg.Websites {
 		sitePrefix := fmt.Sprintf("websites[%d]", i)
 		if strings.TrimSpace(site.Name) == "" {
@@ -75,6 +82,23 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 				continue
 			}
 
+			switch strings.ToLower(strings.TrimSpace(src.Mode)) {
+			case "", "poll", "stream", "hybrid":
+			default:
+				addError(srcPrefix+".mode", "source.mode 仅支持 poll/stream/hybrid")
+			}
+			if raw := strings.TrimSpace(src.PollInterval); raw != "" {
+				if interval, err := time.ParseDuration(raw); err != nil || interval <= 0 {
+					addError(srcPrefix+".pollInterval", "source.pollInterval 格式无效，例如 5s")
+				}
+			}
+
+			switch strings.ToLower(strings.TrimSpace(src.Compression)) {
+			case "", "auto", "none", "gz", "gzip", "zst", "zstd", "bz2", "bzip2", "xz":
+			default:
+				addError(srcPrefix+".compression", "source.compression 仅支持 auto/none/gzip/zstd/bzip2/xz")
+			}
+
 			switch stype {
 			case "local":
 				if strings.TrimSpace(src.Path) == "" && strings.TrimSpace(src.Pattern) == "" {
@@ -122,12 +146,51 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 				}
 			case "agent":
 				// no-op
+			case "syslog":
+				if src.Port <= 0 || src.Port > 65535 {
+					addError(srcPrefix+".port", "syslog.port 无效")
+				}
+				switch strings.ToLower(strings.TrimSpace(src.Protocol)) {
+				case "", "udp", "tcp", "both":
+				default:
+					addError(srcPrefix+".protocol", "syslog.protocol 仅支持 udp/tcp/both")
+				}
+			case "fluent":
+				if src.Port < 0 || src.Port > 65535 {
+					addError(srcPrefix+".port", "fluent.port 无效")
+				}
+				port := src.Port
+				if port == 0 {
+					port = 24224
+				}
+				address := strings.TrimSpace(src.Host) + ":" + strconv.Itoa(port)
+				if key, ok := fluentKeys[address]; ok && key != src.SharedKey {
+					addError(srcPrefix+".sharedKey", "监听同一地址的 fluent 来源需使用相同的 sharedKey")
+				} else {
+					fluentKeys[address] = src.SharedKey
+				}
+			case "docker":
+				if strings.TrimSpace(src.Container) == "" && len(src.Labels) == 0 {
+					addError(srcPrefix, "docker 需要 container 或 labels")
+				}
+				switch strings.ToLower(strings.TrimSpace(src.Stream)) {
+				case "", "stdout", "stderr", "both":
+				default:
+					addError(srcPrefix+".stream", "docker.stream 仅支持 stdout/stderr/both")
+				}
+				if opts.CheckPaths && strings.TrimSpace(src.Path) != "" {
+					if err := validatePath(src.Path); err != nil {
+						addError(srcPrefix+".path", err.Error())
+					}
+				}
 			default:
 				addError(srcPrefix+".type", "不支持的 source.type")
 			}
 		}
 	}
 
+	validateSharedLogs(cfg.Websites, opts.ExtractsHost, addError, addWarning)
+
 	if strings.TrimSpace(cfg.Database.Driver) == "" {
 		addError("database.driver", "数据库驱动不能为空")
 	} else if strings.TrimSpace(cfg.Database.Driver) != "postgres" {
@@ -142,6 +205,9 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	if cfg.System.ParseBatchSize <= 0 {
 		addError("system.parseBatchSize", "parseBatchSize 必须大于 0")
 	}
+	if cfg.System.ScanWorkers <= 0 {
+		addError("system.scanWorkers", "scanWorkers 必须大于 0")
+	}
 	if cfg.System.IPGeoCacheLimit <= 0 {
 		addError("system.ipGeoCacheLimit", "ipGeoCacheLimit 必须大于 0")
 	}
@@ -156,6 +222,97 @@ func ValidateConfig(cfg *Config, opts ValidateOptions) ValidationResult {
 	return result
 }
 
+// validateSharedLogs 校验共享同一份日志的站点：按 Host 分流时最多只能有一个 catchAll 站点，
+// 且配置了 domains 的站点的日志格式中需要有 Host，否则所有记录都会被当作缺少 Host 而跳过
+func validateSharedLogs(
+	websites []WebsiteConfig,
+	extractsHost func(site WebsiteConfig, src *SourceConfig) bool,
+	addError, addWarning func(field, msg string),
+) {
+	groups := SharedLogGroups(websites)
+	shared := make(map[int]struct{})
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		indexes := groups[key]
+		for _, idx := range indexes {
+			shared[idx] = struct{}{}
+		}
+		if !SharedLogRouted(websites, indexes) {
+			continue
+		}
+		catchAll := -1
+		for _, idx := range indexes {
+			site := websites[idx]
+			sitePrefix := fmt.Sprintf("websites[%d]", idx)
+			if site.CatchAll {
+				if catchAll >= 0 {
+					addError(sitePrefix+".catchAll", fmt.Sprintf("与 websites[%d] 共享同一份日志，只能有一个 catchAll 站点", catchAll))
+				} else {
+					catchAll = idx
+				}
+				continue
+			}
+			if len(site.Domains) == 0 {
+				addWarning(sitePrefix+".domains", "该站点与其他站点共享同一份日志，但未配置 domains，将不会收到任何记录")
+				continue
+			}
+			if extractsHost == nil {
+				continue
+			}
+			for _, field := range sharedLogParseFields(site, key) {
+				if !extractsHost(site, field.src) {
+					addError(sitePrefix+field.name, "该站点与其他站点共享同一份日志并按 domains 分流，但日志格式中没有 Host"+
+						"（如 $host、$server_name、%v、RequestHost），将不会收到任何记录；请在日志格式中加入 Host，或移除共享站点的 domains")
+				}
+			}
+		}
+	}
+
+	for i, site := range websites {
+		if _, ok := shared[i]; site.CatchAll && !ok {
+			addWarning(fmt.Sprintf("websites[%d].catchAll", i), "catchAll 仅对共享同一份日志的站点生效")
+		}
+	}
+}
+
+type sharedLogParseField struct {
+	name string
+	src  *SourceConfig
+}
+
+// sharedLogParseFields 返回站点中读取共享日志 key 的位置（logPath 或某个来源）及其解析配置所在的字段
+func sharedLogParseFields(site WebsiteConfig, key string) []sharedLogParseField {
+	if len(site.Sources) == 0 {
+		if LogLocationKey(site, nil) != key {
+			return nil
+		}
+		field := ".logFormat"
+		if strings.TrimSpace(site.LogRegex) != "" {
+			field = ".logRegex"
+		} else if strings.TrimSpace(site.LogFormat) == "" {
+			field = ".logType"
+		}
+		return []sharedLogParseField{{name: field}}
+	}
+	var fields []sharedLogParseField
+	for i := range site.Sources {
+		if LogLocationKey(site, &site.Sources[i]) != key {
+			continue
+		}
+		field := ".logFormat"
+		if site.Sources[i].Parse != nil {
+			field = fmt.Sprintf(".sources[%d].parse", i)
+		}
+		fields = append(fields, sharedLogParseField{name: field, src: &site.Sources[i]})
+	}
+	return fields
+}
+
 func validatePath(value string) error {
 	value = strings.TrimSpace(value)
 	if value == "" {
@@ -18,23 +18,26 @@ var (
 
 // InitPVFilters 初始化PV过滤规则
 func InitPVFilters() {
-	cfg := config.ReadConfig()
+	ApplyPVFilters(config.ReadConfig().PVFilter)
+}
 
+// ApplyPVFilters 按指定的过滤配置初始化PV过滤规则，agent 没有服务端配置时使用
+func ApplyPVFilters(filter config.PVFilterConfig) {
 	// 初始化状态码过滤
 	statusCodes = make(map[int]bool)
-	for _, code := range cfg.PVFilter.StatusCodeInclude {
+	for _, code := range filter.StatusCodeInclude {
 		statusCodes[code] = true
 	}
 
 	// 初始化正则表达式过滤
-	excludePatterns = make([]*regexp.Regexp, len(cfg.PVFilter.ExcludePatterns))
-	for i, pattern := range cfg.PVFilter.ExcludePatterns {
+	excludePatterns = make([]*regexp.Regexp, len(filter.ExcludePatterns))
+	for i, pattern := range filter.ExcludePatterns {
 		excludePatterns[i] = regexp.MustCompile(pattern)
 	}
 
 	// 初始化IP过滤
 	excludeIPs = make(map[string]bool)
-	for _, ip := range cfg.PVFilter.ExcludeIPs {
+	for _, ip := range filter.ExcludeIPs {
 		normalized := normalizeIP(ip)
 		if normalized == "" {
 			continue
@@ -43,7 +46,7 @@ func InitPVFilters() {
 	}
 
 	excludePrivate = true
-	if cfg.PVFilter.ExcludeIPs != nil && len(cfg.PVFilter.ExcludeIPs) == 0 {
+	if filter.ExcludeIPs != nil && len(filter.ExcludeIPs) == 0 {
 		excludePrivate = false
 	}
 }
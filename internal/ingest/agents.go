@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
+)
+
+const (
+	// defaultAgentHeartbeatInterval agent 未上报心跳间隔时使用的默认值
+	defaultAgentHeartbeatInterval = 30 * time.Second
+	// agentOfflineHeartbeats 连续错过多少次心跳视为离线
+	agentOfflineHeartbeats = 3
+)
+
+// ErrAgentNotRegistered 心跳对应的 agent 未注册（如服务端重启后），agent 收到后重新注册
+var ErrAgentNotRegistered = errors.New("agent 未注册")
+
+// AgentInput agent 采集的一组日志文件
+type AgentInput struct {
+	WebsiteID string   `json:"website_id"`
+	SourceID  string   `json:"source_id"`
+	Paths     []string `json:"paths"`
+}
+
+// AgentRegistration agent 启动时上报的信息
+type AgentRegistration struct {
+	AgentID           string       `json:"agent_id"`
+	Hostname          string       `json:"hostname"`
+	Version           string       `json:"version"`
+	HeartbeatInterval int64        `json:"heartbeat_interval"` // 秒
+	Inputs            []AgentInput `json:"inputs"`
+}
+
+// AgentFileStatus agent 正在读取的文件及其推送进度
+type AgentFileStatus struct {
+	Path      string `json:"path"`
+	WebsiteID string `json:"website_id"`
+	SourceID  string `json:"source_id"`
+	Offset    int64  `json:"offset"` // 服务端已确认的位置
+	Size      int64  `json:"size"`
+	Lag       int64  `json:"lag"` // 尚未推送的字节数
+}
+
+// AgentHeartbeat agent 定期上报的状态
+type AgentHeartbeat struct {
+	AgentID      string            `json:"agent_id"`
+	Files        []AgentFileStatus `json:"files"`
+	Lag          int64             `json:"lag"`
+	SpoolBatches int               `json:"spool_batches"`
+	SpoolBytes   int64             `json:"spool_bytes"`
+	LastError    string            `json:"last_error,omitempty"`
+	LastErrorAt  int64             `json:"last_error_at,omitempty"`
+}
+
+// AgentStatus 服务端记录的 agent 状态（自进程启动以来）
+type AgentStatus struct {
+	AgentID           string            `json:"agent_id"`
+	Hostname          string            `json:"hostname"`
+	Version           string            `json:"version"`
+	RemoteAddr        string            `json:"remote_addr"`
+	Inputs            []AgentInput      `json:"inputs"`
+	Files             []AgentFileStatus `json:"files"`
+	Lag               int64             `json:"lag"`
+	SpoolBatches      int               `json:"spool_batches"`
+	SpoolBytes        int64             `json:"spool_bytes"`
+	LastError         string            `json:"last_error,omitempty"`
+	LastErrorAt       int64             `json:"last_error_at,omitempty"`
+	HeartbeatInterval int64             `json:"heartbeat_interval"`
+	RegisteredAt      int64             `json:"registered_at"`
+	LastSeenAt        int64             `json:"last_seen_at"`
+	Online            bool              `json:"online"`
+}
+
+// SilentAgentSource 配置了 agent 来源但没有在线的 agent 为其推送日志
+type SilentAgentSource struct {
+	WebsiteID  string `json:"website_id"`
+	SourceID   string `json:"source_id"`
+	LastSeenAt int64  `json:"last_seen_at,omitempty"` // 0 表示进程启动以来从未收到
+}
+
+var (
+	agentMu        sync.RWMutex
+	agents         = make(map[string]*AgentStatus)
+	agentStartedAt = time.Now()
+)
+
+// RegisterAgent 记录 agent 的注册信息，返回服务端期望的心跳间隔
+func RegisterAgent(reg AgentRegistration, remoteAddr string) (time.Duration, error) {
+	agentID := strings.TrimSpace(reg.AgentID)
+	if agentID == "" {
+		return 0, errors.New("缺少 agent_id")
+	}
+	interval := time.Duration(reg.HeartbeatInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultAgentHeartbeatInterval
+	}
+
+	now := time.Now().Unix()
+	agentMu.Lock()
+	defer agentMu.Unlock()
+	status, ok := agents[agentID]
+	if !ok {
+		status = &AgentStatus{AgentID: agentID}
+		agents[agentID] = status
+	}
+	status.Hostname = strings.TrimSpace(reg.Hostname)
+	status.Version = strings.TrimSpace(reg.Version)
+	status.RemoteAddr = remoteAddr
+	status.Inputs = reg.Inputs
+	status.HeartbeatInterval = int64(interval / time.Second)
+	status.RegisteredAt = now
+	status.LastSeenAt = now
+	return interval, nil
+}
+
+// RecordAgentHeartbeat 更新 agent 的推送进度，未注册的 agent 返回 ErrAgentNotRegistered
+func RecordAgentHeartbeat(hb AgentHeartbeat, remoteAddr string) error {
+	agentMu.Lock()
+	defer agentMu.Unlock()
+	status, ok := agents[strings.TrimSpace(hb.AgentID)]
+	if !ok {
+		return ErrAgentNotRegistered
+	}
+	status.RemoteAddr = remoteAddr
+	status.Files = hb.Files
+	status.Lag = hb.Lag
+	status.SpoolBatches = hb.SpoolBatches
+	status.SpoolBytes = hb.SpoolBytes
+	status.LastError = hb.LastError
+	status.LastErrorAt = hb.LastErrorAt
+	status.LastSeenAt = time.Now().Unix()
+	return nil
+}
+
+func (s *AgentStatus) online(now int64) bool {
+	return now-s.LastSeenAt <= s.HeartbeatInterval*agentOfflineHeartbeats
+}
+
+// GetAgentStatuses 返回所有注册过的 agent，按主机名排序
+func GetAgentStatuses() []AgentStatus {
+	now := time.Now().Unix()
+	agentMu.RLock()
+	results := make([]AgentStatus, 0, len(agents))
+	for _, status := range agents {
+		item := *status
+		item.Online = status.online(now)
+		results = append(results, item)
+	}
+	agentMu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Hostname != results[j].Hostname {
+			return results[i].Hostname < results[j].Hostname
+		}
+		return results[i].AgentID < results[j].AgentID
+	})
+	return results
+}
+
+// GetSilentAgentSources 返回没有在线 agent 推送的 agent 来源。
+// 进程刚启动时 agent 尚未重新注册，等待一个离线判定周期后才报告。
+func GetSilentAgentSources() []SilentAgentSource {
+	now := time.Now().Unix()
+	lastSeen := make(map[string]int64)
+	online := make(map[string]bool)
+	agentMu.RLock()
+	for _, status := range agents {
+		for _, input := range status.Inputs {
+			key := input.WebsiteID + ":" + input.SourceID
+			if status.LastSeenAt > lastSeen[key] {
+				lastSeen[key] = status.LastSeenAt
+			}
+			if status.online(now) {
+				online[key] = true
+			}
+		}
+	}
+	agentMu.RUnlock()
+
+	grace := int64(defaultAgentHeartbeatInterval/time.Second) * agentOfflineHeartbeats
+	starting := now-agentStartedAt.Unix() <= grace
+
+	results := []SilentAgentSource{}
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(websiteID)
+		if !ok {
+			continue
+		}
+		for _, srcCfg := range website.Sources {
+			if !strings.EqualFold(strings.TrimSpace(srcCfg.Type), string(source.SourceAgent)) {
+				continue
+			}
+			key := websiteID + ":" + srcCfg.ID
+			if online[key] || (starting && lastSeen[key] == 0) {
+				continue
+			}
+			results = append(results, SilentAgentSource{
+				WebsiteID:  websiteID,
+				SourceID:   srcCfg.ID,
+				LastSeenAt: lastSeen[key],
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].WebsiteID != results[j].WebsiteID {
+			return results[i].WebsiteID < results[j].WebsiteID
+		}
+		return results[i].SourceID < results[j].SourceID
+	})
+	return results
+}
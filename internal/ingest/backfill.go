@@ -2,7 +2,6 @@ package ingest
 
 import (
 	"bufio"
-	"compress/gzip"
 	"errors"
 	"io"
 	"os"
@@ -10,6 +9,7 @@ import (
 	"time"
 
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/sirupsen/logrus"
 )
@@ -73,12 +73,8 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 	websiteIDs := config.GetAllWebsiteIDs()
 
 	for _, websiteID := range websiteIDs {
-		state, ok := p.states[websiteID]
-		if !ok || state.Files == nil {
-			continue
-		}
-
-		for filePath, fileState := range state.Files {
+		unlock := p.lockScan(websiteID, "")
+		for filePath, fileState := range p.fileStates(websiteID) {
 			if budget.exhausted() {
 				break
 			}
@@ -86,10 +82,10 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 				continue
 			}
 
-			if isGzipFile(filePath) {
-				processed, entries, err := p.backfillGzipFile(websiteID, filePath, &fileState, budget)
+			if codec := source.FileCodec(filePath); codec.Compressed() {
+				processed, entries, err := p.backfillCompressedFile(websiteID, filePath, codec, &fileState, budget)
 				if err != nil {
-					logrus.Warnf("回填 gzip 日志文件 %s 失败: %v", filePath, err)
+					logrus.Warnf("回填 %s 日志文件 %s 失败: %v", codec, filePath, err)
 				} else {
 					result.ProcessedBytes += processed
 					result.ProcessedEntries += entries
@@ -114,6 +110,7 @@ func (p *LogParser) BackfillHistory(maxDuration time.Duration, maxBytes int64) B
 		}
 
 		p.refreshWebsiteRanges(websiteID)
+		unlock()
 		if budget.exhausted() {
 			break
 		}
@@ -139,6 +136,19 @@ func (p *LogParser) backfillPlainFile(
 	}
 	defer file.Close()
 
+	parser, err := p.getLineParser(websiteID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if state.BackfillOffset > 0 {
+		parser.RestoreFields(state.BackfillFields)
+	} else {
+		parser.RestoreFields(nil)
+	}
+	defer func() {
+		state.BackfillFields = parser.CurrentFields()
+	}()
+
 	sectionLen := state.BackfillEnd - state.BackfillOffset
 	reader := io.NewSectionReader(file, state.BackfillOffset, sectionLen)
 	bufReader := bufio.NewReader(reader)
@@ -170,6 +180,8 @@ func (p *LogParser) backfillPlainFile(
 		minTs      int64
 		maxTs      int64
 	)
+	rejects := p.newRejectCollector(websiteID, lineOrigin{target: filePath, offset: state.BackfillOffset})
+	defer rejects.finish()
 
 	for {
 		if budget.exhausted() {
@@ -185,6 +197,7 @@ func (p *LogParser) backfillPlainFile(
 			p.updateParsedRange(state, minTs, maxTs)
 			return bytesRead, entryCount, err
 		}
+		lineOffset := bytesRead
 		bytesRead += int64(len(line))
 		budget.consume(int64(len(line)))
 
@@ -197,6 +210,7 @@ func (p *LogParser) backfillPlainFile(
 		}
 
 		entry, parseErr := p.parseLogLine(websiteID, "", line)
+		rejects.observe(line, lineOffset, parseErr)
 		if parseErr != nil {
 			if err != nil {
 				continue
@@ -240,8 +254,9 @@ func (p *LogParser) backfillPlainFile(
 	return bytesRead, entryCount, nil
 }
 
-func (p *LogParser) backfillGzipFile(
+func (p *LogParser) backfillCompressedFile(
 	websiteID, filePath string,
+	codec source.Codec,
 	state *FileState,
 	budget *backfillBudget,
 ) (int64, int, error) {
@@ -263,11 +278,15 @@ func (p *LogParser) backfillGzipFile(
 	}
 	defer file.Close()
 
-	gzReader, err := gzip.NewReader(file)
+	decompressed, err := source.NewDecompressor(codec, file)
 	if err != nil {
 		return 0, 0, err
 	}
-	defer gzReader.Close()
+	defer decompressed.Close()
+
+	if parser, err := p.getLineParser(websiteID); err == nil {
+		parser.RestoreFields(nil)
+	}
 
 	cutoffTs := state.RecentCutoffTs
 	if cutoffTs == 0 {
@@ -276,7 +295,9 @@ func (p *LogParser) backfillGzipFile(
 	window := parseWindow{maxTs: cutoffTs}
 
 	parserResult := EmptyParserResult("", "")
-	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(gzReader, websiteID, "", &parserResult, window)
+	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(
+		decompressed, websiteID, lineOrigin{target: filePath}, &parserResult, window,
+	)
 	budget.consume(bytesRead)
 	state.BackfillDone = true
 	p.updateParsedRange(state, minTs, maxTs)
@@ -64,6 +64,16 @@ func (c *Cache) Seen(key string) bool {
 	return false
 }
 
+// Forget removes key so that it is accepted again, e.g. after the insert it guarded failed.
+func (c *Cache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
 func (c *Cache) removeOldest() {
 	element := c.order.Back()
 	if element != nil {
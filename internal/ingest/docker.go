@@ -0,0 +1,125 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
+)
+
+var errDockerEnvelope = errors.New("无法解析 Docker 日志外层 JSON")
+
+// dockerEnvelope 拆开 Docker json-file 日志驱动的外层 JSON：{"log":"...","stream":"stdout","time":"..."}
+type dockerEnvelope struct {
+	streams map[string]bool
+	useTime bool
+}
+
+type dockerLogEntry struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+// newDockerEnvelope 非 docker 来源返回 nil
+func newDockerEnvelope(sourceCfg *config.SourceConfig) *dockerEnvelope {
+	if sourceCfg == nil || !strings.EqualFold(strings.TrimSpace(sourceCfg.Type), string(source.SourceDocker)) {
+		return nil
+	}
+	streams := map[string]bool{"stdout": true}
+	switch strings.ToLower(strings.TrimSpace(sourceCfg.Stream)) {
+	case "stderr":
+		streams = map[string]bool{"stderr": true}
+	case "both":
+		streams = map[string]bool{"stdout": true, "stderr": true}
+	}
+	return &dockerEnvelope{streams: streams, useTime: sourceCfg.UseDockerTime}
+}
+
+// partialTail 返回 lines 末尾仍未结束的分段行数，这些行需要等后续分段到达后一起解析
+func (e *dockerEnvelope) partialTail(lines []string) int {
+	count := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		var entry dockerLogEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil || strings.HasSuffix(entry.Log, "\n") {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// dockerLine 合并分段后的一行日志
+type dockerLine struct {
+	text   string
+	offset int64 // 第一段在读取内容中的偏移
+	time   time.Time
+}
+
+// dockerLineJoiner 合并 Docker 拆分的长行：超过 16KB 的行会被拆成多条记录，只有最后一条以换行结尾。
+// stdout 与 stderr 的分段可能交错写入，分别合并。
+type dockerLineJoiner struct {
+	envelope *dockerEnvelope
+	pending  map[string]*dockerPartial
+	emitted  int64 // 最后一条完整行的偏移
+}
+
+type dockerPartial struct {
+	line dockerLine
+	text strings.Builder
+}
+
+func newDockerLineJoiner(envelope *dockerEnvelope) *dockerLineJoiner {
+	return &dockerLineJoiner{
+		envelope: envelope,
+		pending:  make(map[string]*dockerPartial),
+		emitted:  -1,
+	}
+}
+
+// push 处理一条原始记录，返回 false 表示没有可解析的完整行（分段未结束或被 stream 过滤）
+func (j *dockerLineJoiner) push(raw string, offset int64) (dockerLine, bool, error) {
+	var entry dockerLogEntry
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		return dockerLine{text: raw, offset: offset}, true, errDockerEnvelope
+	}
+	if !j.envelope.streams[entry.Stream] {
+		return dockerLine{}, false, nil
+	}
+
+	partial := j.pending[entry.Stream]
+	if partial == nil {
+		partial = &dockerPartial{line: dockerLine{offset: offset}}
+		if j.envelope.useTime {
+			partial.line.time, _ = time.Parse(time.RFC3339Nano, entry.Time)
+		}
+		j.pending[entry.Stream] = partial
+	}
+	partial.text.WriteString(entry.Log)
+	if !strings.HasSuffix(entry.Log, "\n") {
+		return dockerLine{}, false, nil
+	}
+
+	line := partial.line
+	line.text = strings.TrimRight(partial.text.String(), "\r\n")
+	delete(j.pending, entry.Stream)
+	j.emitted = offset
+	return line, true, nil
+}
+
+// unfinished 返回未结束分段的起始偏移。其后已有完整行时不回退，避免重复导入。
+func (j *dockerLineJoiner) unfinished() (int64, bool) {
+	start := int64(-1)
+	for _, partial := range j.pending {
+		if start < 0 || partial.line.offset < start {
+			start = partial.line.offset
+		}
+	}
+	if start < 0 || start < j.emitted {
+		return 0, false
+	}
+	return start, true
+}
@@ -0,0 +1,404 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	fluentDefaultPort      = 24224
+	fluentDefaultRecordKey = "log"
+	// fluentMaxChunk 单条消息（PackedForward 的整个 chunk）的长度上限
+	fluentMaxChunk = 64 * 1024 * 1024
+	// fluentMaxEntries PackedForward 单个 chunk 中的记录数上限
+	fluentMaxEntries       = 100000
+	fluentHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	errFluentChunkTooLarge  = errors.New("fluent chunk 解压后超出长度限制")
+	errFluentTooManyEntries = errors.New("fluent chunk 中的记录数超出限制")
+)
+
+// fluentRoute 将 Fluent 消息分发到站点来源，tag 为空表示接收全部
+type fluentRoute struct {
+	websiteID string
+	sourceID  string
+	tag       string
+	recordKey string
+}
+
+type fluentListener struct {
+	address   string
+	sharedKey string
+	routes    []fluentRoute
+}
+
+// fluentOption forward 协议消息末尾的可选参数
+type fluentOption struct {
+	chunk      string
+	compressed string
+}
+
+// RunFluentReceivers 按配置中的 fluent 来源监听 TCP 端口，接收 Fluent Bit / Fluentd 的 forward 协议消息，
+// 按 tag 分发到站点，取出 recordKey 字段交给 IngestLines 解析入库，写入成功后才回复 ack
+func (p *LogParser) RunFluentReceivers(ctx context.Context) {
+	listeners := buildFluentListeners()
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		listener := listener
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.serveFluent(ctx, listener); err != nil {
+				logrus.WithError(err).Errorf("fluent 监听 %s 失败", listener.address)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// buildFluentListeners 汇总所有站点的 fluent 来源，同一地址只监听一次
+func buildFluentListeners() []*fluentListener {
+	var (
+		listeners []*fluentListener
+		index     = make(map[string]*fluentListener)
+	)
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(websiteID)
+		if !ok {
+			continue
+		}
+		for _, src := range website.Sources {
+			if strings.ToLower(strings.TrimSpace(src.Type)) != "fluent" {
+				continue
+			}
+			port := src.Port
+			if port == 0 {
+				port = fluentDefaultPort
+			}
+			address := net.JoinHostPort(strings.TrimSpace(src.Host), strconv.Itoa(port))
+			recordKey := strings.TrimSpace(src.RecordKey)
+			if recordKey == "" {
+				recordKey = fluentDefaultRecordKey
+			}
+			listener, ok := index[address]
+			if !ok {
+				listener = &fluentListener{address: address, sharedKey: src.SharedKey}
+				index[address] = listener
+				listeners = append(listeners, listener)
+			}
+			listener.routes = append(listener.routes, fluentRoute{
+				websiteID: websiteID,
+				sourceID:  strings.TrimSpace(src.ID),
+				tag:       strings.TrimSpace(src.Tag),
+				recordKey: recordKey,
+			})
+		}
+	}
+	return listeners
+}
+
+// matchFluentTag 按 Fluentd 的规则匹配 tag：* 匹配一段内的任意字符，** 匹配零段或多段，{a,b} 匹配其中之一
+func matchFluentTag(pattern, tag string) bool {
+	if pattern == "" {
+		return true
+	}
+	return matchFluentParts(strings.Split(pattern, "."), strings.Split(tag, "."))
+}
+
+func matchFluentParts(pattern, tag []string) bool {
+	if len(pattern) == 0 {
+		return len(tag) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(tag); i++ {
+			if matchFluentParts(pattern[1:], tag[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(tag) == 0 || !matchFluentPart(pattern[0], tag[0]) {
+		return false
+	}
+	return matchFluentParts(pattern[1:], tag[1:])
+}
+
+func matchFluentPart(pattern, part string) bool {
+	if strings.HasPrefix(pattern, "{") && strings.HasSuffix(pattern, "}") {
+		for _, option := range strings.Split(pattern[1:len(pattern)-1], ",") {
+			if matchFluentPart(option, part) {
+				return true
+			}
+		}
+		return false
+	}
+	matched, _ := path.Match(pattern, part)
+	return matched
+}
+
+func (p *LogParser) serveFluent(ctx context.Context, listener *fluentListener) error {
+	ln, err := net.Listen("tcp", listener.address)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("fluent 接收器已监听 tcp %s", listener.address)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logrus.WithError(err).Warnf("接受 fluent 连接失败: %s", listener.address)
+			continue
+		}
+		go p.handleFluentConn(ctx, conn, listener)
+	}
+}
+
+func (p *LogParser) handleFluentConn(ctx context.Context, conn net.Conn, listener *fluentListener) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, 64*1024)
+	decoder := newMsgpackDecoder(reader, fluentMaxChunk)
+	if listener.sharedKey != "" {
+		if err := fluentHandshake(conn, decoder, listener.sharedKey); err != nil {
+			logrus.WithError(err).Warnf("fluent 客户端 %s 认证失败", conn.RemoteAddr())
+			return
+		}
+	}
+
+	for {
+		value, err := decoder.decode()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				logrus.WithError(err).Warnf("读取 fluent 连接 %s 失败", conn.RemoteAddr())
+			}
+			return
+		}
+		message, ok := value.([]interface{})
+		if !ok || len(message) < 2 {
+			logrus.Warnf("fluent 连接 %s 收到无效消息", conn.RemoteAddr())
+			return
+		}
+
+		option, err := p.ingestFluentMessage(listener, message)
+		if err != nil {
+			// 不回复 ack，开启 require_ack_response 的客户端会重试该 chunk
+			logrus.WithError(err).Warnf("处理 fluent 连接 %s 的消息失败", conn.RemoteAddr())
+			continue
+		}
+		if option.chunk != "" {
+			ack := appendMsgpack(nil, map[string]interface{}{"ack": option.chunk})
+			if _, err := conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// fluentHandshake 执行 forward 协议的共享密钥认证：发送 HELO，校验 PING，回复 PONG
+func fluentHandshake(conn net.Conn, decoder *msgpackDecoder, sharedKey string) error {
+	conn.SetDeadline(time.Now().Add(fluentHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := appendMsgpack(nil, []interface{}{"HELO", map[string]interface{}{
+		"nonce":     nonce,
+		"auth":      []byte{},
+		"keepalive": true,
+	}})
+	if _, err := conn.Write(helo); err != nil {
+		return err
+	}
+
+	value, err := decoder.decode()
+	if err != nil {
+		return err
+	}
+	ping, ok := value.([]interface{})
+	if !ok || len(ping) < 4 || msgpackText(ping[0]) != "PING" {
+		return errors.New("未收到 PING")
+	}
+	clientHostname := msgpackText(ping[1])
+	salt := msgpackText(ping[2])
+	digest := msgpackText(ping[3])
+
+	serverHostname, _ := os.Hostname()
+	if digest != fluentDigest(salt, clientHostname, nonce, sharedKey) {
+		pong := appendMsgpack(nil, []interface{}{"PONG", false, "shared_key mismatch", serverHostname, ""})
+		conn.Write(pong)
+		return errors.New("shared_key 不匹配")
+	}
+	pong := appendMsgpack(nil, []interface{}{
+		"PONG", true, "", serverHostname, fluentDigest(salt, serverHostname, nonce, sharedKey),
+	})
+	_, err = conn.Write(pong)
+	return err
+}
+
+func fluentDigest(salt, hostname string, nonce []byte, sharedKey string) string {
+	hash := sha512.New()
+	hash.Write([]byte(salt))
+	hash.Write([]byte(hostname))
+	hash.Write(nonce)
+	hash.Write([]byte(sharedKey))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ingestFluentMessage 解析 Message / Forward / PackedForward / CompressedPackedForward 四种模式的消息并写入
+func (p *LogParser) ingestFluentMessage(listener *fluentListener, message []interface{}) (fluentOption, error) {
+	tag := msgpackText(message[0])
+
+	var (
+		records []interface{}
+		option  fluentOption
+		err     error
+	)
+	switch entries := message[1].(type) {
+	case []interface{}:
+		// Forward: [tag, [[time, record], ...], option]
+		option = parseFluentOption(message, 2)
+		if len(entries) > fluentMaxEntries {
+			return option, errFluentTooManyEntries
+		}
+		for _, entry := range entries {
+			if pair, ok := entry.([]interface{}); ok && len(pair) >= 2 {
+				records = append(records, pair[1])
+			}
+		}
+	case []byte, string:
+		// PackedForward: [tag, msgpack 流（可能 gzip 压缩）, option]
+		option = parseFluentOption(message, 2)
+		records, err = unpackFluentEntries([]byte(msgpackText(entries)), option.compressed)
+		if err != nil {
+			return option, err
+		}
+	default:
+		// Message: [tag, time, record, option]
+		if len(message) < 3 {
+			return option, errors.New("fluent 消息缺少 record")
+		}
+		option = parseFluentOption(message, 3)
+		records = []interface{}{message[2]}
+	}
+
+	for _, route := range listener.routes {
+		if !matchFluentTag(route.tag, tag) {
+			continue
+		}
+		lines := fluentRecordLines(records, route.recordKey)
+		if len(lines) == 0 {
+			continue
+		}
+		if _, _, err := p.IngestLines(route.websiteID, route.sourceID, lines); err != nil {
+			return option, fmt.Errorf("写入网站 %s 的 fluent 日志失败: %w", route.websiteID, err)
+		}
+	}
+	return option, nil
+}
+
+func parseFluentOption(message []interface{}, index int) fluentOption {
+	if len(message) <= index {
+		return fluentOption{}
+	}
+	values, ok := message[index].(map[string]interface{})
+	if !ok {
+		return fluentOption{}
+	}
+	return fluentOption{
+		chunk:      msgpackText(values["chunk"]),
+		compressed: msgpackText(values["compressed"]),
+	}
+}
+
+// unpackFluentEntries 解开 PackedForward 中连续编码的 [time, record]，返回其中的 record。
+// gzip 压缩的 chunk 解压后同样受 fluentMaxChunk 限制，记录数受 fluentMaxEntries 限制。
+func unpackFluentEntries(data []byte, compressed string) ([]interface{}, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	var limited *io.LimitedReader
+	if compressed == "gzip" {
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzReader.Close()
+		limited = &io.LimitedReader{R: gzReader, N: fluentMaxChunk + 1}
+		reader = limited
+	} else if compressed != "" && compressed != "text" {
+		return nil, fmt.Errorf("不支持的 fluent 压缩格式: %s", compressed)
+	}
+
+	decoder := newMsgpackDecoder(bufio.NewReader(reader), fluentMaxChunk)
+	var records []interface{}
+	for {
+		value, err := decoder.decode()
+		if limited != nil && limited.N <= 0 {
+			return nil, errFluentChunkTooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pair, ok := value.([]interface{}); ok && len(pair) >= 2 {
+			if len(records) >= fluentMaxEntries {
+				return nil, errFluentTooManyEntries
+			}
+			records = append(records, pair[1])
+		}
+	}
+}
+
+// fluentRecordLines 取出每条 record 中 recordKey 字段的内容作为日志行
+func fluentRecordLines(records []interface{}, recordKey string) []string {
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		fields, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, ok := fields[recordKey]
+		if !ok {
+			continue
+		}
+		line := strings.TrimRight(msgpackText(value), "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func packFluentEntries(records ...map[string]interface{}) []byte {
+	var buf []byte
+	for _, record := range records {
+		buf = appendMsgpack(buf, []interface{}{"1700000000", record})
+	}
+	return buf
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnpackFluentEntries(t *testing.T) {
+	packed := packFluentEntries(
+		map[string]interface{}{"log": "line 1"},
+		map[string]interface{}{"log": "line 2"},
+	)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		compressed string
+		wantLines  []string
+		wantErr    bool
+	}{
+		{name: "plain", data: packed, wantLines: []string{"line 1", "line 2"}},
+		{name: "text", data: packed, compressed: "text", wantLines: []string{"line 1", "line 2"}},
+		{name: "gzip", data: gzipBytes(t, packed), compressed: "gzip", wantLines: []string{"line 1", "line 2"}},
+		{name: "empty", data: nil, wantLines: []string{}},
+		{name: "unsupported compression", data: packed, compressed: "zstd", wantErr: true},
+		{name: "invalid gzip", data: packed, compressed: "gzip", wantErr: true},
+		{name: "truncated", data: packed[:len(packed)-3], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := unpackFluentEntries(tt.data, tt.compressed)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，实际得到 %d 条记录", len(records))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unpackFluentEntries 返回错误: %v", err)
+			}
+			lines := fluentRecordLines(records, "log")
+			if len(lines) != len(tt.wantLines) {
+				t.Fatalf("lines = %q, want %q", lines, tt.wantLines)
+			}
+			for i := range lines {
+				if lines[i] != tt.wantLines[i] {
+					t.Fatalf("lines = %q, want %q", lines, tt.wantLines)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackFluentEntriesGzipTooLarge(t *testing.T) {
+	// 一条 record 本身不超过单值上限，但解压后的整个 chunk 超过 fluentMaxChunk
+	half := string(make([]byte, fluentMaxChunk/2))
+	packed := packFluentEntries(
+		map[string]interface{}{"log": half},
+		map[string]interface{}{"log": half},
+	)
+	_, err := unpackFluentEntries(gzipBytes(t, packed), "gzip")
+	if !errors.Is(err, errFluentChunkTooLarge) {
+		t.Fatalf("err = %v, want %v", err, errFluentChunkTooLarge)
+	}
+}
+
+func TestUnpackFluentEntriesTooMany(t *testing.T) {
+	record := map[string]interface{}{"log": "x"}
+	records := make([]map[string]interface{}, fluentMaxEntries+1)
+	for i := range records {
+		records[i] = record
+	}
+	packed := packFluentEntries(records...)
+
+	if _, err := unpackFluentEntries(packed, ""); !errors.Is(err, errFluentTooManyEntries) {
+		t.Fatalf("err = %v, want %v", err, errFluentTooManyEntries)
+	}
+	if got, err := unpackFluentEntries(packFluentEntries(records[:fluentMaxEntries]...), ""); err != nil || len(got) != fluentMaxEntries {
+		t.Fatalf("记录数恰好为上限时应成功: len=%d err=%v", len(got), err)
+	}
+}
@@ -3,18 +3,13 @@ package ingest
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net"
-	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,22 +17,16 @@ import (
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/enrich"
 	"github.com/likaia/nginxpulse/internal/ingest/dedup"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
 	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	defaultNginxLogRegex = `^(?P<ip>\S+) - (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<method>\S+) (?P<url>[^"]+) HTTP/\d\.\d" (?P<status>\d+) (?P<bytes>\d+) "(?P<referer>[^"]*)" "(?P<ua>[^"]*)"`
-	lastCleanupDate      = ""
-	parsingMu            sync.RWMutex
-	parsingMode          parseMode
-)
-
-const defaultNginxTimeLayout = "02/Jan/2006:15:04:05 -0700"
-
-const (
-	parseTypeRegex     = "regex"
-	parseTypeCaddyJSON = "caddy_json"
+	lastCleanupDate = ""
+	parsingMu       sync.RWMutex
+	parsingMode     parseMode
 )
 
 const (
@@ -46,20 +35,11 @@ const (
 	defaultParseBatchSize = 100
 )
 
-var (
-	ipAliases        = []string{"ip", "remote_addr", "client_ip", "http_x_forwarded_for"}
-	timeAliases      = []string{"time", "time_local", "time_iso8601"}
-	methodAliases    = []string{"method", "request_method"}
-	urlAliases       = []string{"url", "request_uri", "uri", "path"}
-	statusAliases    = []string{"status"}
-	bytesAliases     = []string{"bytes", "body_bytes_sent", "bytes_sent"}
-	refererAliases   = []string{"referer", "http_referer"}
-	userAgentAliases = []string{"ua", "user_agent", "http_user_agent"}
-	requestAliases   = []string{"request", "request_line"}
-)
-
 var ErrParsingInProgress = errors.New("日志解析中，请稍后重试")
 
+// errBeyondRetention 表示记录早于保留天数，格式正确，只是不再入库，不计为解析失败
+var errBeyondRetention = errors.New("日志超过保留天数")
+
 // 解析结果
 type ParserResult struct {
 	WebName      string
@@ -80,36 +60,44 @@ type LogScanState struct {
 	LogMaxTs          int64                  `json:"log_max_ts,omitempty"`
 	RecentCutoffTs    int64                  `json:"recent_cutoff_ts,omitempty"`
 	BackfillPending   bool                   `json:"backfill_pending,omitempty"`
+	// 已轮转但尚未被改名/压缩后的文件认领的状态
+	RotatedFiles   []FileState              `json:"rotated_files,omitempty"`
+	RotatedTargets map[string][]TargetState `json:"rotated_targets,omitempty"` // 以来源ID为键
 }
 
 type FileState struct {
-	LastOffset     int64 `json:"last_offset"`
-	LastSize       int64 `json:"last_size"`
-	RecentOffset   int64 `json:"recent_offset,omitempty"`
-	BackfillOffset int64 `json:"backfill_offset,omitempty"`
-	BackfillEnd    int64 `json:"backfill_end,omitempty"`
-	BackfillDone   bool  `json:"backfill_done,omitempty"`
-	FirstTimestamp int64 `json:"first_ts,omitempty"`
-	LastTimestamp  int64 `json:"last_ts,omitempty"`
-	ParsedMinTs    int64 `json:"parsed_min_ts,omitempty"`
-	ParsedMaxTs    int64 `json:"parsed_max_ts,omitempty"`
-	RecentCutoffTs int64 `json:"recent_cutoff_ts,omitempty"`
+	FileIdentity
+	LastOffset     int64    `json:"last_offset"`
+	LastSize       int64    `json:"last_size"`
+	RecentOffset   int64    `json:"recent_offset,omitempty"`
+	BackfillOffset int64    `json:"backfill_offset,omitempty"`
+	BackfillEnd    int64    `json:"backfill_end,omitempty"`
+	BackfillDone   bool     `json:"backfill_done,omitempty"`
+	FirstTimestamp int64    `json:"first_ts,omitempty"`
+	LastTimestamp  int64    `json:"last_ts,omitempty"`
+	ParsedMinTs    int64    `json:"parsed_min_ts,omitempty"`
+	ParsedMaxTs    int64    `json:"parsed_max_ts,omitempty"`
+	RecentCutoffTs int64    `json:"recent_cutoff_ts,omitempty"`
+	Fields         []string `json:"fields,omitempty"` // W3C 日志当前生效的 #Fields 定义
+	BackfillFields []string `json:"backfill_fields,omitempty"`
 }
 
 type TargetState struct {
-	LastOffset     int64  `json:"last_offset"`
-	LastSize       int64  `json:"last_size"`
-	LastModTime    int64  `json:"last_mtime,omitempty"`
-	LastETag       string `json:"last_etag,omitempty"`
-	RecentOffset   int64  `json:"recent_offset,omitempty"`
-	BackfillOffset int64  `json:"backfill_offset,omitempty"`
-	BackfillEnd    int64  `json:"backfill_end,omitempty"`
-	BackfillDone   bool   `json:"backfill_done,omitempty"`
-	FirstTimestamp int64  `json:"first_ts,omitempty"`
-	LastTimestamp  int64  `json:"last_ts,omitempty"`
-	ParsedMinTs    int64  `json:"parsed_min_ts,omitempty"`
-	ParsedMaxTs    int64  `json:"parsed_max_ts,omitempty"`
-	RecentCutoffTs int64  `json:"recent_cutoff_ts,omitempty"`
+	FileIdentity
+	LastOffset     int64    `json:"last_offset"`
+	LastSize       int64    `json:"last_size"`
+	LastModTime    int64    `json:"last_mtime,omitempty"`
+	LastETag       string   `json:"last_etag,omitempty"`
+	RecentOffset   int64    `json:"recent_offset,omitempty"`
+	BackfillOffset int64    `json:"backfill_offset,omitempty"`
+	BackfillEnd    int64    `json:"backfill_end,omitempty"`
+	BackfillDone   bool     `json:"backfill_done,omitempty"`
+	FirstTimestamp int64    `json:"first_ts,omitempty"`
+	LastTimestamp  int64    `json:"last_ts,omitempty"`
+	ParsedMinTs    int64    `json:"parsed_min_ts,omitempty"`
+	ParsedMaxTs    int64    `json:"parsed_max_ts,omitempty"`
+	RecentCutoffTs int64    `json:"recent_cutoff_ts,omitempty"`
+	Fields         []string `json:"fields,omitempty"`
 }
 
 type parseMode int
@@ -135,23 +123,30 @@ func (w parseWindow) allows(ts int64) bool {
 	return true
 }
 
+// logLineParser 在通用的行解析器之外带上站点相关的 Host 分流与 docker 外层 JSON
 type logLineParser struct {
-	regex      *regexp.Regexp
-	indexMap   map[string]int
-	timeLayout string
-	source     string
-	parseType  string
+	*parse.LineParser
+
+	route *hostRoute // 共享日志按 Host 分流，为空表示接收全部记录
+
+	envelope *dockerEnvelope // docker 来源的外层 JSON，为空表示日志行即为原始内容
 }
 
 type LogParser struct {
 	repo            *store.Repository
 	statePath       string
 	states          map[string]LogScanState // 各网站的扫描状态，以网站ID为键
+	stateMu         sync.RWMutex            // 保护 states，只在读写内存中的状态时短暂持有，不跨文件或网络读取
+	saveMu          sync.Mutex              // 串行化状态文件的写入，保证后写入的是较新的状态
+	scanLocks       map[string]*sync.Mutex  // 以网站ID或 网站ID:来源ID 为键，见 lockScan
+	scanLocksMu     sync.Mutex
+	scanWorkers     int
 	demoMode        bool
 	retentionDays   int
 	parseBatchSize  int
 	ipGeoCacheLimit int
 	lineParsers     map[string]*logLineParser // key: websiteID or websiteID:sourceID
+	lineParsersMu   sync.Mutex                // 推送型来源（syslog 等）与定时扫描并发获取解析器
 	dedup           *dedup.Cache
 }
 
@@ -171,6 +166,10 @@ func NewLogParser(userRepoPtr *store.Repository) *LogParser {
 	if ipGeoCacheLimit <= 0 {
 		ipGeoCacheLimit = 1000000
 	}
+	scanWorkers := cfg.System.ScanWorkers
+	if scanWorkers <= 0 {
+		scanWorkers = defaultScanWorkers
+	}
 	parser := &LogParser{
 		repo:            userRepoPtr,
 		statePath:       statePath,
@@ -179,6 +178,8 @@ func NewLogParser(userRepoPtr *store.Repository) *LogParser {
 		retentionDays:   retentionDays,
 		parseBatchSize:  parseBatchSize,
 		ipGeoCacheLimit: ipGeoCacheLimit,
+		scanLocks:       make(map[string]*sync.Mutex),
+		scanWorkers:     scanWorkers,
 		lineParsers:     make(map[string]*logLineParser),
 		dedup:           dedup.NewCache(100000, 10*time.Minute),
 	}
@@ -230,7 +231,11 @@ func (p *LogParser) loadState() {
 
 // updateState 更新并保存状态
 func (p *LogParser) updateState() {
+	p.saveMu.Lock()
+	defer p.saveMu.Unlock()
+	p.stateMu.RLock()
 	data, err := json.Marshal(p.states)
+	p.stateMu.RUnlock()
 	if err != nil {
 		logrus.Errorf("保存扫描状态失败: %v", err)
 		return
@@ -287,6 +292,7 @@ func (p *LogParser) resetStateIfEmptyDB() {
 	p.ResetScanState("")
 }
 
+// ensureWebsiteState 返回网站的扫描状态，不存在时初始化，调用方需持有 stateMu
 func (p *LogParser) ensureWebsiteState(websiteID string) LogScanState {
 	state, ok := p.states[websiteID]
 	if !ok {
@@ -308,6 +314,8 @@ func (p *LogParser) ensureWebsiteState(websiteID string) LogScanState {
 }
 
 func (p *LogParser) getFileState(websiteID, filePath string) (FileState, bool) {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Files == nil {
 		return FileState{}, false
@@ -316,13 +324,28 @@ func (p *LogParser) getFileState(websiteID, filePath string) (FileState, bool) {
 	return fileState, ok
 }
 
+// fileStates 返回网站各文件状态的副本
+func (p *LogParser) fileStates(websiteID string) map[string]FileState {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	files := make(map[string]FileState, len(p.states[websiteID].Files))
+	for path, fileState := range p.states[websiteID].Files {
+		files[path] = fileState
+	}
+	return files
+}
+
 func (p *LogParser) setFileState(websiteID, filePath string, fileState FileState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	state.Files[normalizeLogPath(filePath)] = fileState
 	p.states[websiteID] = state
 }
 
 func (p *LogParser) deleteFileState(websiteID, filePath string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Files == nil {
 		return
@@ -335,6 +358,8 @@ func (p *LogParser) recordParsedHourBuckets(websiteID string, buckets map[int64]
 	if len(buckets) == 0 {
 		return
 	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
 	if state.ParsedHourBuckets == nil {
 		state.ParsedHourBuckets = make(map[int64]bool)
@@ -346,6 +371,8 @@ func (p *LogParser) recordParsedHourBuckets(websiteID string, buckets map[int64]
 }
 
 func (p *LogParser) getTargetState(websiteID, targetKey string) (TargetState, bool) {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Targets == nil {
 		return TargetState{}, false
@@ -355,12 +382,27 @@ func (p *LogParser) getTargetState(websiteID, targetKey string) (TargetState, bo
 }
 
 func (p *LogParser) setTargetState(websiteID, targetKey string, targetState TargetState) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	state := p.ensureWebsiteState(websiteID)
+	state.Targets[targetKey] = targetState
+	p.states[websiteID] = state
+}
+
+// updateTargetState 在 stateMu 内读取并修改目标状态，用于不持有扫描锁的推送写入
+func (p *LogParser) updateTargetState(websiteID, targetKey string, update func(state *TargetState)) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state := p.ensureWebsiteState(websiteID)
+	targetState := state.Targets[targetKey]
+	update(&targetState)
 	state.Targets[targetKey] = targetState
 	p.states[websiteID] = state
 }
 
 func (p *LogParser) deleteTargetState(websiteID, targetKey string) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
 	state, ok := p.states[websiteID]
 	if !ok || state.Targets == nil {
 		return
@@ -370,8 +412,10 @@ func (p *LogParser) deleteTargetState(websiteID, targetKey string) {
 }
 
 func (p *LogParser) refreshWebsiteRanges(websiteID string) {
+	p.stateMu.Lock()
 	state, ok := p.states[websiteID]
 	if !ok || (state.Files == nil && state.Targets == nil) {
+		p.stateMu.Unlock()
 		return
 	}
 
@@ -465,6 +509,12 @@ func (p *LogParser) refreshWebsiteRanges(websiteID string) {
 	state.RecentCutoffTs = recentCutoff
 	state.BackfillPending = backfillPending
 	p.states[websiteID] = state
+	// 其他网站与来源会继续写入 ParsedHourBuckets，解析状态中保存副本
+	parsedHourBuckets := make(map[int64]bool, len(state.ParsedHourBuckets))
+	for bucket := range state.ParsedHourBuckets {
+		parsedHourBuckets[bucket] = true
+	}
+	p.stateMu.Unlock()
 
 	UpdateWebsiteParseStatus(websiteID, WebsiteParseStatus{
 		LogMinTs:               logMin,
@@ -475,7 +525,7 @@ func (p *LogParser) refreshWebsiteRanges(websiteID string) {
 		BackfillPending:        backfillPending,
 		BackfillTotalBytes:     backfillTotalBytes,
 		BackfillProcessedBytes: backfillProcessedBytes,
-		ParsedHourBuckets:      state.ParsedHourBuckets,
+		ParsedHourBuckets:      parsedHourBuckets,
 	})
 }
 
@@ -556,13 +606,15 @@ func (p *LogParser) ScanNginxLogsForWebsite(websiteID string) []ParserResult {
 
 // ResetScanState 重置日志扫描状态
 func (p *LogParser) ResetScanState(websiteID string) {
+	p.stateMu.Lock()
 	if websiteID == "" {
 		p.states = make(map[string]LogScanState)
-		ResetWebsiteParseStatus("")
 	} else {
 		delete(p.states, websiteID)
-		ResetWebsiteParseStatus(websiteID)
 	}
+	p.stateMu.Unlock()
+	ResetWebsiteParseStatus(websiteID)
+	ResetParseFailureStats(websiteID)
 	p.updateState()
 }
 
@@ -614,55 +666,33 @@ func (p *LogParser) TriggerReparse(websiteID string) error {
 	return nil
 }
 
-func (p *LogParser) scanNginxLogsInternal(websiteIDs []string) []ParserResult {
-	setParsingTotalBytes(p.calculateTotalBytesToScan(websiteIDs))
-	parserResults := make([]ParserResult, len(websiteIDs))
-
-	for i, id := range websiteIDs {
-		startTime := time.Now()
-
-		website, _ := config.GetWebsiteByID(id)
-		parserResult := EmptyParserResult(website.Name, id)
-		if len(website.Sources) > 0 {
-			p.scanSources(id, website, &parserResult)
-		} else {
-			if _, err := p.getLineParser(id); err != nil {
-				parserResult.Success = false
-				parserResult.Error = err
-				parserResults[i] = parserResult
-				continue
-			}
+// scanLogPath 扫描未配置 sources 的网站的 logPath，多个匹配文件共用解析器，依次读取
+func (p *LogParser) scanLogPath(websiteID string, website config.WebsiteConfig, parserResult *ParserResult) {
+	if _, err := p.getLineParser(websiteID); err != nil {
+		parserResult.Success = false
+		parserResult.Error = err
+		return
+	}
 
-			logPath := website.LogPath
-			if strings.Contains(logPath, "*") {
-				matches, err := filepath.Glob(logPath)
-				if err != nil {
-					errstr := "解析日志路径模式 " + logPath + " 失败: " + err.Error()
-					parserResult.Success = false
-					parserResult.Error = errors.New(errstr)
-				} else if len(matches) == 0 {
-					errstr := "日志路径模式 " + logPath + " 未匹配到任何文件"
-					parserResult.Success = false
-					parserResult.Error = errors.New(errstr)
-				} else {
-					for _, matchPath := range matches {
-						p.scanSingleFile(id, matchPath, &parserResult)
-					}
-				}
-			} else {
-				p.scanSingleFile(id, logPath, &parserResult)
-			}
+	logPath := website.LogPath
+	if !strings.Contains(logPath, "*") {
+		p.scanSingleFile(websiteID, logPath, parserResult)
+		return
+	}
+	matches, err := filepath.Glob(logPath)
+	if err != nil {
+		errstr := "解析日志路径模式 " + logPath + " 失败: " + err.Error()
+		parserResult.Success = false
+		parserResult.Error = errors.New(errstr)
+	} else if len(matches) == 0 {
+		errstr := "日志路径模式 " + logPath + " 未匹配到任何文件"
+		parserResult.Success = false
+		parserResult.Error = errors.New(errstr)
+	} else {
+		for _, matchPath := range matches {
+			p.scanSingleFile(websiteID, matchPath, parserResult)
 		}
-
-		p.refreshWebsiteRanges(id)
-		p.updateState()
-		parserResult.Duration = time.Since(startTime)
-		parserResults[i] = parserResult
 	}
-
-	p.updateState()
-
-	return parserResults
 }
 
 func (p *LogParser) calculateTotalBytesToScan(websiteIDs []string) int64 {
@@ -701,7 +731,7 @@ func (p *LogParser) scanableBytes(websiteID, logPath string) int64 {
 
 	currentSize := fileInfo.Size()
 	startOffset := p.determineStartOffset(websiteID, logPath, currentSize)
-	if isGzipFile(logPath) {
+	if source.FileCodec(logPath).Compressed() {
 		if startOffset < 0 {
 			return 0
 		}
@@ -780,7 +810,8 @@ func (p *LogParser) scanSingleFile(
 	}
 
 	currentSize := fileInfo.Size()
-	isGzip := isGzipFile(logPath)
+	codec := source.FileCodec(logPath)
+	compressed := codec.Compressed()
 
 	parser, err := p.getLineParser(websiteID)
 	if err != nil {
@@ -789,11 +820,25 @@ func (p *LogParser) scanSingleFile(
 		return
 	}
 
+	device, inode := source.FileID(fileInfo)
+	head := localHeadFingerprint(logPath, codec)
 	fileState, ok := p.getFileState(websiteID, logPath)
-	if ok && currentSize < fileState.LastSize {
+	if ok && (currentSize < fileState.LastSize || !fileState.sameFile(device, inode, head)) {
 		logrus.Infof("检测到网站 %s 的日志文件 %s 已被轮转，从头开始扫描", websiteID, logPath)
 		ok = false
-		p.deleteFileState(websiteID, logPath)
+		p.retireFileState(websiteID, logPath, fileState, parserResult)
+	}
+	if !ok {
+		if inherited, found := p.adoptRotatedFileState(websiteID, logPath, device, inode, head); found {
+			logrus.Infof("网站 %s 的日志文件 %s 由已扫描的文件轮转而来，从上次位置继续扫描", websiteID, logPath)
+			fileState = inherited
+			ok = true
+			if compressed {
+				// 压缩归档按解压后的偏移跳过已导入的内容
+				fileState.LastSize = 0
+			}
+			p.setFileState(websiteID, logPath, fileState)
+		}
 	}
 
 	if !ok {
@@ -802,29 +847,30 @@ func (p *LogParser) scanSingleFile(
 		cutoffTs := cutoff.Unix()
 		fileState.RecentCutoffTs = cutoffTs
 
-		p.initFileRange(file, parser, fileInfo, isGzip, &fileState)
+		parser.RestoreFields(nil)
+		p.initFileRange(file, parser, fileInfo, codec, &fileState)
 
-		if isGzip {
+		if compressed {
 			if fileInfo.ModTime().After(cutoff) || fileInfo.ModTime().Equal(cutoff) {
 				if _, err := file.Seek(0, 0); err == nil {
-					if gzReader, err := gzip.NewReader(file); err == nil {
+					if decompressed, err := source.NewDecompressor(codec, file); err == nil {
 						entriesCount, _, minTs, maxTs := p.parseLogLines(
-							gzReader, websiteID, "", parserResult, parseWindow{minTs: cutoffTs},
+							decompressed, websiteID, lineOrigin{target: logPath}, parserResult, parseWindow{minTs: cutoffTs},
 						)
-						gzReader.Close()
+						decompressed.Close()
 						p.updateParsedRange(&fileState, minTs, maxTs)
 						if maxTs > fileState.LastTimestamp {
 							fileState.LastTimestamp = maxTs
 						}
 						if entriesCount > 0 {
-							logrus.Infof("网站 %s 的 gzip 日志文件 %s 扫描完成，解析了 %d 条记录",
-								websiteID, logPath, entriesCount)
+							logrus.Infof("网站 %s 的 %s 日志文件 %s 扫描完成，解析了 %d 条记录",
+								websiteID, codec, logPath, entriesCount)
 						}
 					} else {
-						logrus.Errorf("无法解析 gzip 日志文件 %s: %v", logPath, err)
+						logrus.Errorf("无法解析 %s 日志文件 %s: %v", codec, logPath, err)
 					}
 				} else {
-					logrus.Errorf("无法重置 gzip 文件 %s: %v", logPath, err)
+					logrus.Errorf("无法重置 %s 文件 %s: %v", codec, logPath, err)
 				}
 			}
 
@@ -833,6 +879,8 @@ func (p *LogParser) scanSingleFile(
 			fileState.BackfillOffset = 0
 			fileState.BackfillEnd = 0
 			fileState.BackfillDone = fileState.FirstTimestamp > 0 && fileState.FirstTimestamp >= cutoffTs
+			fileState.Fields = parser.CurrentFields()
+			fileState.refresh(device, inode, currentSize, head)
 			p.setFileState(websiteID, logPath, fileState)
 			return
 		}
@@ -859,7 +907,7 @@ func (p *LogParser) scanSingleFile(
 				logrus.Errorf("无法设置文件读取位置 %s: %v", logPath, err)
 			} else {
 				entriesCount, _, minTs, maxTs := p.parseLogLines(
-					file, websiteID, "", parserResult, parseWindow{minTs: cutoffTs},
+					file, websiteID, lineOrigin{target: logPath, offset: recentOffset}, parserResult, parseWindow{minTs: cutoffTs},
 				)
 				p.updateParsedRange(&fileState, minTs, maxTs)
 				if maxTs > fileState.LastTimestamp {
@@ -872,6 +920,8 @@ func (p *LogParser) scanSingleFile(
 			}
 		}
 
+		fileState.Fields = parser.CurrentFields()
+		fileState.refresh(device, inode, currentSize, head)
 		p.setFileState(websiteID, logPath, fileState)
 		return
 	}
@@ -880,7 +930,7 @@ func (p *LogParser) scanSingleFile(
 	if startOffset < 0 {
 		return
 	}
-	if !isGzip && currentSize <= startOffset {
+	if !compressed && currentSize <= startOffset {
 		return
 	}
 
@@ -888,58 +938,64 @@ func (p *LogParser) scanSingleFile(
 		reader io.Reader
 		closer io.Closer
 	)
-	if isGzip {
+	if compressed {
+		parser.RestoreFields(nil)
 		if _, err = file.Seek(0, 0); err != nil {
 			logrus.Errorf("无法设置文件读取位置 %s: %v", logPath, err)
 			return
 		}
-		gzReader, err := gzip.NewReader(file)
+		decompressed, err := source.NewDecompressor(codec, file)
 		if err != nil {
-			logrus.Errorf("无法解析 gzip 日志文件 %s: %v", logPath, err)
+			logrus.Errorf("无法解析 %s 日志文件 %s: %v", codec, logPath, err)
 			return
 		}
 		if startOffset > 0 {
-			if err := skipReaderBytes(gzReader, startOffset); err != nil {
-				logrus.Warnf("跳过 gzip 历史内容失败，将重新解析文件 %s: %v", logPath, err)
-				gzReader.Close()
+			if err := skipReaderBytes(decompressed, startOffset); err != nil {
+				logrus.Warnf("跳过 %s 历史内容失败，将重新解析文件 %s: %v", codec, logPath, err)
+				decompressed.Close()
 				if _, err := file.Seek(0, 0); err != nil {
-					logrus.Errorf("无法重置 gzip 文件 %s: %v", logPath, err)
+					logrus.Errorf("无法重置 %s 文件 %s: %v", codec, logPath, err)
 					return
 				}
-				gzReader, err = gzip.NewReader(file)
+				decompressed, err = source.NewDecompressor(codec, file)
 				if err != nil {
-					logrus.Errorf("无法重新解析 gzip 日志文件 %s: %v", logPath, err)
+					logrus.Errorf("无法重新解析 %s 日志文件 %s: %v", codec, logPath, err)
 					return
 				}
 				startOffset = 0
 			}
 		}
-		reader = gzReader
-		closer = gzReader
+		reader = decompressed
+		closer = decompressed
 	} else {
 		if _, err = file.Seek(startOffset, 0); err != nil {
 			logrus.Errorf("无法设置文件读取位置 %s: %v", logPath, err)
 			return
 		}
+		parser.RestoreFields(fileState.Fields)
 		reader = file
 	}
 
-	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(reader, websiteID, "", parserResult, parseWindow{})
+	entriesCount, bytesRead, minTs, maxTs := p.parseLogLines(
+		reader, websiteID, lineOrigin{target: logPath, offset: startOffset}, parserResult, parseWindow{},
+	)
 	if closer != nil {
 		closer.Close()
 	}
 
-	if isGzip {
+	if compressed {
 		fileState.LastOffset = startOffset + bytesRead
 	} else {
 		fileState.LastOffset = currentSize
 	}
 	fileState.LastSize = currentSize
+	fileState.Fields = parser.CurrentFields()
 	p.updateParsedRange(&fileState, minTs, maxTs)
 	if maxTs > fileState.LastTimestamp {
 		fileState.LastTimestamp = maxTs
 	}
 
+	fileState.refresh(device, inode, currentSize, head)
 	p.setFileState(websiteID, logPath, fileState)
 
 	if entriesCount > 0 {
@@ -952,22 +1008,7 @@ func (p *LogParser) scanSingleFile(
 func (p *LogParser) determineStartOffset(
 	websiteID string, filePath string, currentSize int64) int64 {
 
-	state, ok := p.states[websiteID]
-	if !ok { // 网站没有扫描记录，创建新状态
-		p.states[websiteID] = LogScanState{
-			Files: make(map[string]FileState),
-		}
-		return 0
-	}
-
-	if state.Files == nil {
-		state.Files = make(map[string]FileState)
-		p.states[websiteID] = state
-		return 0
-	}
-
-	normalizedPath := normalizeLogPath(filePath)
-	fileState, ok := state.Files[normalizedPath]
+	fileState, ok := p.getFileState(websiteID, filePath)
 	if !ok {
 		return 0
 	}
@@ -978,7 +1019,7 @@ func (p *LogParser) determineStartOffset(
 		return 0
 	}
 
-	if isGzipFile(filePath) {
+	if source.FileCodec(filePath).Compressed() {
 		if currentSize == fileState.LastSize {
 			return -1
 		}
@@ -992,11 +1033,11 @@ func (p *LogParser) initFileRange(
 	file *os.File,
 	parser *logLineParser,
 	info os.FileInfo,
-	isGzip bool,
+	codec source.Codec,
 	state *FileState,
 ) {
 	if state.FirstTimestamp == 0 {
-		if firstTs, err := p.readFirstTimestamp(file, parser, isGzip); err == nil {
+		if firstTs, err := p.readFirstTimestamp(file, parser, codec); err == nil {
 			state.FirstTimestamp = firstTs
 		}
 	}
@@ -1023,7 +1064,7 @@ func (p *LogParser) updateParsedRange(state *FileState, minTs, maxTs int64) {
 func (p *LogParser) readFirstTimestamp(
 	file *os.File,
 	parser *logLineParser,
-	isGzip bool,
+	codec source.Codec,
 ) (int64, error) {
 	if _, err := file.Seek(0, 0); err != nil {
 		return 0, err
@@ -1031,19 +1072,19 @@ func (p *LogParser) readFirstTimestamp(
 
 	var reader io.Reader = file
 	var closer io.Closer
-	if isGzip {
-		gzReader, err := gzip.NewReader(file)
+	if codec.Compressed() {
+		decompressed, err := source.NewDecompressor(codec, file)
 		if err != nil {
 			return 0, err
 		}
-		reader = gzReader
-		closer = gzReader
+		reader = decompressed
+		closer = decompressed
 	}
 
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
-		ts, err := p.parseLogTimestamp(parser, line)
+		ts, err := parser.ParseTimestamp(line)
 		if err == nil {
 			if closer != nil {
 				closer.Close()
@@ -1123,7 +1164,7 @@ func (p *LogParser) findRecentOffset(
 			if len(line) == 0 {
 				continue
 			}
-			ts, err := p.parseLogTimestamp(parser, string(line))
+			ts, err := parser.ParseTimestamp(string(line))
 			if err != nil {
 				continue
 			}
@@ -1152,8 +1193,10 @@ func (p *LogParser) findRecentOffset(
 
 // parseLogLines 解析日志行并返回解析的记录数
 func (p *LogParser) parseLogLines(
-	reader io.Reader, websiteID, sourceID string, parserResult *ParserResult, window parseWindow) (int, int64, int64, int64) {
+	reader io.Reader, websiteID string, origin lineOrigin, parserResult *ParserResult, window parseWindow) (int, int64, int64, int64) {
 	scanner := bufio.NewScanner(reader)
+	rejects := p.newRejectCollector(websiteID, origin)
+	defer rejects.finish()
 	entriesCount := 0
 	var minTs int64
 	var maxTs int64
@@ -1180,12 +1223,19 @@ func (p *LogParser) parseLogLines(
 		batch = batch[:0] // 清空批次但保留容量
 	}
 
+	// docker 来源需要先拆开外层 JSON 并合并分段
+	var joiner *dockerLineJoiner
+	if parser, err := p.getLineParserForSource(websiteID, origin.sourceID); err == nil && parser.envelope != nil {
+		joiner = newDockerLineJoiner(parser.envelope)
+	}
+
 	// 逐行处理
 	const progressChunk = int64(64 * 1024)
 	var pendingBytes int64
 	var totalBytes int64
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineOffset := totalBytes
 		lineBytes := int64(len(line) + 1)
 		pendingBytes += lineBytes
 		totalBytes += lineBytes
@@ -1194,10 +1244,27 @@ func (p *LogParser) parseLogLines(
 			pendingBytes = 0
 		}
 
-		entry, err := p.parseLogLine(websiteID, sourceID, line)
+		var lineTime time.Time
+		if joiner != nil {
+			unwrapped, ok, err := joiner.push(line, lineOffset)
+			if err != nil {
+				rejects.observe(line, lineOffset, err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			line, lineOffset, lineTime = unwrapped.text, unwrapped.offset, unwrapped.time
+		}
+
+		entry, err := p.parseLogLine(websiteID, origin.sourceID, line)
+		rejects.observe(line, lineOffset, err)
 		if err != nil {
 			continue
 		}
+		if !lineTime.IsZero() {
+			entry.Timestamp = lineTime.In(entry.Timestamp.Location())
+		}
 		ts := entry.Timestamp.Unix()
 		if !window.allows(ts) {
 			continue
@@ -1227,6 +1294,12 @@ func (p *LogParser) parseLogLines(
 	if err := scanner.Err(); err != nil {
 		logrus.Errorf("扫描网站 %s 的文件时出错: %v", websiteID, err)
 	}
+	if joiner != nil {
+		// 末尾未写完的分段下次从其起始位置重新读取
+		if start, ok := joiner.unfinished(); ok {
+			totalBytes = start
+		}
+	}
 
 	p.recordParsedHourBuckets(websiteID, parsedBuckets)
 	return entriesCount, totalBytes, minTs, maxTs // 返回当前文件的日志条数
@@ -1234,10 +1307,22 @@ func (p *LogParser) parseLogLines(
 
 // IngestLines parses and inserts streamed log lines for a website/source.
 func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int, int, error) {
+	return p.ingestPushed(websiteID, sourceID, len(lines), func(i int) (string, *store.NginxLogRecord, error) {
+		entry, err := p.parseLogLine(websiteID, sourceID, lines[i])
+		return lines[i], entry, err
+	})
+}
+
+// ingestPushed 写入推送的日志，parse 返回第 i 条的原文（用于去重与解析失败记录）及解析结果
+func (p *LogParser) ingestPushed(
+	websiteID, sourceID string,
+	count int,
+	parse func(i int) (string, *store.NginxLogRecord, error)) (int, int, error) {
+
 	if websiteID == "" {
 		return 0, 0, errors.New("websiteID 不能为空")
 	}
-	if len(lines) == 0 {
+	if count == 0 {
 		return 0, 0, nil
 	}
 	if _, err := p.getLineParserForSource(websiteID, sourceID); err != nil {
@@ -1245,8 +1330,11 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 	}
 
 	batch := make([]store.NginxLogRecord, 0, p.parseBatchSize)
+	batchKeys := make([]string, 0, p.parseBatchSize)
 	accepted := 0
 	deduped := 0
+	rejects := p.newRejectCollector(websiteID, lineOrigin{sourceID: sourceID, target: "stream", offset: -1})
+	defer rejects.finish()
 	var minTs int64
 	var maxTs int64
 	parsedBuckets := make(map[int64]struct{})
@@ -1258,15 +1346,23 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 		// 先标记 location 为“待解析”，再在成功落库后写入 ip_geo_pending（避免竞态导致“待解析”长期不变）
 		p.markBatchIPGeoPending(batch)
 		if err := p.repo.BatchInsertLogsForWebsite(websiteID, batch); err != nil {
+			// 写入失败的行不计入去重，发送方重试时可以重新写入
+			if p.dedup != nil {
+				for _, key := range batchKeys {
+					p.dedup.Forget(key)
+				}
+			}
 			return err
 		}
 		p.enqueueBatchIPGeo(batch)
 		batch = batch[:0]
+		batchKeys = batchKeys[:0]
 		return nil
 	}
 
-	for _, line := range lines {
-		entry, err := p.parseLogLine(websiteID, sourceID, line)
+	for i := 0; i < count; i++ {
+		line, entry, err := parse(i)
+		rejects.observe(line, 0, err)
 		if err != nil {
 			continue
 		}
@@ -1276,6 +1372,7 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 			continue
 		}
 		batch = append(batch, *entry)
+		batchKeys = append(batchKeys, key)
 		accepted++
 		ts := entry.Timestamp.Unix()
 		bucket := (ts / 3600) * 3600
@@ -1300,14 +1397,13 @@ func (p *LogParser) IngestLines(websiteID, sourceID string, lines []string) (int
 
 	if accepted > 0 {
 		p.recordParsedHourBuckets(websiteID, parsedBuckets)
-		targetKey := buildTargetStateKey(sourceID, "stream")
-		state, _ := p.getTargetState(websiteID, targetKey)
-		if state.RecentCutoffTs == 0 {
-			state.RecentCutoffTs = time.Now().AddDate(0, 0, -recentLogWindowDays).Unix()
-		}
-		updateTargetParsedRange(&state, minTs, maxTs)
-		state.BackfillDone = true
-		p.setTargetState(websiteID, targetKey, state)
+		p.updateTargetState(websiteID, buildTargetStateKey(sourceID, "stream"), func(state *TargetState) {
+			if state.RecentCutoffTs == 0 {
+				state.RecentCutoffTs = time.Now().AddDate(0, 0, -recentLogWindowDays).Unix()
+			}
+			updateTargetParsedRange(state, minTs, maxTs)
+			state.BackfillDone = true
+		})
 		p.refreshWebsiteRanges(websiteID)
 		p.updateState()
 	}
@@ -1368,8 +1464,24 @@ func (p *LogParser) enqueueBatchIPGeo(batch []store.NginxLogRecord) {
 	}
 }
 
-func isGzipFile(filePath string) bool {
-	return strings.HasSuffix(strings.ToLower(filePath), ".gz")
+// openDecompressed 解压 reader 的内容，关闭时同时关闭 reader
+func openDecompressed(reader io.ReadCloser, codec source.Codec) (io.ReadCloser, error) {
+	decompressed, err := source.NewDecompressor(codec, reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &decompressedReadCloser{ReadCloser: decompressed, file: reader}, nil
+}
+
+type decompressedReadCloser struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (r *decompressedReadCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
 }
 
 func skipReaderBytes(reader io.Reader, offset int64) error {
@@ -1389,6 +1501,8 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 	if sourceID != "" {
 		key = websiteID + ":" + sourceID
 	}
+	p.lineParsersMu.Lock()
+	defer p.lineParsersMu.Unlock()
 	if parser, ok := p.lineParsers[key]; ok {
 		return parser, nil
 	}
@@ -1408,248 +1522,20 @@ func (p *LogParser) getLineParserForSource(websiteID, sourceID string) (*logLine
 		}
 	}
 
-	parser, err := newLogLineParser(website, sourceCfg)
+	lineParser, err := parse.New(website, sourceCfg)
 	if err != nil {
 		return nil, err
 	}
+	parser := &logLineParser{
+		LineParser: lineParser,
+		route:      buildHostRoute(website, sourceCfg),
+		envelope:   newDockerEnvelope(sourceCfg),
+	}
 
 	p.lineParsers[key] = parser
 	return parser, nil
 }
 
-func newLogLineParser(website config.WebsiteConfig, sourceCfg *config.SourceConfig) (*logLineParser, error) {
-	logType := strings.ToLower(strings.TrimSpace(website.LogType))
-	logFormat := website.LogFormat
-	logRegex := website.LogRegex
-	timeLayout := website.TimeLayout
-
-	if sourceCfg != nil && sourceCfg.Parse != nil {
-		parseOverride := sourceCfg.Parse
-		if strings.TrimSpace(parseOverride.LogType) != "" {
-			logType = strings.ToLower(strings.TrimSpace(parseOverride.LogType))
-		}
-		if strings.TrimSpace(parseOverride.LogFormat) != "" {
-			logFormat = parseOverride.LogFormat
-		}
-		if strings.TrimSpace(parseOverride.LogRegex) != "" {
-			logRegex = parseOverride.LogRegex
-		}
-		if strings.TrimSpace(parseOverride.TimeLayout) != "" {
-			timeLayout = parseOverride.TimeLayout
-		}
-	}
-	if logType == "" {
-		logType = "nginx"
-	}
-
-	pattern := defaultNginxLogRegex
-	source := "default"
-	parseType := parseTypeRegex
-
-	if strings.TrimSpace(logRegex) != "" {
-		pattern = ensureAnchors(logRegex)
-		source = "logRegex"
-	} else if strings.TrimSpace(logFormat) != "" {
-		compiled, err := buildRegexFromFormat(logFormat)
-		if err != nil {
-			return nil, err
-		}
-		pattern = compiled
-		source = "logFormat"
-	} else if logType == "caddy" {
-		return &logLineParser{
-			timeLayout: timeLayout,
-			source:     "caddy",
-			parseType:  parseTypeCaddyJSON,
-		}, nil
-	} else if logType != "nginx" {
-		return nil, fmt.Errorf("不支持的日志类型: %s", logType)
-	}
-
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, fmt.Errorf("日志格式正则无效 (%s): %w", source, err)
-	}
-
-	indexMap := make(map[string]int)
-	for i, name := range regex.SubexpNames() {
-		if name != "" {
-			indexMap[name] = i
-		}
-	}
-
-	if err := validateLogPattern(indexMap); err != nil {
-		return nil, err
-	}
-
-	return &logLineParser{
-		regex:      regex,
-		indexMap:   indexMap,
-		timeLayout: timeLayout,
-		source:     source,
-		parseType:  parseType,
-	}, nil
-}
-
-func ensureAnchors(pattern string) string {
-	trimmed := strings.TrimSpace(pattern)
-	if trimmed == "" {
-		return trimmed
-	}
-	if !strings.HasPrefix(trimmed, "^") {
-		trimmed = "^" + trimmed
-	}
-	if !strings.HasSuffix(trimmed, "$") {
-		trimmed = trimmed + "$"
-	}
-	return trimmed
-}
-
-func buildRegexFromFormat(format string) (string, error) {
-	if strings.TrimSpace(format) == "" {
-		return "", errors.New("logFormat 不能为空")
-	}
-
-	varPattern := regexp.MustCompile(`\$\w+`)
-	locations := varPattern.FindAllStringIndex(format, -1)
-	if len(locations) == 0 {
-		return "", errors.New("logFormat 未包含任何变量")
-	}
-
-	var builder strings.Builder
-	usedNames := make(map[string]bool)
-	last := 0
-	for _, loc := range locations {
-		literal := format[last:loc[0]]
-		builder.WriteString(regexp.QuoteMeta(literal))
-
-		varName := format[loc[0]+1 : loc[1]]
-		quoted := isQuotedTokenBoundary(literal, format[loc[1]:])
-		builder.WriteString(tokenRegexForVar(varName, usedNames, quoted))
-		last = loc[1]
-	}
-	builder.WriteString(regexp.QuoteMeta(format[last:]))
-
-	return "^" + builder.String() + "$", nil
-}
-
-func tokenRegexForVar(name string, used map[string]bool, quoted bool) string {
-	addGroup := func(group, pattern string) string {
-		if used[group] {
-			return pattern
-		}
-		used[group] = true
-		return "(?P<" + group + ">" + pattern + ")"
-	}
-
-	commaListPattern := `[^,\s]+(?:,\s*[^,\s]+)*`
-	optionalTokenPattern := `\S*`
-	optionalQuotedPattern := `[^"]*`
-	requiredTokenPattern := `\S+`
-	requiredQuotedPattern := `[^"]+`
-	if quoted {
-		optionalTokenPattern = optionalQuotedPattern
-		requiredTokenPattern = requiredQuotedPattern
-	}
-
-	switch name {
-	case "remote_addr":
-		return addGroup("ip", requiredTokenPattern)
-	case "http_x_forwarded_for":
-		return addGroup("http_x_forwarded_for", commaListPattern)
-	case "remote_user":
-		return addGroup("user", optionalTokenPattern)
-	case "time_local":
-		return addGroup("time", `[^]]+`)
-	case "time_iso8601":
-		return addGroup("time", requiredTokenPattern)
-	case "request":
-		return addGroup("request", requiredTokenPattern)
-	case "request_method":
-		return addGroup("method", requiredTokenPattern)
-	case "request_uri", "uri":
-		return addGroup("url", requiredTokenPattern)
-	case "args":
-		return addGroup("args", optionalTokenPattern)
-	case "query_string":
-		return addGroup("query_string", optionalTokenPattern)
-	case "status":
-		return addGroup("status", `\d{3}`)
-	case "body_bytes_sent", "bytes_sent":
-		return addGroup("bytes", `\d+`)
-	case "http_referer":
-		return addGroup("referer", optionalTokenPattern)
-	case "http_user_agent":
-		return addGroup("ua", optionalTokenPattern)
-	case "host":
-		return addGroup("host", requiredTokenPattern)
-	case "http_host":
-		return addGroup("host", requiredTokenPattern)
-	case "server_name":
-		return addGroup("server_name", requiredTokenPattern)
-	case "scheme":
-		return addGroup("scheme", requiredTokenPattern)
-	case "request_length":
-		return addGroup("request_length", `\d+`)
-	case "remote_port":
-		return addGroup("remote_port", `\d+`)
-	case "connection":
-		return addGroup("connection", `\d+`)
-	case "request_time_msec":
-		return addGroup("request_time_msec", `\d+(?:\.\d+)?`)
-	case "upstream_addr":
-		return addGroup("upstream_addr", commaListPattern)
-	case "upstream_status":
-		return addGroup("upstream_status", commaListPattern)
-	case "upstream_response_time":
-		return addGroup("upstream_response_time", commaListPattern)
-	case "upstream_connect_time":
-		return addGroup("upstream_connect_time", commaListPattern)
-	case "upstream_header_time":
-		return addGroup("upstream_header_time", commaListPattern)
-	default:
-		return optionalTokenPattern
-	}
-}
-
-func isQuotedTokenBoundary(prefix, suffix string) bool {
-	prefixTrim := strings.TrimRight(prefix, " \t\r\n")
-	if !strings.HasSuffix(prefixTrim, "\"") {
-		return false
-	}
-	suffixTrim := strings.TrimLeft(suffix, " \t\r\n")
-	return strings.HasPrefix(suffixTrim, "\"")
-}
-
-func validateLogPattern(indexMap map[string]int) error {
-	if len(indexMap) == 0 {
-		return errors.New("logRegex/logFormat 必须包含命名分组")
-	}
-
-	if !hasAnyField(indexMap, ipAliases) {
-		return errors.New("日志格式缺少 IP 字段（ip/remote_addr）")
-	}
-	if !hasAnyField(indexMap, timeAliases) {
-		return errors.New("日志格式缺少时间字段（time/time_local/time_iso8601）")
-	}
-	if !hasAnyField(indexMap, statusAliases) {
-		return errors.New("日志格式缺少状态码字段（status）")
-	}
-	if !hasAnyField(indexMap, urlAliases) && !hasAnyField(indexMap, requestAliases) {
-		return errors.New("日志格式缺少 URL 字段（url/request_uri 或 request）")
-	}
-	return nil
-}
-
-func hasAnyField(indexMap map[string]int, aliases []string) bool {
-	for _, name := range aliases {
-		if _, ok := indexMap[name]; ok {
-			return true
-		}
-	}
-	return false
-}
-
 // parseLogLine 解析单行日志
 func (p *LogParser) parseLogLine(websiteID, sourceID string, line string) (*store.NginxLogRecord, error) {
 	parser, err := p.getLineParserForSource(websiteID, sourceID)
@@ -1657,214 +1543,17 @@ func (p *LogParser) parseLogLine(websiteID, sourceID string, line string) (*stor
 		return nil, err
 	}
 
-	switch parser.parseType {
-	case parseTypeCaddyJSON:
-		return p.parseCaddyJSONLine(line, parser)
-	default:
-		return p.parseRegexLogLine(parser, line)
-	}
-}
-
-func (p *LogParser) parseLogTimestamp(parser *logLineParser, line string) (time.Time, error) {
-	switch parser.parseType {
-	case parseTypeCaddyJSON:
-		decoder := json.NewDecoder(strings.NewReader(line))
-		decoder.UseNumber()
-		var payload map[string]interface{}
-		if err := decoder.Decode(&payload); err != nil {
-			return time.Time{}, err
-		}
-		return parseCaddyTime(payload, parser.timeLayout)
-	default:
-		return p.parseRegexLogTimestamp(parser, line)
-	}
-}
-
-func (p *LogParser) parseRegexLogTimestamp(parser *logLineParser, line string) (time.Time, error) {
-	matches := parser.regex.FindStringSubmatch(line)
-	if len(matches) == 0 {
-		return time.Time{}, errors.New("日志格式不匹配")
-	}
-	rawTime := extractField(matches, parser.indexMap, timeAliases)
-	if rawTime == "" {
-		return time.Time{}, errors.New("日志缺少时间字段")
-	}
-	return parseLogTime(rawTime, parser.timeLayout)
-}
-
-func (p *LogParser) parseRegexLogLine(parser *logLineParser, line string) (*store.NginxLogRecord, error) {
-	matches := parser.regex.FindStringSubmatch(line)
-	if len(matches) == 0 {
-		return nil, errors.New("日志格式不匹配")
-	}
-
-	ip := extractField(matches, parser.indexMap, ipAliases)
-	rawTime := extractField(matches, parser.indexMap, timeAliases)
-	statusStr := extractField(matches, parser.indexMap, statusAliases)
-	urlValue := extractField(matches, parser.indexMap, urlAliases)
-	method := extractField(matches, parser.indexMap, methodAliases)
-	requestLine := extractField(matches, parser.indexMap, requestAliases)
-
-	if method == "" || urlValue == "" {
-		if requestLine != "" {
-			parsedMethod, parsedURL, err := parseRequestLine(requestLine)
-			if err != nil {
-				return nil, err
-			}
-			if method == "" {
-				method = parsedMethod
-			}
-			if urlValue == "" {
-				urlValue = parsedURL
-			}
-		}
-	}
-
-	if ip == "" || rawTime == "" || statusStr == "" || urlValue == "" {
-		return nil, errors.New("日志缺少必要字段")
-	}
-
-	timestamp, err := parseLogTime(rawTime, parser.timeLayout)
-	if err != nil {
-		return nil, err
-	}
-
-	statusCode, err := strconv.Atoi(statusStr)
-	if err != nil {
-		return nil, err
-	}
-
-	bytesSent := 0
-	bytesStr := extractField(matches, parser.indexMap, bytesAliases)
-	if bytesStr != "" && bytesStr != "-" {
-		if parsed, err := strconv.Atoi(bytesStr); err == nil {
-			bytesSent = parsed
-		}
-	}
-
-	referPath := extractField(matches, parser.indexMap, refererAliases)
-
-	userAgent := extractField(matches, parser.indexMap, userAgentAliases)
-	return p.buildLogRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
-}
-
-func (p *LogParser) parseCaddyJSONLine(line string, parser *logLineParser) (*store.NginxLogRecord, error) {
-	decoder := json.NewDecoder(strings.NewReader(line))
-	decoder.UseNumber()
-
-	var payload map[string]interface{}
-	if err := decoder.Decode(&payload); err != nil {
-		return nil, err
-	}
-
-	request := getMap(payload, "request")
-	headers := getMap(request, "headers")
-
-	ip := getString(request, "remote_ip")
-	if ip == "" {
-		ip = getString(request, "client_ip")
-	}
-	if ip == "" {
-		ip = getString(payload, "remote_ip")
-	}
-
-	method := getString(request, "method")
-	urlValue := getString(request, "uri")
-
-	statusCode, ok := getInt(payload, "status")
-	if !ok {
-		return nil, errors.New("日志缺少状态码")
-	}
-
-	bytesSent, _ := getInt(payload, "size")
-	referPath := getHeader(headers, "Referer")
-	userAgent := getHeader(headers, "User-Agent")
-
-	timestamp, err := parseCaddyTime(payload, parser.timeLayout)
+	record, err := parser.Parse(line)
 	if err != nil {
 		return nil, err
 	}
-
-	return p.buildLogRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
-}
-
-func (p *LogParser) buildLogRecord(
-	ip, method, urlValue, referer, userAgent string,
-	statusCode, bytesSent int, timestamp time.Time) (*store.NginxLogRecord, error) {
-
-	ip = normalizeIP(ip)
-	if ip == "" || method == "" || urlValue == "" {
-		return nil, errors.New("日志缺少必要字段")
+	if p.beyondRetention(record.Timestamp) {
+		return nil, errBeyondRetention
 	}
-	if statusCode <= 0 {
-		return nil, errors.New("日志缺少状态码")
-	}
-
-	cutoffTime := time.Now().AddDate(0, 0, -p.retentionDays)
-	if timestamp.Before(cutoffTime) {
-		return nil, errors.New("日志超过保留天数")
+	if !parser.route.accepts(record.Host) {
+		return nil, errHostNotRouted
 	}
-
-	decodedPath, err := url.QueryUnescape(urlValue)
-	if err != nil {
-		decodedPath = urlValue
-	}
-
-	referPath := referer
-	if referPath != "" {
-		if decodedRefer, err := url.QueryUnescape(referPath); err == nil {
-			referPath = decodedRefer
-		}
-	}
-
-	if userAgent == "" {
-		userAgent = "-"
-	}
-
-	pageviewFlag := enrich.ShouldCountAsPageView(statusCode, decodedPath, ip)
-	browser, os, device := enrich.ParseUserAgent(userAgent)
-
-	return &store.NginxLogRecord{
-		ID:               0,
-		IP:               ip,
-		PageviewFlag:     pageviewFlag,
-		Timestamp:        timestamp,
-		Method:           method,
-		Url:              decodedPath,
-		Status:           statusCode,
-		BytesSent:        bytesSent,
-		Referer:          referPath,
-		UserBrowser:      browser,
-		UserOs:           os,
-		UserDevice:       device,
-		DomesticLocation: "",
-		GlobalLocation:   "",
-	}, nil
-}
-
-func normalizeIP(raw string) string {
-	ip := strings.TrimSpace(raw)
-	if ip == "" {
-		return ip
-	}
-	if strings.Contains(ip, ",") {
-		parts := strings.Split(ip, ",")
-		if len(parts) > 0 {
-			ip = strings.TrimSpace(parts[0])
-		}
-	}
-	if strings.HasPrefix(ip, "[") {
-		if end := strings.Index(ip, "]"); end > 0 {
-			ip = ip[1:end]
-		}
-		return ip
-	}
-	if strings.Count(ip, ":") == 1 && strings.Contains(ip, ".") {
-		if host, _, err := net.SplitHostPort(ip); err == nil {
-			return host
-		}
-	}
-	return ip
+	return record, nil
 }
 
 func normalizeLogPath(path string) string {
@@ -1879,216 +1568,9 @@ func normalizeLogPath(path string) string {
 	return cleaned
 }
 
-func getMap(source map[string]interface{}, key string) map[string]interface{} {
-	if source == nil {
-		return nil
-	}
-	value, ok := source[key]
-	if !ok {
-		return nil
-	}
-	if mapped, ok := value.(map[string]interface{}); ok {
-		return mapped
-	}
-	return nil
-}
-
-func getString(source map[string]interface{}, key string) string {
-	if source == nil {
-		return ""
-	}
-	value, ok := source[key]
-	if !ok || value == nil {
-		return ""
-	}
-	switch typed := value.(type) {
-	case string:
-		return typed
-	case json.Number:
-		return typed.String()
-	default:
-		return fmt.Sprint(typed)
-	}
-}
-
-func getInt(source map[string]interface{}, key string) (int, bool) {
-	if source == nil {
-		return 0, false
-	}
-	value, ok := source[key]
-	if !ok || value == nil {
-		return 0, false
-	}
-	switch typed := value.(type) {
-	case json.Number:
-		if parsed, err := typed.Int64(); err == nil {
-			return int(parsed), true
-		}
-		if parsed, err := typed.Float64(); err == nil {
-			return int(parsed), true
-		}
-	case float64:
-		return int(typed), true
-	case float32:
-		return int(typed), true
-	case int:
-		return typed, true
-	case int64:
-		return int(typed), true
-	case string:
-		if parsed, err := strconv.Atoi(typed); err == nil {
-			return parsed, true
-		}
-	}
-	return 0, false
-}
-
-func getHeader(headers map[string]interface{}, name string) string {
-	if headers == nil {
-		return ""
-	}
-	for key, value := range headers {
-		if strings.EqualFold(key, name) {
-			switch typed := value.(type) {
-			case []interface{}:
-				if len(typed) > 0 {
-					return fmt.Sprint(typed[0])
-				}
-			case []string:
-				if len(typed) > 0 {
-					return typed[0]
-				}
-			case string:
-				return typed
-			default:
-				return fmt.Sprint(typed)
-			}
-		}
-	}
-	return ""
-}
-
-func parseCaddyTime(payload map[string]interface{}, layout string) (time.Time, error) {
-	if payload == nil {
-		return time.Time{}, errors.New("日志缺少时间字段")
-	}
-	if value, ok := payload["ts"]; ok {
-		if ts, err := parseAnyTime(value, layout); err == nil {
-			return ts, nil
-		}
-	}
-	if value, ok := payload["time"]; ok {
-		if ts, err := parseAnyTime(value, layout); err == nil {
-			return ts, nil
-		}
-	}
-	if value, ok := payload["timestamp"]; ok {
-		if ts, err := parseAnyTime(value, layout); err == nil {
-			return ts, nil
-		}
-	}
-	return time.Time{}, errors.New("日志缺少时间字段")
-}
-
-func parseAnyTime(value interface{}, layout string) (time.Time, error) {
-	switch typed := value.(type) {
-	case json.Number:
-		if parsed, err := typed.Int64(); err == nil {
-			return time.Unix(parsed, 0), nil
-		}
-		if parsed, err := typed.Float64(); err == nil {
-			return parseFloatEpoch(parsed), nil
-		}
-	case float64:
-		return parseFloatEpoch(typed), nil
-	case float32:
-		return parseFloatEpoch(float64(typed)), nil
-	case int:
-		return time.Unix(int64(typed), 0), nil
-	case int64:
-		return time.Unix(typed, 0), nil
-	case string:
-		return parseLogTime(typed, layout)
-	}
-	return time.Time{}, errors.New("时间格式不支持")
-}
-
-func parseFloatEpoch(value float64) time.Time {
-	if value > 1e12 {
-		value = value / 1000
-	}
-	sec := int64(value)
-	nsec := int64((value - float64(sec)) * float64(time.Second))
-	return time.Unix(sec, nsec)
-}
-
-func extractField(matches []string, indexMap map[string]int, aliases []string) string {
-	for _, name := range aliases {
-		if idx, ok := indexMap[name]; ok {
-			if idx > 0 && idx < len(matches) {
-				return matches[idx]
-			}
-		}
-	}
-	return ""
-}
-
-func parseRequestLine(line string) (string, string, error) {
-	parts := strings.Fields(line)
-	if len(parts) < 2 {
-		return "", "", errors.New("无效的 request 格式")
-	}
-	return parts[0], parts[1], nil
-}
-
-func parseLogTime(raw, layout string) (time.Time, error) {
-	if ts, ok := parseEpochTime(raw); ok {
-		return ts, nil
-	}
-
-	layouts := make([]string, 0, 3)
-	if layout != "" {
-		layouts = append(layouts, layout)
-	}
-	layouts = append(layouts, defaultNginxTimeLayout, time.RFC3339, time.RFC3339Nano)
-
-	var lastErr error
-	for _, l := range layouts {
-		parsed, err := time.Parse(l, raw)
-		if err == nil {
-			return parsed, nil
-		}
-		lastErr = err
-	}
-	if lastErr == nil {
-		lastErr = errors.New("时间解析失败")
-	}
-	return time.Time{}, lastErr
-}
-
-func parseEpochTime(raw string) (time.Time, bool) {
-	if raw == "" {
-		return time.Time{}, false
-	}
-
-	for _, r := range raw {
-		if (r < '0' || r > '9') && r != '.' {
-			return time.Time{}, false
-		}
-	}
-
-	value, err := strconv.ParseFloat(raw, 64)
-	if err != nil {
-		return time.Time{}, false
-	}
-
-	if value > 1e12 {
-		value = value / 1000
-	}
-
-	sec := int64(value)
-	nsec := int64((value - float64(sec)) * float64(time.Second))
-	return time.Unix(sec, nsec), true
+// beyondRetention 判断记录是否早于保留天数
+func (p *LogParser) beyondRetention(timestamp time.Time) bool {
+	return timestamp.Before(time.Now().AddDate(0, 0, -p.retentionDays))
 }
 
 // EmptyParserResult 生成空结果
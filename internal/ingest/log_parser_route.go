@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"errors"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+)
+
+// errHostNotRouted 表示记录属于共享日志中的其他站点，应直接跳过而非计为解析失败
+var errHostNotRouted = errors.New("记录的 Host 不属于该站点")
+
+// hostRoute 多个站点共享同一份日志时，按记录的 Host 判断记录是否属于当前站点。
+// 每个站点仍独立维护扫描进度，因此重新解析、回填等操作互不影响。
+type hostRoute struct {
+	domains  []string // 当前站点的 domains
+	others   []string // 共享同一份日志的其他站点的 domains
+	catchAll bool     // 未匹配任何站点（或缺少 Host）的记录归入当前站点
+}
+
+// buildHostRoute 根据全局配置判断站点的日志位置是否与其他站点共享，共享且启用分流时返回路由规则
+func buildHostRoute(website config.WebsiteConfig, sourceCfg *config.SourceConfig) *hostRoute {
+	key := config.LogLocationKey(website, sourceCfg)
+	if key == "" {
+		return nil
+	}
+	websites := config.ReadConfig().Websites
+	indexes, ok := config.SharedLogGroups(websites)[key]
+	if !ok || !config.SharedLogRouted(websites, indexes) {
+		return nil
+	}
+
+	route := &hostRoute{
+		domains:  website.Domains,
+		catchAll: website.CatchAll,
+	}
+	for _, idx := range indexes {
+		if websites[idx].Name == website.Name {
+			continue
+		}
+		route.others = append(route.others, websites[idx].Domains...)
+	}
+	return route
+}
+
+func (r *hostRoute) accepts(host string) bool {
+	if r == nil {
+		return true
+	}
+	host = config.NormalizeHost(host)
+	if host != "" && config.MatchDomain(r.domains, host) {
+		return true
+	}
+	if !r.catchAll {
+		return false
+	}
+	return host == "" || !config.MatchDomain(r.others, host)
+}
+
+// ParserExtractsHost 判断站点（或其来源）的解析配置能否从记录中取得 Host，供配置校验使用。
+// 解析器无法创建（由 ValidateParseConfigs 报告）或字段由日志中的 #Fields 决定（W3C）时返回 true。
+func ParserExtractsHost(website config.WebsiteConfig, sourceCfg *config.SourceConfig) bool {
+	parser, err := parse.New(website, sourceCfg)
+	if err != nil {
+		return true
+	}
+	return parser.ExtractsHost()
+}
@@ -0,0 +1,296 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// msgpack 编解码，仅覆盖 Fluent forward 协议用到的类型
+
+// msgpackMaxDepth 数组/map 的最大嵌套层数
+const msgpackMaxDepth = 64
+
+var (
+	errMsgpackTooLarge = errors.New("msgpack 数据超出长度限制")
+	errMsgpackTooDeep  = errors.New("msgpack 嵌套层数过多")
+)
+
+// msgpackExt 扩展类型，Fluent 的 EventTime 为类型 0
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+type msgpackDecoder struct {
+	reader *bufio.Reader
+	maxLen int // 单个字符串/二进制/容器的长度上限
+	depth  int
+}
+
+func newMsgpackDecoder(reader *bufio.Reader, maxLen int) *msgpackDecoder {
+	return &msgpackDecoder{reader: reader, maxLen: maxLen}
+}
+
+// decode 读取一个值：整数为 int64（超出范围的无符号数为 uint64），str 为 string，bin 为 []byte，
+// 数组为 []interface{}，map 为 map[string]interface{}（非字符串键转为文本）
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	code, err := d.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code >= 0x80 && code <= 0x8f:
+		return d.decodeMap(int(code & 0x0f))
+	case code >= 0x90 && code <= 0x9f:
+		return d.decodeArray(int(code & 0x0f))
+	case code >= 0xa0 && code <= 0xbf:
+		return d.decodeString(int(code & 0x1f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := d.readLength(code - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(length)
+	case 0xc7, 0xc8, 0xc9:
+		length, err := d.readLength(code - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(length)
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := d.readUint(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if value > math.MaxInt64 {
+			return value, nil
+		}
+		return int64(value), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		value, err := d.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := uint(64 - size*8)
+		return int64(value<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (code - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.readLength(code - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(length)
+	case 0xdc, 0xdd:
+		length, err := d.readLength(code - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(length)
+	case 0xde, 0xdf:
+		length, err := d.readLength(code - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(length)
+	default:
+		return nil, fmt.Errorf("不支持的 msgpack 类型 0x%02x", code)
+	}
+}
+
+// readLength 读取 1/2/4 字节（sizeIndex 为 0/1/2）的长度
+func (d *msgpackDecoder) readLength(sizeIndex byte) (int, error) {
+	value, err := d.readUint(1 << sizeIndex)
+	if err != nil {
+		return 0, err
+	}
+	if value > uint64(d.maxLen) {
+		return 0, errMsgpackTooLarge
+	}
+	return int(value), nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.reader, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (d *msgpackDecoder) readBytes(length int) ([]byte, error) {
+	if length > d.maxLen {
+		return nil, errMsgpackTooLarge
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(d.reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (d *msgpackDecoder) decodeString(length int) (string, error) {
+	buf, err := d.readBytes(length)
+	return string(buf), err
+}
+
+func (d *msgpackDecoder) decodeExt(length int) (msgpackExt, error) {
+	extType, err := d.reader.ReadByte()
+	if err != nil {
+		return msgpackExt{}, err
+	}
+	data, err := d.readBytes(length)
+	return msgpackExt{Type: int8(extType), Data: data}, err
+}
+
+func (d *msgpackDecoder) decodeArray(length int) ([]interface{}, error) {
+	if length > d.maxLen {
+		return nil, errMsgpackTooLarge
+	}
+	if d.depth >= msgpackMaxDepth {
+		return nil, errMsgpackTooDeep
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	// 长度来自对端，不按声明长度预分配
+	items := make([]interface{}, 0, min(length, 1024))
+	for i := 0; i < length; i++ {
+		item, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *msgpackDecoder) decodeMap(length int) (map[string]interface{}, error) {
+	if length > d.maxLen {
+		return nil, errMsgpackTooLarge
+	}
+	if d.depth >= msgpackMaxDepth {
+		return nil, errMsgpackTooDeep
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	items := make(map[string]interface{}, min(length, 1024))
+	for i := 0; i < length; i++ {
+		key, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		items[msgpackText(key)] = value
+	}
+	return items, nil
+}
+
+// msgpackText 将 str/bin 转为文本，其他类型按默认格式输出
+func msgpackText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// appendMsgpack 编码响应（HELO/PONG/ack）用到的类型
+func appendMsgpack(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case string:
+		switch length := len(v); {
+		case length <= 31:
+			buf = append(buf, 0xa0|byte(length))
+		case length <= math.MaxUint8:
+			buf = append(buf, 0xd9, byte(length))
+		case length <= math.MaxUint16:
+			buf = append(buf, 0xda)
+			buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+		default:
+			buf = append(buf, 0xdb)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(length))
+		}
+		return append(buf, v...)
+	case []byte:
+		switch length := len(v); {
+		case length <= math.MaxUint8:
+			buf = append(buf, 0xc4, byte(length))
+		case length <= math.MaxUint16:
+			buf = append(buf, 0xc5)
+			buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+		default:
+			buf = append(buf, 0xc6)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(length))
+		}
+		return append(buf, v...)
+	case []interface{}:
+		buf = appendMsgpackHeader(buf, 0x90, 0xdc, len(v))
+		for _, item := range v {
+			buf = appendMsgpack(buf, item)
+		}
+		return buf
+	case map[string]interface{}:
+		buf = appendMsgpackHeader(buf, 0x80, 0xde, len(v))
+		for key, item := range v {
+			buf = appendMsgpack(buf, key)
+			buf = appendMsgpack(buf, item)
+		}
+		return buf
+	default:
+		return appendMsgpack(buf, fmt.Sprint(v))
+	}
+}
+
+// appendMsgpackHeader 写入数组或 map 的长度头，fix 为 fixarray/fixmap 前缀，code16 为 16 位长度的类型码
+func appendMsgpackHeader(buf []byte, fix, code16 byte, length int) []byte {
+	switch {
+	case length <= 15:
+		return append(buf, fix|byte(length))
+	case length <= math.MaxUint16:
+		buf = append(buf, code16)
+		return binary.BigEndian.AppendUint16(buf, uint16(length))
+	default:
+		buf = append(buf, code16+1)
+		return binary.BigEndian.AppendUint32(buf, uint32(length))
+	}
+}
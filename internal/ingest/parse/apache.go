@@ -0,0 +1,159 @@
+package parse
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	apacheCommonLogFormat        = `%h %l %u %t "%r" %>s %b`
+	apacheCombinedLogFormat      = `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`
+	apacheVhostCombinedLogFormat = `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`
+)
+
+// apacheDirectivePattern 匹配 Apache LogFormat 指令，如 %h、%>s、%{Referer}i、%!200,304{User-agent}i
+var apacheDirectivePattern = regexp.MustCompile(`%[<>]?(?:!?\d{3}(?:,\d{3})*)?(?:\{([^}]*)\})?[<>]?([a-zA-Z%])`)
+
+func isApacheLogType(logType string) bool {
+	switch logType {
+	case "apache", "apache_common", "apache_combined", "apache_vhost_combined":
+		return true
+	default:
+		return false
+	}
+}
+
+// apacheFormatForType 返回 Apache 日志类型对应的预置 LogFormat
+func apacheFormatForType(logType string) string {
+	switch logType {
+	case "apache_common":
+		return apacheCommonLogFormat
+	case "apache_vhost_combined":
+		return apacheVhostCombinedLogFormat
+	default:
+		return apacheCombinedLogFormat
+	}
+}
+
+// resolveApacheFormatNickname 支持直接填写 Apache 预置格式名称（common/combined/vhost_combined）
+func resolveApacheFormatNickname(format string) string {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "common":
+		return apacheCommonLogFormat
+	case "combined":
+		return apacheCombinedLogFormat
+	case "vhost_combined":
+		return apacheVhostCombinedLogFormat
+	default:
+		return format
+	}
+}
+
+func buildRegexFromApacheFormat(format string) (string, error) {
+	format = resolveApacheFormatNickname(format)
+	if strings.TrimSpace(format) == "" {
+		return "", errors.New("logFormat 不能为空")
+	}
+
+	locations := apacheDirectivePattern.FindAllStringSubmatchIndex(format, -1)
+	if len(locations) == 0 {
+		return "", errors.New("logFormat 未包含任何 Apache 指令")
+	}
+
+	var builder strings.Builder
+	usedNames := make(map[string]bool)
+	last := 0
+	for _, loc := range locations {
+		literal := format[last:loc[0]]
+		builder.WriteString(regexp.QuoteMeta(literal))
+
+		param := ""
+		if loc[2] >= 0 {
+			param = format[loc[2]:loc[3]]
+		}
+		directive := format[loc[4]:loc[5]]
+		quoted := isQuotedTokenBoundary(literal, format[loc[1]:])
+		builder.WriteString(tokenRegexForApacheDirective(directive, param, usedNames, quoted))
+		last = loc[1]
+	}
+	builder.WriteString(regexp.QuoteMeta(format[last:]))
+
+	return "^" + builder.String() + "$", nil
+}
+
+func tokenRegexForApacheDirective(directive, param string, used map[string]bool, quoted bool) string {
+	addGroup := func(group, pattern string) string {
+		if used[group] {
+			return pattern
+		}
+		used[group] = true
+		return "(?P<" + group + ">" + pattern + ")"
+	}
+
+	optionalTokenPattern := `\S*`
+	requiredTokenPattern := `\S+`
+	if quoted {
+		optionalTokenPattern = `[^"]*`
+		requiredTokenPattern = `[^"]+`
+	}
+
+	switch directive {
+	case "%":
+		return "%"
+	case "h", "a":
+		return addGroup("ip", requiredTokenPattern)
+	case "l":
+		return optionalTokenPattern
+	case "u":
+		return addGroup("user", optionalTokenPattern)
+	case "t":
+		if param == "" {
+			return `\[` + addGroup("time", `[^]]+`) + `\]`
+		}
+		return addGroup("time", `.+?`)
+	case "r":
+		return addGroup("request", requiredTokenPattern)
+	case "m":
+		return addGroup("method", requiredTokenPattern)
+	case "U":
+		return addGroup("url", requiredTokenPattern)
+	case "q":
+		return addGroup("query_string", optionalTokenPattern)
+	case "H":
+		return addGroup("protocol", requiredTokenPattern)
+	case "s":
+		return addGroup("status", `\d{3}`)
+	case "b":
+		return addGroup("bytes", `(?:\d+|-)`)
+	case "B", "O":
+		return addGroup("bytes", `\d+`)
+	case "I":
+		return addGroup("request_length", `\d+`)
+	case "v":
+		return addGroup("server_name", requiredTokenPattern)
+	case "V":
+		return addGroup("host", requiredTokenPattern)
+	case "p":
+		return addGroup("server_port", `\d+`)
+	case "D":
+		return addGroup("request_time_us", `\d+`)
+	case "T":
+		return addGroup("request_time_sec", `\d+(?:\.\d+)?`)
+	case "i":
+		switch strings.ToLower(param) {
+		case "referer":
+			return addGroup("referer", optionalTokenPattern)
+		case "user-agent":
+			return addGroup("ua", optionalTokenPattern)
+		case "host":
+			return addGroup("host", requiredTokenPattern)
+		case "x-forwarded-for":
+			return addGroup("http_x_forwarded_for", `[^,\s]+(?:,\s*[^,\s]+)*`)
+		default:
+			return optionalTokenPattern
+		}
+	default:
+		return optionalTokenPattern
+	}
+}
@@ -0,0 +1,52 @@
+package parse
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AWS Application Load Balancer 访问日志（空格分隔，部分字段带引号）：
+//
+//	http 2025-07-02T22:23:00.186641Z app/my-lb/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" - - arn:... "Root=1-..." "-" "-" 0 ...
+//
+// 请求行中的 URL 为绝对地址，解析时会去掉 scheme 与 host。
+const albLogRegex = `^(?P<type>\S+) (?P<time>\S+) (?P<elb>\S+) ` +
+	`(?P<ip>\S+?):(?P<remote_port>\d+) (?P<target>\S+) ` +
+	`(?P<request_processing_time>\S+) (?P<target_processing_time>\S+) (?P<response_processing_time>\S+) ` +
+	`(?P<status>\S+) (?P<target_status>\S+) (?P<request_length>\d+) (?P<bytes>\d+) ` +
+	`"(?P<request>[^"]*)" "(?P<ua>[^"]*)"(?: .*)?$`
+
+// CloudFront 标准日志字段（制表符分隔，文件头带 #Version/#Fields）
+var defaultCloudFrontFields = []string{
+	"date", "time", "x-edge-location", "sc-bytes", "c-ip", "cs-method", "cs(Host)", "cs-uri-stem",
+	"sc-status", "cs(Referer)", "cs(User-Agent)", "cs-uri-query", "cs(Cookie)", "x-edge-result-type",
+	"x-edge-request-id", "x-host-header", "cs-protocol", "cs-bytes", "time-taken", "x-forwarded-for",
+	"ssl-protocol", "ssl-cipher", "x-edge-response-result-type", "cs-protocol-version", "fle-status",
+	"fle-encrypted-fields", "c-port", "time-to-first-byte", "x-edge-detailed-result-type",
+	"sc-content-type", "sc-content-len", "sc-range-start", "sc-range-end",
+}
+
+func newCloudFrontLineParser(logFormat, timeLayout string) *LineParser {
+	parser := newW3CLineParser(logFormat, timeLayout)
+	if strings.TrimSpace(logFormat) == "" {
+		parser.defaultFields = defaultCloudFrontFields
+	}
+	parser.source = "cloudfront"
+	parser.separator = "\t"
+	parser.urlEncoded = true
+	parser.durationScale = 1000 // CloudFront time-taken 单位为秒
+	return parser
+}
+
+// stripURLOrigin 将绝对 URL（http://host/path?q）转换为请求路径
+func stripURLOrigin(raw string) string {
+	lower := strings.ToLower(raw)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return parsed.RequestURI()
+}
@@ -0,0 +1,17 @@
+package parse
+
+// HAProxy `option httplog` 默认格式：
+//
+//	Feb  6 12:14:14 localhost haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"
+//
+// 依次为 syslog 前缀（可选）、client_ip:port、[accept_date]、frontend、backend/server、Tq/Tw/Tc/Tr/Ta、
+// 状态码、bytes_read、请求/响应 cookie、终止状态、连接计数、队列、捕获的请求/响应头（可选）以及请求行。
+const haproxyHTTPLogRegex = `^(?:.*?(?:\]|haproxy):\s+)?` +
+	`(?P<ip>\S+?):(?P<remote_port>\d+) \[(?P<time>[^\]]+)\] ` +
+	`(?P<frontend>\S+) (?P<backend>[^/\s]+)/(?P<server>\S+) ` +
+	`(?P<tq>-?\d+)/(?P<tw>-?\d+)/(?P<tc>-?\d+)/(?P<tr>-?\d+)/(?P<ta>\+?-?\d+) ` +
+	`(?P<status>-?\d{1,3}) (?P<bytes>\+?\d+) ` +
+	`\S+ \S+ \S+ \S+ \S+(?: \{[^}]*\})* "(?P<request>[^"]*)"\s*$`
+
+// HAProxy accept_date 不带时区，按本地时区解析
+const haproxyTimeLayout = "02/Jan/2006:15:04:05.000"
@@ -0,0 +1,51 @@
+package parse
+
+import (
+	"net/url"
+	"strings"
+)
+
+var hostAliases = []string{"host", "http_host", "server_name"}
+
+// ExtractsHost 判断解析器能否从记录中取得 Host。字段由日志中的 #Fields 决定（W3C）时返回 true。
+func (lp *LineParser) ExtractsHost() bool {
+	switch lp.parseType {
+	case parseTypeCaddyJSON, parseTypeW3C:
+		return true
+	case parseTypeJSON:
+		return len(lp.jsonFields["host"]) > 0
+	default:
+		if lp.stripURL {
+			return true
+		}
+		for _, alias := range hostAliases {
+			if _, ok := lp.indexMap[alias]; ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// hostFromURL 从绝对 URL（如 ALB 请求行）中提取 Host
+func hostFromURL(raw string) string {
+	lower := strings.ToLower(raw)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return ""
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+// w3cHost CloudFront 的 x-host-header 为客户端请求的 Host，cs(Host) 为 CloudFront 分配的域名
+func w3cHost(row map[string]string) string {
+	for _, key := range []string{"x-host-header", "cs-host", "cs(host)"} {
+		if value := row[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,433 @@
+package parse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Traefik JSON 访问日志字段（accessLog.format=json）
+	traefikJSONFields = map[string][]string{
+		"ip":            {"ClientHost", "ClientAddr"},
+		"time":          {"StartUTC", "StartLocal", "time"},
+		"method":        {"RequestMethod"},
+		"url":           {"RequestPath"},
+		"status":        {"DownstreamStatus", "OriginStatus"},
+		"bytes":         {"DownstreamContentSize"},
+		"referer":       {"request_Referer"},
+		"ua":            {"request_User-Agent"},
+		"host":          {"RequestHost"},
+		"request_time":  {"Duration"},
+		"upstream_time": {"OriginDuration"},
+	}
+	// Envoy/Istio 常见 json_format 字段
+	envoyJSONFields = map[string][]string{
+		"ip":            {"downstream_remote_address", "x_forwarded_for", "downstream_direct_remote_address"},
+		"time":          {"start_time", "timestamp"},
+		"method":        {"method"},
+		"url":           {"path", "x_envoy_original_path"},
+		"status":        {"response_code"},
+		"bytes":         {"bytes_sent"},
+		"referer":       {"referer"},
+		"ua":            {"user_agent"},
+		"host":          {"authority", "host"},
+		"request_time":  {"duration"},
+		"upstream_time": {"upstream_service_time", "x_envoy_upstream_service_time"},
+	}
+)
+
+// jsonFieldAliases 返回 JSON 解析使用的标准字段及其别名表，标准字段名为别名表的第一个元素
+func jsonFieldAliases() [][]string {
+	return [][]string{
+		ipAliases,
+		timeAliases,
+		methodAliases,
+		urlAliases,
+		statusAliases,
+		bytesAliases,
+		refererAliases,
+		userAgentAliases,
+		requestAliases,
+		requestTimeAliases,
+		upstreamTimeAliases,
+		hostAliases,
+	}
+}
+
+// canonicalJSONField 将字段名（含别名）归一为标准字段名
+func canonicalJSONField(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, aliases := range jsonFieldAliases() {
+		for _, alias := range aliases {
+			if alias == name {
+				return aliases[0]
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	nginxJSONEscapePrefix = regexp.MustCompile(`^escape=\w+\s+`)
+	nginxJSONSingleVar    = regexp.MustCompile(`^\$(\w+)$`)
+)
+
+func isJSONLogType(logType string) bool {
+	switch logType {
+	case "json", "nginx_json", "traefik", "envoy":
+		return true
+	default:
+		return false
+	}
+}
+
+// buildJSONFieldMap 根据日志类型预置映射与用户 fieldMap 生成字段查找表
+func buildJSONFieldMap(logType string, fieldMap map[string]string) (map[string][]string, error) {
+	fields := make(map[string][]string)
+	switch logType {
+	case "traefik":
+		for key, values := range traefikJSONFields {
+			fields[key] = append([]string(nil), values...)
+		}
+	case "envoy":
+		for key, values := range envoyJSONFields {
+			fields[key] = append([]string(nil), values...)
+		}
+	default:
+		for _, aliases := range jsonFieldAliases() {
+			fields[aliases[0]] = append([]string(nil), aliases...)
+		}
+	}
+
+	if err := applyJSONFieldOverrides(fields, fieldMap); err != nil {
+		return nil, err
+	}
+	if err := validateJSONFieldMap(fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func applyJSONFieldOverrides(fields map[string][]string, fieldMap map[string]string) error {
+	for name, jsonKey := range fieldMap {
+		jsonKey = strings.TrimSpace(jsonKey)
+		if jsonKey == "" {
+			continue
+		}
+		canonical := canonicalJSONField(name)
+		if canonical == "" {
+			return fmt.Errorf("fieldMap 包含不支持的字段: %s", name)
+		}
+		fields[canonical] = append([]string{jsonKey}, fields[canonical]...)
+	}
+	return nil
+}
+
+func validateJSONFieldMap(fields map[string][]string) error {
+	if len(fields["ip"]) == 0 {
+		return errors.New("日志格式缺少 IP 字段（ip/remote_addr）")
+	}
+	if len(fields["time"]) == 0 {
+		return errors.New("日志格式缺少时间字段（time/time_local/time_iso8601）")
+	}
+	if len(fields["status"]) == 0 {
+		return errors.New("日志格式缺少状态码字段（status）")
+	}
+	if len(fields["url"]) == 0 && len(fields["request"]) == 0 {
+		return errors.New("日志格式缺少 URL 字段（url/request_uri 或 request）")
+	}
+	return nil
+}
+
+// isJSONLogFormat 判断 logFormat 是否为 nginx JSON 格式（log_format main escape=json '{...}'）
+func isJSONLogFormat(format string) bool {
+	return strings.HasPrefix(normalizeNginxJSONFormat(format), "{")
+}
+
+// normalizeNginxJSONFormat 去掉 escape= 参数与 nginx 配置中的单引号分段，返回 JSON 模板文本
+func normalizeNginxJSONFormat(format string) string {
+	trimmed := strings.TrimSpace(format)
+	trimmed = nginxJSONEscapePrefix.ReplaceAllString(trimmed, "")
+	trimmed = strings.TrimSuffix(strings.TrimSpace(trimmed), ";")
+	if strings.HasPrefix(trimmed, "'") {
+		var builder strings.Builder
+		inQuote := false
+		for _, r := range trimmed {
+			if r == '\'' {
+				inQuote = !inQuote
+				continue
+			}
+			if inQuote {
+				builder.WriteRune(r)
+			}
+		}
+		trimmed = builder.String()
+	}
+	return strings.TrimSpace(trimmed)
+}
+
+// buildJSONFieldMapFromNginxFormat 解析 nginx JSON 模板，通过别名表把 $变量 映射到对应的 JSON 键
+func buildJSONFieldMapFromNginxFormat(format string, fieldMap map[string]string) (map[string][]string, error) {
+	template := normalizeNginxJSONFormat(format)
+	// 数值类变量常以未加引号的形式出现（"bytes":$body_bytes_sent），补齐引号后再按 JSON 解析
+	template = quoteNginxJSONBareVars(template)
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &payload); err != nil {
+		return nil, fmt.Errorf("logFormat JSON 模板无效: %w", err)
+	}
+
+	type candidate struct {
+		key      string
+		priority int
+	}
+	candidates := make(map[string][]candidate)
+	var collect func(prefix string, node map[string]interface{})
+	collect = func(prefix string, node map[string]interface{}) {
+		for key, value := range node {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			switch typed := value.(type) {
+			case map[string]interface{}:
+				collect(path, typed)
+			case string:
+				matches := nginxJSONSingleVar.FindStringSubmatch(strings.TrimSpace(typed))
+				if len(matches) < 2 {
+					continue
+				}
+				for _, aliases := range jsonFieldAliases() {
+					for idx, alias := range aliases {
+						if alias == matches[1] {
+							candidates[aliases[0]] = append(candidates[aliases[0]], candidate{key: path, priority: idx})
+						}
+					}
+				}
+			}
+		}
+	}
+	collect("", payload)
+
+	fields := make(map[string][]string, len(candidates))
+	for name, list := range candidates {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].priority != list[j].priority {
+				return list[i].priority < list[j].priority
+			}
+			return list[i].key < list[j].key
+		})
+		for _, item := range list {
+			fields[name] = append(fields[name], item.key)
+		}
+	}
+
+	if err := applyJSONFieldOverrides(fields, fieldMap); err != nil {
+		return nil, err
+	}
+	if err := validateJSONFieldMap(fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// quoteNginxJSONBareVars 给 JSON 字符串之外的 $变量 加上引号，字符串内的变量（如 "[$time_local]"）保持不变
+func quoteNginxJSONBareVars(template string) string {
+	var builder strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if inString {
+			builder.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		if c == '"' {
+			inString = true
+			builder.WriteByte(c)
+			continue
+		}
+		if c == '$' {
+			end := i + 1
+			for end < len(template) && isNginxVarByte(template[end]) {
+				end++
+			}
+			if end > i+1 {
+				builder.WriteByte('"')
+				builder.WriteString(template[i:end])
+				builder.WriteByte('"')
+				i = end - 1
+				continue
+			}
+		}
+		builder.WriteByte(c)
+	}
+	return builder.String()
+}
+
+func isNginxVarByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func decodeJSONLine(line string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (lp *LineParser) parseJSONLine(line string) (*Record, error) {
+	payload, err := decodeJSONLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := lp.jsonFields
+	ip := lookupJSONString(payload, fields["ip"])
+	method := lookupJSONString(payload, fields["method"])
+	urlValue := lookupJSONString(payload, fields["url"])
+	if method == "" || urlValue == "" {
+		if requestLine := lookupJSONString(payload, fields["request"]); requestLine != "" {
+			parsedMethod, parsedURL, err := parseRequestLine(requestLine)
+			if err != nil {
+				return nil, err
+			}
+			if method == "" {
+				method = parsedMethod
+			}
+			if urlValue == "" {
+				urlValue = parsedURL
+			}
+		}
+	}
+
+	statusCode, ok := lookupJSONInt(payload, fields["status"])
+	if !ok {
+		return nil, errors.New("日志缺少状态码")
+	}
+	bytesSent, _ := lookupJSONInt(payload, fields["bytes"])
+	referer := lookupJSONString(payload, fields["referer"])
+	userAgent := lookupJSONString(payload, fields["ua"])
+
+	timestamp, err := parseJSONTime(payload, fields["time"], lp.timeLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := BuildRecord(ip, method, urlValue, referer, userAgent, statusCode, bytesSent, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	record.RequestTime, record.UpstreamTime = extractJSONLatency(payload, lp)
+	record.Host = lookupJSONString(payload, lp.jsonFields["host"])
+	return record, nil
+}
+
+func parseJSONTime(payload map[string]interface{}, keys []string, layout string) (time.Time, error) {
+	for _, key := range keys {
+		value, ok := lookupJSONValue(payload, key)
+		if !ok {
+			continue
+		}
+		if ts, err := parseAnyTime(value, layout); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, errors.New("日志缺少时间字段")
+}
+
+// lookupJSONValue 按键名查找字段，键名不存在时按 "." 分隔逐级查找嵌套对象
+func lookupJSONValue(payload map[string]interface{}, key string) (interface{}, bool) {
+	if payload == nil || key == "" {
+		return nil, false
+	}
+	if value, ok := payload[key]; ok && value != nil {
+		return value, true
+	}
+	if !strings.Contains(key, ".") {
+		return nil, false
+	}
+	current := payload
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, ok := current[part]
+		if !ok || value == nil {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return nil, false
+}
+
+func lookupJSONString(payload map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		value, ok := lookupJSONValue(payload, key)
+		if !ok {
+			continue
+		}
+		var text string
+		switch typed := value.(type) {
+		case string:
+			text = typed
+		case json.Number:
+			text = typed.String()
+		case []interface{}:
+			if len(typed) == 0 {
+				continue
+			}
+			text = fmt.Sprint(typed[0])
+		default:
+			text = fmt.Sprint(typed)
+		}
+		if text != "" && text != "-" {
+			return text
+		}
+	}
+	return ""
+}
+
+func lookupJSONInt(payload map[string]interface{}, keys []string) (int, bool) {
+	for _, key := range keys {
+		value, ok := lookupJSONValue(payload, key)
+		if !ok {
+			continue
+		}
+		switch typed := value.(type) {
+		case json.Number:
+			if parsed, err := typed.Int64(); err == nil {
+				return int(parsed), true
+			}
+			if parsed, err := typed.Float64(); err == nil {
+				return int(parsed), true
+			}
+		case string:
+			if parsed, err := strconv.Atoi(strings.TrimSpace(typed)); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return 0, false
+}
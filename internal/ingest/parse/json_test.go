@@ -0,0 +1,152 @@
+package parse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+func TestQuoteNginxJSONBareVars(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name:     "bare number",
+			template: `{"status":$status,"bytes": $body_bytes_sent}`,
+			want:     `{"status":"$status","bytes": "$body_bytes_sent"}`,
+		},
+		{
+			name:     "quoted variable",
+			template: `{"ip":"$remote_addr"}`,
+			want:     `{"ip":"$remote_addr"}`,
+		},
+		{
+			name:     "variable inside brackets in string",
+			template: `{"time":"[$time_local]"}`,
+			want:     `{"time":"[$time_local]"}`,
+		},
+		{
+			name:     "comma separated variables in string",
+			template: `{"req":"$request_method,$uri","status":$status}`,
+			want:     `{"req":"$request_method,$uri","status":"$status"}`,
+		},
+		{
+			name:     "escaped quote in string",
+			template: `{"a":"x\"$y","b":$z}`,
+			want:     `{"a":"x\"$y","b":"$z"}`,
+		},
+		{
+			name:     "escaped backslash before closing quote",
+			template: `{"a":"x\\","b":$z}`,
+			want:     `{"a":"x\\","b":"$z"}`,
+		},
+		{
+			name:     "bare array",
+			template: `{"times":[$request_time, $upstream_response_time]}`,
+			want:     `{"times":["$request_time", "$upstream_response_time"]}`,
+		},
+		{
+			name:     "lone dollar",
+			template: `{"a":$}`,
+			want:     `{"a":$}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quoteNginxJSONBareVars(tt.template); got != tt.want {
+				t.Fatalf("quoteNginxJSONBareVars(%s) = %s, want %s", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildJSONFieldMapFromNginxFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "nginx log_format with escape and quoted segments",
+			format: `escape=json '{"ip":"$remote_addr",' '"ts":"$time_iso8601",' '"status":$status,' ` +
+				`'"uri":"$request_uri","bytes":$body_bytes_sent}'`,
+			want: map[string][]string{
+				"ip":     {"ip"},
+				"time":   {"ts"},
+				"status": {"status"},
+				"url":    {"uri"},
+				"bytes":  {"bytes"},
+			},
+		},
+		{
+			name: "variables embedded in strings are not fields",
+			format: `{"ip":"$remote_addr","local":"[$time_local]","ts":"$time_iso8601",` +
+				`"req":"$request_method,$uri","request":"$request","status":$status}`,
+			want: map[string][]string{
+				"ip":      {"ip"},
+				"time":    {"ts"},
+				"request": {"request"},
+				"status":  {"status"},
+			},
+		},
+		{
+			name:   "nested object",
+			format: `{"client":{"ip":"$remote_addr"},"time":"$time_local","http":{"status":$status,"uri":"$uri"}}`,
+			want: map[string][]string{
+				"ip":     {"client.ip"},
+				"time":   {"time"},
+				"status": {"http.status"},
+				"url":    {"http.uri"},
+			},
+		},
+		{
+			name:    "missing status",
+			format:  `{"ip":"$remote_addr","time":"$time_local","uri":"$uri"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			format:  `{"ip":"$remote_addr",}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildJSONFieldMapFromNginxFormat(tt.format, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，实际得到 %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildJSONFieldMapFromNginxFormat 返回错误: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNginxJSONFormatLine(t *testing.T) {
+	parser, err := NewFromConfig(config.ParseConfig{
+		LogFormat: `escape=json '{"ip":"$remote_addr","local":"[$time_local]","ts":"$time_iso8601",` +
+			`"req":"$request_method,$uri","request":"$request","status":$status,"bytes":$body_bytes_sent}'`,
+	})
+	if err != nil {
+		t.Fatalf("创建解析器失败: %v", err)
+	}
+	record, err := parser.Parse(`{"ip":"1.2.3.4","local":"[18/Oct/2026:10:00:00 +0000]","ts":"2026-10-18T10:00:00+00:00",` +
+		`"req":"GET,/a","request":"GET /a?b=1 HTTP/1.1","status":200,"bytes":512}`)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if record.IP != "1.2.3.4" || record.Method != "GET" || record.Url != "/a?b=1" || record.Status != 200 || record.BytesSent != 512 {
+		t.Fatalf("record = %+v", record)
+	}
+}
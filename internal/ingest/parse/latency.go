@@ -0,0 +1,169 @@
+package parse
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	requestTimeAliases  = []string{"request_time", "duration"}
+	upstreamTimeAliases = []string{"upstream_time", "upstream_response_time"}
+)
+
+// jsonDurationScale 返回 JSON 日志中耗时字段换算为毫秒的倍数
+func jsonDurationScale(logType string) float64 {
+	switch logType {
+	case "traefik":
+		// Traefik 的 Duration/OriginDuration 单位为纳秒
+		return 1e-6
+	case "envoy":
+		// Envoy 的 %DURATION% 与 %RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% 单位为毫秒
+		return 1
+	default:
+		// nginx $request_time / $upstream_response_time 单位为秒
+		return 1000
+	}
+}
+
+// extractRegexLatency 从正则命名分组中提取请求耗时与上游耗时（毫秒）
+func extractRegexLatency(matches []string, indexMap map[string]int) (*float64, *float64) {
+	field := func(name string) string {
+		if idx, ok := indexMap[name]; ok && idx < len(matches) {
+			return strings.TrimSpace(matches[idx])
+		}
+		return ""
+	}
+
+	var requestTime *float64
+	switch {
+	case field("request_time") != "":
+		requestTime = parseDurationList(field("request_time"), 1000)
+	case field("request_time_msec") != "":
+		requestTime = parseDurationList(field("request_time_msec"), 1)
+	case field("request_time_us") != "":
+		// Apache %D 单位为微秒
+		requestTime = parseDurationList(field("request_time_us"), 0.001)
+	case field("request_time_sec") != "":
+		requestTime = parseDurationList(field("request_time_sec"), 1000)
+	case field("ta") != "":
+		// HAProxy Ta 为总耗时（毫秒），option logasap 时带 "+" 前缀
+		requestTime = parseDurationList(strings.TrimPrefix(field("ta"), "+"), 1)
+	case field("request_processing_time") != "":
+		// ALB 三段处理耗时（秒）之和，任一段为 -1 表示请求未完成
+		requestTime = sumDurations(1000,
+			field("request_processing_time"),
+			field("target_processing_time"),
+			field("response_processing_time"),
+		)
+	}
+
+	var upstreamTime *float64
+	switch {
+	case field("upstream_response_time") != "":
+		upstreamTime = parseDurationList(field("upstream_response_time"), 1000)
+	case field("tr") != "":
+		upstreamTime = parseDurationList(field("tr"), 1)
+	case field("target_processing_time") != "":
+		upstreamTime = parseDurationList(field("target_processing_time"), 1000)
+	}
+
+	return requestTime, upstreamTime
+}
+
+// extractJSONLatency 按字段映射从 JSON 日志中提取请求耗时与上游耗时（毫秒）
+func extractJSONLatency(payload map[string]interface{}, lp *LineParser) (*float64, *float64) {
+	scale := lp.durationScale
+	if scale == 0 {
+		scale = 1000
+	}
+	requestTime := lookupJSONDuration(payload, lp.jsonFields["request_time"], scale)
+	upstreamTime := lookupJSONDuration(payload, lp.jsonFields["upstream_time"], scale)
+	return requestTime, upstreamTime
+}
+
+// extractCaddyLatency 读取 Caddy 的 duration 字段：默认为秒（浮点数），
+// 配置 duration_format 为 string 时为 Go duration 字符串（如 "1.5ms"）
+func extractCaddyLatency(payload map[string]interface{}) *float64 {
+	value, ok := payload["duration"]
+	if !ok || value == nil {
+		return nil
+	}
+	switch typed := value.(type) {
+	case json.Number:
+		if parsed, err := typed.Float64(); err == nil && parsed >= 0 {
+			ms := parsed * 1000
+			return &ms
+		}
+	case string:
+		if parsed, err := time.ParseDuration(typed); err == nil && parsed >= 0 {
+			ms := float64(parsed) / float64(time.Millisecond)
+			return &ms
+		}
+		return parseDurationList(typed, 1000)
+	}
+	return nil
+}
+
+func lookupJSONDuration(payload map[string]interface{}, keys []string, scale float64) *float64 {
+	for _, key := range keys {
+		value, ok := lookupJSONValue(payload, key)
+		if !ok {
+			continue
+		}
+		switch typed := value.(type) {
+		case json.Number:
+			if parsed, err := typed.Float64(); err == nil && parsed >= 0 {
+				ms := parsed * scale
+				return &ms
+			}
+		case string:
+			if parsed := parseDurationList(typed, scale); parsed != nil {
+				return parsed
+			}
+		}
+	}
+	return nil
+}
+
+// parseDurationList 解析耗时字段并换算为毫秒。nginx 的 $upstream_response_time 在请求经过多个上游
+// 或内部跳转时形如 "0.012, 0.003 : 0.020"，此时取各段之和；"-" 与负值视为未记录。
+func parseDurationList(raw string, scale float64) *float64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "-" {
+		return nil
+	}
+	var (
+		total float64
+		found bool
+	)
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || r == ':' || r == ' '
+	}) {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			continue
+		}
+		total += value
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	total *= scale
+	return &total
+}
+
+func sumDurations(scale float64, parts ...string) *float64 {
+	var total float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || value < 0 {
+			return nil
+		}
+		total += value
+	}
+	total *= scale
+	return &total
+}
@@ -0,0 +1,839 @@
+// Package parse 将单行访问日志解析为记录，服务端扫描与 agent 本地解析共用，不依赖数据库与日志来源
+package parse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/enrich"
+)
+
+const defaultNginxLogRegex = `^(?P<ip>\S+) - (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<method>\S+) (?P<url>[^"]+) HTTP/\d\.\d" (?P<status>\d+) (?P<bytes>\d+) "(?P<referer>[^"]*)" "(?P<ua>[^"]*)"`
+
+const defaultNginxTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+const (
+	parseTypeRegex     = "regex"
+	parseTypeCaddyJSON = "caddy_json"
+	parseTypeJSON      = "json"
+	parseTypeW3C       = "w3c"
+)
+
+var (
+	ipAliases        = []string{"ip", "remote_addr", "client_ip", "http_x_forwarded_for"}
+	timeAliases      = []string{"time", "time_local", "time_iso8601"}
+	methodAliases    = []string{"method", "request_method"}
+	urlAliases       = []string{"url", "request_uri", "uri", "path"}
+	statusAliases    = []string{"status"}
+	bytesAliases     = []string{"bytes", "body_bytes_sent", "bytes_sent"}
+	refererAliases   = []string{"referer", "http_referer"}
+	userAgentAliases = []string{"ua", "user_agent", "http_user_agent"}
+	requestAliases   = []string{"request", "request_line"}
+)
+
+// LineParser 按一份解析配置解析日志行。W3C/CloudFront 的 #Fields 定义随读取的行变化，
+// 同一解析器不应同时用于多个文件。
+type LineParser struct {
+	regex      *regexp.Regexp
+	indexMap   map[string]int
+	timeLayout string
+	location   *time.Location // 时间不带时区时使用的时区，为空则按 UTC
+	source     string
+	parseType  string
+	stripURL   bool                // 请求行中的 URL 为绝对地址（如 ALB），需去掉 scheme 与 host
+	jsonFields map[string][]string // parseTypeJSON: 标准字段 -> 候选 JSON 键
+
+	durationScale float64 // parseTypeJSON/parseTypeW3C: 耗时字段换算为毫秒的倍数
+
+	// parseTypeW3C: 按 #Fields 指令定义的列切分，fields 为当前生效的定义
+	mu            sync.Mutex
+	separator     string
+	urlEncoded    bool
+	defaultFields []string
+	fields        []string
+}
+
+// EffectiveConfig 合并站点与来源的解析配置，来源的 parse 覆盖站点配置
+func EffectiveConfig(website config.WebsiteConfig, sourceCfg *config.SourceConfig) config.ParseConfig {
+	parseCfg := config.ParseConfig{
+		LogType:    strings.ToLower(strings.TrimSpace(website.LogType)),
+		LogFormat:  website.LogFormat,
+		LogRegex:   website.LogRegex,
+		TimeLayout: website.TimeLayout,
+	}
+
+	if sourceCfg != nil && sourceCfg.Parse != nil {
+		parseOverride := sourceCfg.Parse
+		if strings.TrimSpace(parseOverride.LogType) != "" {
+			parseCfg.LogType = strings.ToLower(strings.TrimSpace(parseOverride.LogType))
+		}
+		if strings.TrimSpace(parseOverride.LogFormat) != "" {
+			parseCfg.LogFormat = parseOverride.LogFormat
+		}
+		if strings.TrimSpace(parseOverride.LogRegex) != "" {
+			parseCfg.LogRegex = parseOverride.LogRegex
+		}
+		if strings.TrimSpace(parseOverride.TimeLayout) != "" {
+			parseCfg.TimeLayout = parseOverride.TimeLayout
+		}
+		if len(parseOverride.FieldMap) > 0 {
+			parseCfg.FieldMap = parseOverride.FieldMap
+		}
+	}
+	if parseCfg.LogType == "" {
+		parseCfg.LogType = "nginx"
+	}
+	return parseCfg
+}
+
+// New 按站点（及来源）的解析配置创建解析器
+func New(website config.WebsiteConfig, sourceCfg *config.SourceConfig) (*LineParser, error) {
+	parseCfg := EffectiveConfig(website, sourceCfg)
+	logType := parseCfg.LogType
+	logFormat := parseCfg.LogFormat
+	logRegex := parseCfg.LogRegex
+	timeLayout := parseCfg.TimeLayout
+	fieldMap := parseCfg.FieldMap
+
+	pattern := defaultNginxLogRegex
+	source := "default"
+	parseType := parseTypeRegex
+	var location *time.Location
+	stripURL := logType == "alb"
+
+	if strings.TrimSpace(logRegex) != "" {
+		pattern = ensureAnchors(logRegex)
+		source = "logRegex"
+	} else if logType == "w3c" {
+		return newW3CLineParser(logFormat, timeLayout), nil
+	} else if logType == "cloudfront" {
+		return newCloudFrontLineParser(logFormat, timeLayout), nil
+	} else if strings.TrimSpace(logFormat) != "" && !isApacheLogType(logType) && isJSONLogFormat(logFormat) {
+		fields, err := buildJSONFieldMapFromNginxFormat(logFormat, fieldMap)
+		if err != nil {
+			return nil, err
+		}
+		return &LineParser{
+			timeLayout:    timeLayout,
+			source:        "logFormat",
+			parseType:     parseTypeJSON,
+			jsonFields:    fields,
+			durationScale: jsonDurationScale(logType),
+		}, nil
+	} else if strings.TrimSpace(logFormat) != "" {
+		var (
+			compiled string
+			err      error
+		)
+		if isApacheLogType(logType) {
+			compiled, err = buildRegexFromApacheFormat(logFormat)
+		} else {
+			compiled, err = buildRegexFromFormat(logFormat)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pattern = compiled
+		source = "logFormat"
+	} else if logType == "caddy" {
+		return &LineParser{
+			timeLayout: timeLayout,
+			source:     "caddy",
+			parseType:  parseTypeCaddyJSON,
+		}, nil
+	} else if isJSONLogType(logType) {
+		fields, err := buildJSONFieldMap(logType, fieldMap)
+		if err != nil {
+			return nil, err
+		}
+		return &LineParser{
+			timeLayout:    timeLayout,
+			source:        logType,
+			parseType:     parseTypeJSON,
+			jsonFields:    fields,
+			durationScale: jsonDurationScale(logType),
+		}, nil
+	} else if isApacheLogType(logType) {
+		compiled, err := buildRegexFromApacheFormat(apacheFormatForType(logType))
+		if err != nil {
+			return nil, err
+		}
+		pattern = compiled
+		source = logType
+	} else if logType == "haproxy" {
+		pattern = haproxyHTTPLogRegex
+		source = "haproxy"
+		if strings.TrimSpace(timeLayout) == "" {
+			timeLayout = haproxyTimeLayout
+		}
+		location = time.Local
+	} else if logType == "alb" {
+		pattern = albLogRegex
+		source = "alb"
+	} else if logType != "nginx" {
+		return nil, fmt.Errorf("不支持的日志类型: %s", logType)
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("日志格式正则无效 (%s): %w", source, err)
+	}
+
+	indexMap := make(map[string]int)
+	for i, name := range regex.SubexpNames() {
+		if name != "" {
+			indexMap[name] = i
+		}
+	}
+
+	if err := validateLogPattern(indexMap); err != nil {
+		return nil, err
+	}
+
+	return &LineParser{
+		regex:      regex,
+		indexMap:   indexMap,
+		timeLayout: timeLayout,
+		location:   location,
+		source:     source,
+		parseType:  parseType,
+		stripURL:   stripURL,
+	}, nil
+}
+
+// NewFromConfig 按单独的解析配置创建解析器，未配置 logType 时按 nginx 默认格式解析
+func NewFromConfig(parseCfg config.ParseConfig) (*LineParser, error) {
+	return New(config.WebsiteConfig{}, &config.SourceConfig{Parse: &parseCfg})
+}
+
+func ensureAnchors(pattern string) string {
+	trimmed := strings.TrimSpace(pattern)
+	if trimmed == "" {
+		return trimmed
+	}
+	if !strings.HasPrefix(trimmed, "^") {
+		trimmed = "^" + trimmed
+	}
+	if !strings.HasSuffix(trimmed, "$") {
+		trimmed = trimmed + "$"
+	}
+	return trimmed
+}
+
+func buildRegexFromFormat(format string) (string, error) {
+	if strings.TrimSpace(format) == "" {
+		return "", errors.New("logFormat 不能为空")
+	}
+
+	varPattern := regexp.MustCompile(`\$\w+`)
+	locations := varPattern.FindAllStringIndex(format, -1)
+	if len(locations) == 0 {
+		return "", errors.New("logFormat 未包含任何变量")
+	}
+
+	var builder strings.Builder
+	usedNames := make(map[string]bool)
+	last := 0
+	for _, loc := range locations {
+		literal := format[last:loc[0]]
+		builder.WriteString(regexp.QuoteMeta(literal))
+
+		varName := format[loc[0]+1 : loc[1]]
+		quoted := isQuotedTokenBoundary(literal, format[loc[1]:])
+		builder.WriteString(tokenRegexForVar(varName, usedNames, quoted))
+		last = loc[1]
+	}
+	builder.WriteString(regexp.QuoteMeta(format[last:]))
+
+	return "^" + builder.String() + "$", nil
+}
+
+func tokenRegexForVar(name string, used map[string]bool, quoted bool) string {
+	addGroup := func(group, pattern string) string {
+		if used[group] {
+			return pattern
+		}
+		used[group] = true
+		return "(?P<" + group + ">" + pattern + ")"
+	}
+
+	commaListPattern := `[^,\s]+(?:,\s*[^,\s]+)*`
+	optionalTokenPattern := `\S*`
+	optionalQuotedPattern := `[^"]*`
+	requiredTokenPattern := `\S+`
+	requiredQuotedPattern := `[^"]+`
+	if quoted {
+		optionalTokenPattern = optionalQuotedPattern
+		requiredTokenPattern = requiredQuotedPattern
+	}
+
+	switch name {
+	case "remote_addr":
+		return addGroup("ip", requiredTokenPattern)
+	case "http_x_forwarded_for":
+		return addGroup("http_x_forwarded_for", commaListPattern)
+	case "remote_user":
+		return addGroup("user", optionalTokenPattern)
+	case "time_local":
+		return addGroup("time", `[^]]+`)
+	case "time_iso8601":
+		return addGroup("time", requiredTokenPattern)
+	case "request":
+		return addGroup("request", requiredTokenPattern)
+	case "request_method":
+		return addGroup("method", requiredTokenPattern)
+	case "request_uri", "uri":
+		return addGroup("url", requiredTokenPattern)
+	case "args":
+		return addGroup("args", optionalTokenPattern)
+	case "query_string":
+		return addGroup("query_string", optionalTokenPattern)
+	case "status":
+		return addGroup("status", `\d{3}`)
+	case "body_bytes_sent", "bytes_sent":
+		return addGroup("bytes", `\d+`)
+	case "http_referer":
+		return addGroup("referer", optionalTokenPattern)
+	case "http_user_agent":
+		return addGroup("ua", optionalTokenPattern)
+	case "host":
+		return addGroup("host", requiredTokenPattern)
+	case "http_host":
+		return addGroup("host", requiredTokenPattern)
+	case "server_name":
+		return addGroup("server_name", requiredTokenPattern)
+	case "scheme":
+		return addGroup("scheme", requiredTokenPattern)
+	case "request_length":
+		return addGroup("request_length", `\d+`)
+	case "remote_port":
+		return addGroup("remote_port", `\d+`)
+	case "connection":
+		return addGroup("connection", `\d+`)
+	case "request_time":
+		return addGroup("request_time", `\d+(?:\.\d+)?`)
+	case "request_time_msec":
+		return addGroup("request_time_msec", `\d+(?:\.\d+)?`)
+	case "upstream_addr":
+		return addGroup("upstream_addr", commaListPattern)
+	case "upstream_status":
+		return addGroup("upstream_status", commaListPattern)
+	case "upstream_response_time":
+		return addGroup("upstream_response_time", commaListPattern)
+	case "upstream_connect_time":
+		return addGroup("upstream_connect_time", commaListPattern)
+	case "upstream_header_time":
+		return addGroup("upstream_header_time", commaListPattern)
+	default:
+		return optionalTokenPattern
+	}
+}
+
+func isQuotedTokenBoundary(prefix, suffix string) bool {
+	prefixTrim := strings.TrimRight(prefix, " \t\r\n")
+	if !strings.HasSuffix(prefixTrim, "\"") {
+		return false
+	}
+	suffixTrim := strings.TrimLeft(suffix, " \t\r\n")
+	return strings.HasPrefix(suffixTrim, "\"")
+}
+
+func validateLogPattern(indexMap map[string]int) error {
+	if len(indexMap) == 0 {
+		return errors.New("logRegex/logFormat 必须包含命名分组")
+	}
+
+	if !hasAnyField(indexMap, ipAliases) {
+		return errors.New("日志格式缺少 IP 字段（ip/remote_addr）")
+	}
+	if !hasAnyField(indexMap, timeAliases) {
+		return errors.New("日志格式缺少时间字段（time/time_local/time_iso8601）")
+	}
+	if !hasAnyField(indexMap, statusAliases) {
+		return errors.New("日志格式缺少状态码字段（status）")
+	}
+	if !hasAnyField(indexMap, urlAliases) && !hasAnyField(indexMap, requestAliases) {
+		return errors.New("日志格式缺少 URL 字段（url/request_uri 或 request）")
+	}
+	return nil
+}
+
+func hasAnyField(indexMap map[string]int, aliases []string) bool {
+	for _, name := range aliases {
+		if _, ok := indexMap[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse 解析一行日志，不检查保留天数与 Host 分流，由调用方按自身配置判断
+func (lp *LineParser) Parse(line string) (*Record, error) {
+	switch lp.parseType {
+	case parseTypeCaddyJSON:
+		return lp.parseCaddyJSONLine(line)
+	case parseTypeJSON:
+		return lp.parseJSONLine(line)
+	case parseTypeW3C:
+		return lp.parseW3CLine(line)
+	default:
+		return lp.parseRegexLogLine(line)
+	}
+}
+
+// ParseTimestamp 只解析日志行中的时间，用于定位扫描起点
+func (lp *LineParser) ParseTimestamp(line string) (time.Time, error) {
+	switch lp.parseType {
+	case parseTypeCaddyJSON:
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var payload map[string]interface{}
+		if err := decoder.Decode(&payload); err != nil {
+			return time.Time{}, err
+		}
+		return parseCaddyTime(payload, lp.timeLayout)
+	case parseTypeJSON:
+		payload, err := decodeJSONLine(line)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return parseJSONTime(payload, lp.jsonFields["time"], lp.timeLayout)
+	case parseTypeW3C:
+		return lp.parseW3CTimestamp(line)
+	default:
+		return lp.parseRegexLogTimestamp(line)
+	}
+}
+
+func (lp *LineParser) parseRegexLogTimestamp(line string) (time.Time, error) {
+	matches := lp.regex.FindStringSubmatch(line)
+	if len(matches) == 0 {
+		return time.Time{}, errors.New("日志格式不匹配")
+	}
+	rawTime := extractField(matches, lp.indexMap, timeAliases)
+	if rawTime == "" {
+		return time.Time{}, errors.New("日志缺少时间字段")
+	}
+	return lp.parseTime(rawTime)
+}
+
+func (lp *LineParser) parseRegexLogLine(line string) (*Record, error) {
+	matches := lp.regex.FindStringSubmatch(line)
+	if len(matches) == 0 {
+		return nil, errors.New("日志格式不匹配")
+	}
+
+	ip := extractField(matches, lp.indexMap, ipAliases)
+	rawTime := extractField(matches, lp.indexMap, timeAliases)
+	statusStr := extractField(matches, lp.indexMap, statusAliases)
+	urlValue := extractField(matches, lp.indexMap, urlAliases)
+	method := extractField(matches, lp.indexMap, methodAliases)
+	requestLine := extractField(matches, lp.indexMap, requestAliases)
+
+	if method == "" || urlValue == "" {
+		if requestLine != "" {
+			parsedMethod, parsedURL, err := parseRequestLine(requestLine)
+			if err != nil {
+				return nil, err
+			}
+			if method == "" {
+				method = parsedMethod
+			}
+			if urlValue == "" {
+				urlValue = parsedURL
+			}
+		}
+	}
+
+	if ip == "" || rawTime == "" || statusStr == "" || urlValue == "" {
+		return nil, errors.New("日志缺少必要字段")
+	}
+	host := extractField(matches, lp.indexMap, hostAliases)
+	if lp.stripURL {
+		if host == "" {
+			host = hostFromURL(urlValue)
+		}
+		urlValue = stripURLOrigin(urlValue)
+	}
+
+	timestamp, err := lp.parseTime(rawTime)
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode, err := strconv.Atoi(statusStr)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesSent := 0
+	bytesStr := extractField(matches, lp.indexMap, bytesAliases)
+	if bytesStr != "" && bytesStr != "-" {
+		if parsed, err := strconv.Atoi(bytesStr); err == nil {
+			bytesSent = parsed
+		}
+	}
+
+	referPath := extractField(matches, lp.indexMap, refererAliases)
+
+	userAgent := extractField(matches, lp.indexMap, userAgentAliases)
+	record, err := BuildRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	record.RequestTime, record.UpstreamTime = extractRegexLatency(matches, lp.indexMap)
+	record.Host = host
+	return record, nil
+}
+
+func (lp *LineParser) parseCaddyJSONLine(line string) (*Record, error) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	request := getMap(payload, "request")
+	headers := getMap(request, "headers")
+
+	ip := getString(request, "remote_ip")
+	if ip == "" {
+		ip = getString(request, "client_ip")
+	}
+	if ip == "" {
+		ip = getString(payload, "remote_ip")
+	}
+
+	method := getString(request, "method")
+	urlValue := getString(request, "uri")
+
+	statusCode, ok := getInt(payload, "status")
+	if !ok {
+		return nil, errors.New("日志缺少状态码")
+	}
+
+	bytesSent, _ := getInt(payload, "size")
+	referPath := getHeader(headers, "Referer")
+	userAgent := getHeader(headers, "User-Agent")
+
+	timestamp, err := parseCaddyTime(payload, lp.timeLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := BuildRecord(ip, method, urlValue, referPath, userAgent, statusCode, bytesSent, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	record.RequestTime = extractCaddyLatency(payload)
+	record.Host = getString(request, "host")
+	return record, nil
+}
+
+// BuildRecord 校验必要字段并生成记录，同时解析 URL、UA 并按 PV 过滤规则计算 PV 标记
+func BuildRecord(
+	ip, method, urlValue, referer, userAgent string,
+	statusCode, bytesSent int, timestamp time.Time) (*Record, error) {
+
+	ip = normalizeIP(ip)
+	if ip == "" || method == "" || urlValue == "" {
+		return nil, errors.New("日志缺少必要字段")
+	}
+	if statusCode <= 0 {
+		return nil, errors.New("日志缺少状态码")
+	}
+
+	decodedPath, err := url.QueryUnescape(urlValue)
+	if err != nil {
+		decodedPath = urlValue
+	}
+
+	referPath := referer
+	if referPath != "" {
+		if decodedRefer, err := url.QueryUnescape(referPath); err == nil {
+			referPath = decodedRefer
+		}
+	}
+
+	if userAgent == "" {
+		userAgent = "-"
+	}
+
+	pageviewFlag := enrich.ShouldCountAsPageView(statusCode, decodedPath, ip)
+	browser, os, device := enrich.ParseUserAgent(userAgent)
+
+	return &Record{
+		ID:               0,
+		IP:               ip,
+		PageviewFlag:     pageviewFlag,
+		Timestamp:        timestamp,
+		Method:           method,
+		Url:              decodedPath,
+		Status:           statusCode,
+		BytesSent:        bytesSent,
+		Referer:          referPath,
+		UserBrowser:      browser,
+		UserOs:           os,
+		UserDevice:       device,
+		DomesticLocation: "",
+		GlobalLocation:   "",
+	}, nil
+}
+
+func normalizeIP(raw string) string {
+	ip := strings.TrimSpace(raw)
+	if ip == "" {
+		return ip
+	}
+	if strings.Contains(ip, ",") {
+		parts := strings.Split(ip, ",")
+		if len(parts) > 0 {
+			ip = strings.TrimSpace(parts[0])
+		}
+	}
+	if strings.HasPrefix(ip, "[") {
+		if end := strings.Index(ip, "]"); end > 0 {
+			ip = ip[1:end]
+		}
+		return ip
+	}
+	if strings.Count(ip, ":") == 1 && strings.Contains(ip, ".") {
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			return host
+		}
+	}
+	return ip
+}
+
+func getMap(source map[string]interface{}, key string) map[string]interface{} {
+	if source == nil {
+		return nil
+	}
+	value, ok := source[key]
+	if !ok {
+		return nil
+	}
+	if mapped, ok := value.(map[string]interface{}); ok {
+		return mapped
+	}
+	return nil
+}
+
+func getString(source map[string]interface{}, key string) string {
+	if source == nil {
+		return ""
+	}
+	value, ok := source[key]
+	if !ok || value == nil {
+		return ""
+	}
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case json.Number:
+		return typed.String()
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+func getInt(source map[string]interface{}, key string) (int, bool) {
+	if source == nil {
+		return 0, false
+	}
+	value, ok := source[key]
+	if !ok || value == nil {
+		return 0, false
+	}
+	switch typed := value.(type) {
+	case json.Number:
+		if parsed, err := typed.Int64(); err == nil {
+			return int(parsed), true
+		}
+		if parsed, err := typed.Float64(); err == nil {
+			return int(parsed), true
+		}
+	case float64:
+		return int(typed), true
+	case float32:
+		return int(typed), true
+	case int:
+		return typed, true
+	case int64:
+		return int(typed), true
+	case string:
+		if parsed, err := strconv.Atoi(typed); err == nil {
+			return parsed, true
+		}
+	}
+	return 0, false
+}
+
+func getHeader(headers map[string]interface{}, name string) string {
+	if headers == nil {
+		return ""
+	}
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			switch typed := value.(type) {
+			case []interface{}:
+				if len(typed) > 0 {
+					return fmt.Sprint(typed[0])
+				}
+			case []string:
+				if len(typed) > 0 {
+					return typed[0]
+				}
+			case string:
+				return typed
+			default:
+				return fmt.Sprint(typed)
+			}
+		}
+	}
+	return ""
+}
+
+func parseCaddyTime(payload map[string]interface{}, layout string) (time.Time, error) {
+	if payload == nil {
+		return time.Time{}, errors.New("日志缺少时间字段")
+	}
+	if value, ok := payload["ts"]; ok {
+		if ts, err := parseAnyTime(value, layout); err == nil {
+			return ts, nil
+		}
+	}
+	if value, ok := payload["time"]; ok {
+		if ts, err := parseAnyTime(value, layout); err == nil {
+			return ts, nil
+		}
+	}
+	if value, ok := payload["timestamp"]; ok {
+		if ts, err := parseAnyTime(value, layout); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, errors.New("日志缺少时间字段")
+}
+
+func parseAnyTime(value interface{}, layout string) (time.Time, error) {
+	switch typed := value.(type) {
+	case json.Number:
+		if parsed, err := typed.Int64(); err == nil {
+			return time.Unix(parsed, 0), nil
+		}
+		if parsed, err := typed.Float64(); err == nil {
+			return parseFloatEpoch(parsed), nil
+		}
+	case float64:
+		return parseFloatEpoch(typed), nil
+	case float32:
+		return parseFloatEpoch(float64(typed)), nil
+	case int:
+		return time.Unix(int64(typed), 0), nil
+	case int64:
+		return time.Unix(typed, 0), nil
+	case string:
+		return parseLogTime(typed, layout)
+	}
+	return time.Time{}, errors.New("时间格式不支持")
+}
+
+func parseFloatEpoch(value float64) time.Time {
+	if value > 1e12 {
+		value = value / 1000
+	}
+	sec := int64(value)
+	nsec := int64((value - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec)
+}
+
+func extractField(matches []string, indexMap map[string]int, aliases []string) string {
+	for _, name := range aliases {
+		if idx, ok := indexMap[name]; ok {
+			if idx > 0 && idx < len(matches) {
+				return matches[idx]
+			}
+		}
+	}
+	return ""
+}
+
+func parseRequestLine(line string) (string, string, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return "", "", errors.New("无效的 request 格式")
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseTime 按解析器配置的时区解析不带时区的时间，失败时回退到通用解析
+func (lp *LineParser) parseTime(raw string) (time.Time, error) {
+	if lp.location != nil && lp.timeLayout != "" {
+		if ts, err := time.ParseInLocation(lp.timeLayout, raw, lp.location); err == nil {
+			return ts, nil
+		}
+	}
+	return parseLogTime(raw, lp.timeLayout)
+}
+
+func parseLogTime(raw, layout string) (time.Time, error) {
+	if ts, ok := parseEpochTime(raw); ok {
+		return ts, nil
+	}
+
+	layouts := make([]string, 0, 3)
+	if layout != "" {
+		layouts = append(layouts, layout)
+	}
+	layouts = append(layouts, defaultNginxTimeLayout, time.RFC3339, time.RFC3339Nano)
+
+	var lastErr error
+	for _, l := range layouts {
+		parsed, err := time.Parse(l, raw)
+		if err == nil {
+			return parsed, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("时间解析失败")
+	}
+	return time.Time{}, lastErr
+}
+
+func parseEpochTime(raw string) (time.Time, bool) {
+	if raw == "" {
+		return time.Time{}, false
+	}
+
+	for _, r := range raw {
+		if (r < '0' || r > '9') && r != '.' {
+			return time.Time{}, false
+		}
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	if value > 1e12 {
+		value = value / 1000
+	}
+
+	sec := int64(value)
+	nsec := int64((value - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec), true
+}
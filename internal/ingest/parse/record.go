@@ -0,0 +1,24 @@
+package parse
+
+import "time"
+
+// Record 解析后的一条访问记录，入库结构见 store.NginxLogRecord
+type Record struct {
+	ID               int64     `json:"id"`
+	IP               string    `json:"ip"`
+	PageviewFlag     int       `json:"pageview_flag"`
+	Timestamp        time.Time `json:"timestamp"`
+	Method           string    `json:"method"`
+	Url              string    `json:"url"`
+	Status           int       `json:"status"`
+	BytesSent        int       `json:"bytes_sent"`
+	Referer          string    `json:"referer"`
+	UserBrowser      string    `json:"user_browser"`
+	UserOs           string    `json:"user_os"`
+	UserDevice       string    `json:"user_device"`
+	DomesticLocation string    `json:"domestic_location"`
+	GlobalLocation   string    `json:"global_location"`
+	RequestTime      *float64  `json:"request_time,omitempty"`  // 请求耗时（毫秒），日志未记录时为 nil
+	UpstreamTime     *float64  `json:"upstream_time,omitempty"` // 上游响应耗时（毫秒），日志未记录时为 nil
+	Host             string    `json:"host,omitempty"`          // 请求的 Host，仅用于共享日志分流，不落库
+}
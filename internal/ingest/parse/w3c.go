@@ -0,0 +1,196 @@
+package parse
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IIS 默认的 W3C 扩展日志字段
+var defaultW3CFields = []string{
+	"date", "time", "s-ip", "cs-method", "cs-uri-stem", "cs-uri-query", "s-port", "cs-username",
+	"c-ip", "cs(User-Agent)", "cs(Referer)", "sc-status", "sc-substatus", "sc-win32-status", "time-taken",
+}
+
+const defaultW3CTimeLayout = "2006-01-02 15:04:05"
+
+// ErrSkipLine 表示该行为注释或指令行（如 W3C 的 #Fields:），应直接跳过而非计为解析失败
+var ErrSkipLine = errors.New("跳过注释或指令行")
+
+func newW3CLineParser(logFormat, timeLayout string) *LineParser {
+	fields := defaultW3CFields
+	if strings.TrimSpace(logFormat) != "" {
+		fields = parseW3CFieldsDirective(logFormat)
+	}
+	return &LineParser{
+		timeLayout:    timeLayout,
+		source:        "w3c",
+		parseType:     parseTypeW3C,
+		defaultFields: fields,
+		durationScale: 1, // IIS time-taken 单位为毫秒
+	}
+}
+
+// parseW3CFieldsDirective 解析 "#Fields: date time c-ip ..." 或纯字段列表
+func parseW3CFieldsDirective(line string) []string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(strings.ToLower(trimmed), "#fields:") {
+		trimmed = trimmed[len("#fields:"):]
+	}
+	return strings.Fields(trimmed)
+}
+
+// observeDirective 处理以 # 开头的指令行，遇到 #Fields: 时更新当前字段定义
+func (lp *LineParser) observeDirective(line string) {
+	if lp.parseType != parseTypeW3C {
+		return
+	}
+	if !strings.HasPrefix(strings.ToLower(line), "#fields:") {
+		return
+	}
+	fields := parseW3CFieldsDirective(line)
+	if len(fields) == 0 {
+		return
+	}
+	lp.mu.Lock()
+	lp.fields = fields
+	lp.mu.Unlock()
+}
+
+// RestoreFields 恢复目标上次记录的 #Fields 定义；为空时回退到默认字段
+func (lp *LineParser) RestoreFields(fields []string) {
+	if lp.parseType != parseTypeW3C {
+		return
+	}
+	lp.mu.Lock()
+	lp.fields = append([]string(nil), fields...)
+	lp.mu.Unlock()
+}
+
+// CurrentFields 返回当前生效的字段定义，供写入 FileState/TargetState
+func (lp *LineParser) CurrentFields() []string {
+	if lp.parseType != parseTypeW3C {
+		return nil
+	}
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if len(lp.fields) == 0 {
+		return nil
+	}
+	return append([]string(nil), lp.fields...)
+}
+
+func (lp *LineParser) activeFields() []string {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if len(lp.fields) > 0 {
+		return lp.fields
+	}
+	return lp.defaultFields
+}
+
+// splitW3CLine 按字段定义切分一行，返回 字段名(小写) -> 值，"-" 视为空
+func (lp *LineParser) splitW3CLine(line string) (map[string]string, error) {
+	fields := lp.activeFields()
+	var values []string
+	if lp.separator != "" {
+		values = strings.Split(line, lp.separator)
+	} else {
+		values = strings.Fields(line)
+	}
+	if len(values) != len(fields) {
+		return nil, errors.New("日志字段数量与 #Fields 定义不一致")
+	}
+	row := make(map[string]string, len(fields))
+	for i, name := range fields {
+		value := values[i]
+		if value == "-" {
+			value = ""
+		}
+		row[strings.ToLower(name)] = value
+	}
+	return row, nil
+}
+
+func (lp *LineParser) parseW3CLine(line string) (*Record, error) {
+	if strings.HasPrefix(line, "#") {
+		lp.observeDirective(line)
+		return nil, ErrSkipLine
+	}
+	row, err := lp.splitW3CLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := parseW3CTime(row, lp.timeLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode, err := strconv.Atoi(row["sc-status"])
+	if err != nil {
+		return nil, errors.New("日志缺少状态码")
+	}
+
+	urlValue := row["cs-uri-stem"]
+	if query := row["cs-uri-query"]; query != "" && urlValue != "" {
+		urlValue += "?" + query
+	}
+
+	bytesSent := 0
+	if parsed, err := strconv.Atoi(row["sc-bytes"]); err == nil {
+		bytesSent = parsed
+	}
+
+	userAgent := lp.decodeW3CValue(row["cs(user-agent)"])
+	referer := row["cs(referer)"]
+
+	record, err := BuildRecord(row["c-ip"], row["cs-method"], urlValue, referer, userAgent, statusCode, bytesSent, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	record.RequestTime = parseDurationList(row["time-taken"], lp.durationScale)
+	record.Host = w3cHost(row)
+	return record, nil
+}
+
+func (lp *LineParser) parseW3CTimestamp(line string) (time.Time, error) {
+	if strings.HasPrefix(line, "#") {
+		lp.observeDirective(line)
+		return time.Time{}, ErrSkipLine
+	}
+	row, err := lp.splitW3CLine(line)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseW3CTime(row, lp.timeLayout)
+}
+
+// parseW3CTime 组合 date/time 两列，W3C 规范要求时间为 UTC
+func parseW3CTime(row map[string]string, layout string) (time.Time, error) {
+	datePart := row["date"]
+	timePart := row["time"]
+	if datePart == "" || timePart == "" {
+		return time.Time{}, errors.New("日志缺少时间字段")
+	}
+	raw := datePart + " " + timePart
+	if layout != "" {
+		if ts, err := time.Parse(layout, raw); err == nil {
+			return ts, nil
+		}
+	}
+	return time.ParseInLocation(defaultW3CTimeLayout, raw, time.UTC)
+}
+
+// decodeW3CValue IIS 会把 User-Agent 中的空格写成 "+"，CloudFront 则使用 URL 编码
+func (lp *LineParser) decodeW3CValue(value string) string {
+	if lp.urlEncoded {
+		if decoded, err := url.QueryUnescape(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+	return strings.ReplaceAll(value, "+", " ")
+}
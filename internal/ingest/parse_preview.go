@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// previewLogTypes 自动识别时依次尝试的日志类型，匹配行数相同时靠前的优先
+var previewLogTypes = []string{
+	"nginx",
+	"apache_vhost_combined",
+	"apache_combined",
+	"apache_common",
+	"caddy",
+	"traefik",
+	"envoy",
+	"json",
+	"haproxy",
+	"alb",
+	"cloudfront",
+	"w3c",
+}
+
+// ParsePreviewLine 单行日志的试解析结果
+type ParsePreviewLine struct {
+	Line    string                `json:"line"`
+	Record  *store.NginxLogRecord `json:"record,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	Warning string                `json:"warning,omitempty"`
+	Skipped bool                  `json:"skipped,omitempty"` // 注释或指令行（如 W3C 的 #Fields:）
+}
+
+// ParsePreviewCandidate 自动识别时各日志类型的匹配情况
+type ParsePreviewCandidate struct {
+	Parse   config.ParseConfig `json:"parse"`
+	Matched int                `json:"matched"`
+	Failed  int                `json:"failed"`
+}
+
+// ParsePreviewResult 试解析结果
+type ParsePreviewResult struct {
+	Parse      config.ParseConfig      `json:"parse"`
+	Detected   bool                    `json:"detected"` // 是否为自动识别出的格式
+	Matched    int                     `json:"matched"`
+	Failed     int                     `json:"failed"`
+	Lines      []ParsePreviewLine      `json:"lines"`
+	Candidates []ParsePreviewCandidate `json:"candidates,omitempty"`
+}
+
+// PreviewParse 使用站点（及来源）的解析配置试解析样例日志行，不写入数据库
+func PreviewParse(lines []string, website config.WebsiteConfig, sourceCfg *config.SourceConfig) (ParsePreviewResult, error) {
+	parser, err := parse.New(website, sourceCfg)
+	if err != nil {
+		return ParsePreviewResult{}, err
+	}
+	result := previewWithParser(parser, lines)
+	result.Parse = parse.EffectiveConfig(website, sourceCfg)
+	return result, nil
+}
+
+// DetectLogFormat 依次尝试已知的日志类型，返回匹配行数最多的格式及其解析结果
+func DetectLogFormat(lines []string) ParsePreviewResult {
+	var (
+		best       ParsePreviewResult
+		found      bool
+		candidates []ParsePreviewCandidate
+	)
+	for _, logType := range previewLogTypes {
+		parseCfg := config.ParseConfig{LogType: logType}
+		parser, err := parse.NewFromConfig(parseCfg)
+		if err != nil {
+			continue
+		}
+		result := previewWithParser(parser, lines)
+		result.Parse = parseCfg
+		candidates = append(candidates, ParsePreviewCandidate{
+			Parse:   parseCfg,
+			Matched: result.Matched,
+			Failed:  result.Failed,
+		})
+		// 全部不匹配时返回默认 nginx 格式的结果，便于查看失败原因
+		if !found || result.Matched > best.Matched {
+			best = result
+			found = true
+		}
+	}
+
+	best.Detected = best.Matched > 0
+	if !best.Detected {
+		best.Parse = config.ParseConfig{}
+	}
+	best.Candidates = candidates
+	return best
+}
+
+// previewWithParser 试解析不按保留天数丢弃记录，超出保留天数的记录以警告提示
+func previewWithParser(parser *parse.LineParser, lines []string) ParsePreviewResult {
+	retentionDays := config.ReadConfig().System.LogRetentionDays
+	if retentionDays <= 0 {
+		retentionDays = 30
+	}
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+
+	parser.RestoreFields(nil)
+	result := ParsePreviewResult{Lines: make([]ParsePreviewLine, 0, len(lines))}
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		item := ParsePreviewLine{Line: line}
+		if strings.TrimSpace(line) == "" {
+			item.Skipped = true
+			result.Lines = append(result.Lines, item)
+			continue
+		}
+
+		record, err := parser.Parse(line)
+		switch {
+		case err == nil:
+			item.Record = record
+			result.Matched++
+			if record.Timestamp.Before(cutoff) {
+				item.Warning = fmt.Sprintf("日志超过保留天数（%d 天），扫描时会被忽略", retentionDays)
+			}
+		case isSkippedLine(err):
+			item.Skipped = true
+		default:
+			item.Error = err.Error()
+			result.Failed++
+		}
+		result.Lines = append(result.Lines, item)
+	}
+	return result
+}
+
+// ValidateParseConfigs 检查各站点与来源的解析配置能否生成解析器（正则、logFormat、logType 等）
+func ValidateParseConfigs(cfg *config.Config) []config.FieldError {
+	if cfg == nil {
+		return nil
+	}
+	var errs []config.FieldError
+	for i, website := range cfg.Websites {
+		if _, err := parse.New(website, nil); err != nil {
+			errs = append(errs, config.FieldError{
+				Field:   fmt.Sprintf("websites[%d].%s", i, parseConfigField(parse.EffectiveConfig(website, nil))),
+				Message: err.Error(),
+			})
+		}
+		for j := range website.Sources {
+			if website.Sources[j].Parse == nil {
+				continue
+			}
+			if _, err := parse.New(website, &website.Sources[j]); err != nil {
+				errs = append(errs, config.FieldError{
+					Field:   fmt.Sprintf("websites[%d].sources[%d].parse", i, j),
+					Message: err.Error(),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// parseConfigField 返回解析配置中实际生效（也最可能出错）的字段名
+func parseConfigField(parseCfg config.ParseConfig) string {
+	switch {
+	case strings.TrimSpace(parseCfg.LogRegex) != "":
+		return "logRegex"
+	case strings.TrimSpace(parseCfg.LogFormat) != "":
+		return "logFormat"
+	default:
+		return "logType"
+	}
+}
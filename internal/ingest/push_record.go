@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/enrich"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+// IngestRecord 推送方已拆分好字段的访问记录，例如 OTLP 中按 HTTP 语义约定上报的日志属性
+type IngestRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	IP           string    `json:"ip"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	Status       int       `json:"status"`
+	BytesSent    int       `json:"bytes_sent"`
+	Referer      string    `json:"referer,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	Host         string    `json:"host,omitempty"`
+	RequestTime  *float64  `json:"request_time,omitempty"`  // 毫秒
+	UpstreamTime *float64  `json:"upstream_time,omitempty"` // 毫秒
+}
+
+// IngestRecords 写入结构化的访问记录，与 IngestLines 一样经过 Host 分流、去重与 IP 归属地解析。
+// 去重与解析失败记录使用记录的 JSON 文本作为原文。
+func (p *LogParser) IngestRecords(websiteID, sourceID string, records []IngestRecord) (int, int, error) {
+	return p.ingestPushed(websiteID, sourceID, len(records), func(i int) (string, *store.NginxLogRecord, error) {
+		raw, _ := json.Marshal(records[i])
+		entry, err := p.buildPushedRecord(websiteID, sourceID, records[i])
+		return string(raw), entry, err
+	})
+}
+
+func (p *LogParser) buildPushedRecord(websiteID, sourceID string, record IngestRecord) (*store.NginxLogRecord, error) {
+	parser, err := p.getLineParserForSource(websiteID, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Timestamp.IsZero() {
+		return nil, errors.New("日志缺少时间字段")
+	}
+	entry, err := parse.BuildRecord(
+		record.IP, record.Method, record.URL, record.Referer, record.UserAgent,
+		record.Status, record.BytesSent, record.Timestamp,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if p.beyondRetention(entry.Timestamp) {
+		return nil, errBeyondRetention
+	}
+	entry.RequestTime = record.RequestTime
+	entry.UpstreamTime = record.UpstreamTime
+	entry.Host = record.Host
+	if !parser.route.accepts(entry.Host) {
+		return nil, errHostNotRouted
+	}
+	return entry, nil
+}
+
+// IngestParsedRecords 写入 agent 已解析好的记录，跳过正则解析阶段。
+// 服务端仍按自身配置检查保留天数与 Host 分流并重新计算 PV 标记，IP 归属地由服务端解析。
+func (p *LogParser) IngestParsedRecords(websiteID, sourceID string, records []store.NginxLogRecord) (int, int, error) {
+	return p.ingestPushed(websiteID, sourceID, len(records), func(i int) (string, *store.NginxLogRecord, error) {
+		raw, _ := json.Marshal(records[i])
+		entry, err := p.acceptParsedRecord(websiteID, sourceID, records[i])
+		return string(raw), entry, err
+	})
+}
+
+func (p *LogParser) acceptParsedRecord(websiteID, sourceID string, record store.NginxLogRecord) (*store.NginxLogRecord, error) {
+	parser, err := p.getLineParserForSource(websiteID, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if record.IP == "" || record.Method == "" || record.Url == "" {
+		return nil, errors.New("日志缺少必要字段")
+	}
+	if record.Status <= 0 {
+		return nil, errors.New("日志缺少状态码")
+	}
+	if record.Timestamp.IsZero() {
+		return nil, errors.New("日志缺少时间字段")
+	}
+	if p.beyondRetention(record.Timestamp) {
+		return nil, errBeyondRetention
+	}
+	if !parser.route.accepts(record.Host) {
+		return nil, errHostNotRouted
+	}
+
+	entry := record
+	entry.ID = 0
+	entry.DomesticLocation, entry.GlobalLocation = "", ""
+	entry.PageviewFlag = enrich.ShouldCountAsPageView(entry.Status, entry.Url, entry.IP)
+	if entry.UserBrowser == "" {
+		entry.UserBrowser, entry.UserOs, entry.UserDevice = enrich.ParseUserAgent("-")
+	}
+	return &entry, nil
+}
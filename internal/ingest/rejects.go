@@ -0,0 +1,178 @@
+package ingest
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
+	"github.com/likaia/nginxpulse/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+// lineOrigin 标识日志行所在的来源与位置，用于隔离解析失败的行
+type lineOrigin struct {
+	sourceID string
+	target   string
+	offset   int64 // reader 起始位置在目标中的字节偏移（压缩文件为解压后偏移），-1 表示无法定位
+}
+
+// SourceParseFailure 单个日志来源的解析失败统计（自进程启动或重新解析以来）
+type SourceParseFailure struct {
+	WebsiteID    string  `json:"website_id"`
+	SourceID     string  `json:"source_id"`
+	Parsed       int64   `json:"parsed"`
+	Failed       int64   `json:"failed"`
+	FailureRatio float64 `json:"failure_ratio"`
+	LastError    string  `json:"last_error,omitempty"`
+	LastFailedAt int64   `json:"last_failed_at,omitempty"`
+}
+
+var (
+	parseFailureMu sync.RWMutex
+	parseFailures  = make(map[string]*SourceParseFailure)
+)
+
+// isSkippedLine 注释/指令行、共享日志中属于其他站点的记录以及超过保留天数的记录不算解析失败
+func isSkippedLine(err error) bool {
+	return errors.Is(err, parse.ErrSkipLine) || errors.Is(err, errHostNotRouted) || errors.Is(err, errBeyondRetention)
+}
+
+// rejectCollector 统计一次扫描中的解析结果，并把解析失败的行批量写入隔离表
+type rejectCollector struct {
+	parser    *LogParser
+	websiteID string
+	origin    lineOrigin
+
+	rejects   []store.RejectedLine
+	parsed    int64
+	failed    int64
+	stored    int
+	lastError string
+}
+
+func (p *LogParser) newRejectCollector(websiteID string, origin lineOrigin) *rejectCollector {
+	return &rejectCollector{
+		parser:    p,
+		websiteID: websiteID,
+		origin:    origin,
+	}
+}
+
+// observe 记录一行的解析结果，offset 为该行相对 reader 起始位置的偏移
+func (c *rejectCollector) observe(line string, offset int64, err error) {
+	if err == nil {
+		c.parsed++
+		return
+	}
+	if isSkippedLine(err) || strings.TrimSpace(line) == "" {
+		return
+	}
+	c.failed++
+	c.lastError = err.Error()
+
+	// 隔离表本身有上限，单次扫描超出上限的失败行只计数不保存
+	if c.stored >= store.RejectRetentionLimit {
+		return
+	}
+	lineOffset := int64(-1)
+	if c.origin.offset >= 0 {
+		lineOffset = c.origin.offset + offset
+	}
+	c.rejects = append(c.rejects, store.RejectedLine{
+		CreatedAt: time.Now().Unix(),
+		SourceID:  c.origin.sourceID,
+		Target:    c.origin.target,
+		Offset:    lineOffset,
+		Reason:    err.Error(),
+		Line:      line,
+	})
+	c.stored++
+	if len(c.rejects) >= c.parser.parseBatchSize {
+		c.flushRejects()
+	}
+}
+
+func (c *rejectCollector) flushRejects() {
+	if len(c.rejects) == 0 {
+		return
+	}
+	if c.parser.repo != nil {
+		if err := c.parser.repo.InsertRejectsForWebsite(c.websiteID, c.rejects); err != nil {
+			logrus.Errorf("写入网站 %s 的解析失败记录失败: %v", c.websiteID, err)
+		}
+	}
+	c.rejects = c.rejects[:0]
+}
+
+// finish 写入剩余的失败行并累加到来源的失败统计
+func (c *rejectCollector) finish() {
+	c.flushRejects()
+	if c.parsed == 0 && c.failed == 0 {
+		return
+	}
+	recordParseOutcome(c.websiteID, c.origin.sourceID, c.parsed, c.failed, c.lastError)
+	if c.failed > 0 {
+		target := c.origin.target
+		if target == "" {
+			target = c.origin.sourceID
+		}
+		logrus.Warnf("网站 %s 的日志 %s 有 %d 行解析失败（成功 %d 行），最近错误: %s",
+			c.websiteID, target, c.failed, c.parsed, c.lastError)
+	}
+}
+
+func recordParseOutcome(websiteID, sourceID string, parsed, failed int64, lastError string) {
+	key := websiteID + ":" + sourceID
+	parseFailureMu.Lock()
+	defer parseFailureMu.Unlock()
+	stat, ok := parseFailures[key]
+	if !ok {
+		stat = &SourceParseFailure{WebsiteID: websiteID, SourceID: sourceID}
+		parseFailures[key] = stat
+	}
+	stat.Parsed += parsed
+	stat.Failed += failed
+	if failed > 0 {
+		stat.LastError = lastError
+		stat.LastFailedAt = time.Now().Unix()
+	}
+	if total := stat.Parsed + stat.Failed; total > 0 {
+		stat.FailureRatio = float64(stat.Failed) / float64(total)
+	}
+}
+
+// GetParseFailureStats 返回各日志来源的解析失败统计，按站点与来源排序
+func GetParseFailureStats() []SourceParseFailure {
+	parseFailureMu.RLock()
+	results := make([]SourceParseFailure, 0, len(parseFailures))
+	for _, stat := range parseFailures {
+		results = append(results, *stat)
+	}
+	parseFailureMu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].WebsiteID != results[j].WebsiteID {
+			return results[i].WebsiteID < results[j].WebsiteID
+		}
+		return results[i].SourceID < results[j].SourceID
+	})
+	return results
+}
+
+// ResetParseFailureStats 清空解析失败统计，websiteID 为空时清空全部站点
+func ResetParseFailureStats(websiteID string) {
+	parseFailureMu.Lock()
+	defer parseFailureMu.Unlock()
+	if websiteID == "" {
+		parseFailures = make(map[string]*SourceParseFailure)
+		return
+	}
+	for key, stat := range parseFailures {
+		if stat.WebsiteID == websiteID {
+			delete(parseFailures, key)
+		}
+	}
+}
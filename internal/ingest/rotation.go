@@ -0,0 +1,346 @@
+package ingest
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/ingest/source"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fingerprintSize 指纹最多覆盖的文件开头字节数
+	fingerprintSize = 1024
+	// maxRotatedStates 每个网站（来源）暂存的轮转文件状态上限
+	maxRotatedStates = 32
+)
+
+// FileIdentity 标识文件本身而不是路径，用于识别轮转、截断以及改名/压缩后的同一文件
+type FileIdentity struct {
+	Device         uint64 `json:"dev,omitempty"`
+	Inode          uint64 `json:"inode,omitempty"`
+	Fingerprint    string `json:"fingerprint,omitempty"` // 文件开头 FingerprintLen 字节（压缩文件为解压后内容）的 sha1
+	FingerprintLen int64  `json:"fingerprint_len,omitempty"`
+}
+
+// headFingerprint 按长度缓存文件开头内容的指纹，避免一次扫描中重复读取
+type headFingerprint struct {
+	open  func() (io.ReadCloser, error)
+	cache map[int64]string
+}
+
+func newHeadFingerprint(open func() (io.ReadCloser, error), codec source.Codec) *headFingerprint {
+	return &headFingerprint{
+		open: func() (io.ReadCloser, error) {
+			reader, err := open()
+			if err != nil || !codec.Compressed() {
+				return reader, err
+			}
+			return openDecompressed(reader, codec)
+		},
+		cache: make(map[int64]string),
+	}
+}
+
+func localHeadFingerprint(path string, codec source.Codec) *headFingerprint {
+	return newHeadFingerprint(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}, codec)
+}
+
+// sum 返回开头 length 字节的指纹，内容不足 length 字节时返回 false
+func (h *headFingerprint) sum(length int64) (string, bool) {
+	if length <= 0 {
+		return "", false
+	}
+	if cached, ok := h.cache[length]; ok {
+		return cached, cached != ""
+	}
+	h.cache[length] = ""
+
+	reader, err := h.open()
+	if err != nil {
+		return "", false
+	}
+	defer reader.Close()
+
+	hash := sha1.New()
+	if n, _ := io.CopyN(hash, reader, length); n < length {
+		return "", false
+	}
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+	h.cache[length] = fingerprint
+	return fingerprint, true
+}
+
+// sameFile 判断路径上的当前文件是否仍是记录中的文件：
+// 设备号+inode 变化（create 方式轮转），或开头内容与指纹不一致（copytruncate 后重新写入）都视为新文件
+func (id FileIdentity) sameFile(device, inode uint64, head *headFingerprint) bool {
+	if id.Inode != 0 && inode != 0 && (id.Device != device || id.Inode != inode) {
+		return false
+	}
+	if id.Fingerprint != "" {
+		fingerprint, ok := head.sum(id.FingerprintLen)
+		return ok && fingerprint == id.Fingerprint
+	}
+	return true
+}
+
+// claims 判断记录中的文件是否就是给定文件（改名后 inode 不变，复制或压缩后开头内容不变）。
+// 有指纹时以指纹为准，避免删除后被复用的 inode 误认。
+func (id FileIdentity) claims(device, inode uint64, head *headFingerprint) bool {
+	if id.Fingerprint != "" {
+		fingerprint, ok := head.sum(id.FingerprintLen)
+		return ok && fingerprint == id.Fingerprint
+	}
+	return id.Inode != 0 && id.Device == device && id.Inode == inode
+}
+
+// refresh 记录当前文件的身份，指纹随文件增长覆盖到 fingerprintSize 字节为止
+func (id *FileIdentity) refresh(device, inode uint64, size int64, head *headFingerprint) {
+	if inode != 0 {
+		id.Device = device
+		id.Inode = inode
+	}
+	length := size
+	if length > fingerprintSize {
+		length = fingerprintSize
+	}
+	if length <= id.FingerprintLen {
+		return
+	}
+	if fingerprint, ok := head.sum(length); ok {
+		id.Fingerprint = fingerprint
+		id.FingerprintLen = length
+	}
+}
+
+// findRenamedFile 在同一目录下查找 inode 相同的其他文件（如 access.log 被改名为 access.log.1）
+func findRenamedFile(path string, id FileIdentity) string {
+	if id.Inode == 0 {
+		return ""
+	}
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		candidate := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || normalizeLogPath(candidate) == normalizeLogPath(path) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		device, inode := source.FileID(info)
+		if device == id.Device && inode == id.Inode {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// fileStillAtPath 判断记录中的文件是否仍在原路径上
+func fileStillAtPath(path string, state FileState) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if info.Size() < state.LastSize {
+		return false
+	}
+	device, inode := source.FileID(info)
+	return state.sameFile(device, inode, localHeadFingerprint(path, source.FileCodec(path)))
+}
+
+// retireFileState 路径已指向新文件时处理旧文件的状态：旧文件被改名时把状态移到新路径并读完剩余内容，
+// 否则暂存起来，等复制或压缩出的归档文件出现时通过指纹认领，避免重复导入
+func (p *LogParser) retireFileState(websiteID, logPath string, state FileState, parserResult *ParserResult) {
+	p.deleteFileState(websiteID, logPath)
+
+	if renamed := findRenamedFile(logPath, state.FileIdentity); renamed != "" {
+		if _, exists := p.getFileState(websiteID, renamed); !exists {
+			logrus.Infof("网站 %s 的日志文件 %s 已改名为 %s，继续读取剩余内容", websiteID, logPath, renamed)
+			p.setFileState(websiteID, renamed, state)
+			p.scanSingleFile(websiteID, renamed, parserResult)
+		}
+		return
+	}
+
+	if state.Inode == 0 && state.Fingerprint == "" {
+		return
+	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	websiteState := p.ensureWebsiteState(websiteID)
+	websiteState.RotatedFiles = append(websiteState.RotatedFiles, state)
+	if len(websiteState.RotatedFiles) > maxRotatedStates {
+		websiteState.RotatedFiles = websiteState.RotatedFiles[len(websiteState.RotatedFiles)-maxRotatedStates:]
+	}
+	p.states[websiteID] = websiteState
+}
+
+// adoptRotatedFileState 为新出现的路径查找其来源文件的状态：其他路径下已不在原处的文件，
+// 或暂存的轮转文件。找到时返回该状态，扫描从上次读取的位置继续。
+// 比对指纹需要读取文件，在状态的副本上查找；调用方持有该网站的扫描锁，期间其他协程不会修改这些状态。
+func (p *LogParser) adoptRotatedFileState(
+	websiteID, logPath string, device, inode uint64, head *headFingerprint) (FileState, bool) {
+	p.stateMu.RLock()
+	websiteState, ok := p.states[websiteID]
+	files := make(map[string]FileState, len(websiteState.Files))
+	for path, state := range websiteState.Files {
+		files[path] = state
+	}
+	rotatedFiles := append([]FileState(nil), websiteState.RotatedFiles...)
+	p.stateMu.RUnlock()
+	if !ok {
+		return FileState{}, false
+	}
+
+	normalizedPath := normalizeLogPath(logPath)
+	for otherPath, other := range files {
+		if otherPath == normalizedPath || !other.claims(device, inode, head) {
+			continue
+		}
+		if fileStillAtPath(otherPath, other) {
+			continue
+		}
+		p.deleteFileState(websiteID, otherPath)
+		return other, true
+	}
+
+	for i, rotated := range rotatedFiles {
+		if !rotated.claims(device, inode, head) {
+			continue
+		}
+		p.stateMu.Lock()
+		websiteState = p.ensureWebsiteState(websiteID)
+		websiteState.RotatedFiles = append(rotatedFiles[:i:i], rotatedFiles[i+1:]...)
+		p.states[websiteID] = websiteState
+		p.stateMu.Unlock()
+		return rotated, true
+	}
+	return FileState{}, false
+}
+
+func targetHeadFingerprint(ctx context.Context, src source.LogSource, target source.TargetRef, codec source.Codec) *headFingerprint {
+	return newHeadFingerprint(func() (io.ReadCloser, error) {
+		return src.OpenRange(ctx, target, 0, -1)
+	}, codec)
+}
+
+// targetStillAtKey 判断记录中的目标文件是否仍在原路径上
+func targetStillAtKey(ctx context.Context, src source.LogSource, target source.TargetRef, state TargetState) bool {
+	meta, err := src.Stat(ctx, target)
+	if err != nil || meta.Size < state.LastSize {
+		return false
+	}
+	return state.sameFile(meta.Device, meta.Inode, targetHeadFingerprint(ctx, src, target, meta.Codec))
+}
+
+// retireTargetState 与 retireFileState 相同，用于本地来源（sources）的目标
+func (p *LogParser) retireTargetState(
+	ctx context.Context,
+	websiteID string,
+	src source.LogSource,
+	target source.TargetRef,
+	state TargetState,
+	parserResult *ParserResult,
+) {
+	p.deleteTargetState(websiteID, buildTargetStateKey(target.SourceID, target.Key))
+
+	if renamed := findRenamedFile(target.Key, state.FileIdentity); renamed != "" {
+		renamedKey := buildTargetStateKey(target.SourceID, renamed)
+		if _, exists := p.getTargetState(websiteID, renamedKey); !exists {
+			logrus.Infof("网站 %s 的日志文件 %s 已改名为 %s，继续读取剩余内容", websiteID, target.Key, renamed)
+			p.setTargetState(websiteID, renamedKey, state)
+			renamedTarget := source.TargetRef{WebsiteID: target.WebsiteID, SourceID: target.SourceID, Key: renamed}
+			if err := p.scanTarget(ctx, websiteID, src, renamedTarget, parserResult); err != nil {
+				logrus.WithError(err).Warnf("读取网站 %s 改名后的日志文件 %s 失败", websiteID, renamed)
+			}
+		}
+		return
+	}
+
+	if state.Inode == 0 && state.Fingerprint == "" {
+		return
+	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	websiteState := p.ensureWebsiteState(websiteID)
+	if websiteState.RotatedTargets == nil {
+		websiteState.RotatedTargets = make(map[string][]TargetState)
+	}
+	rotated := append(websiteState.RotatedTargets[target.SourceID], state)
+	if len(rotated) > maxRotatedStates {
+		rotated = rotated[len(rotated)-maxRotatedStates:]
+	}
+	websiteState.RotatedTargets[target.SourceID] = rotated
+	p.states[websiteID] = websiteState
+}
+
+// adoptRotatedTargetState 与 adoptRotatedFileState 相同，只在同一来源的目标之间查找
+func (p *LogParser) adoptRotatedTargetState(
+	ctx context.Context,
+	websiteID string,
+	src source.LogSource,
+	target source.TargetRef,
+	meta source.TargetMeta,
+	head *headFingerprint,
+) (TargetState, bool) {
+	targetKey := buildTargetStateKey(target.SourceID, target.Key)
+	prefix := buildTargetStateKey(target.SourceID, "")
+
+	p.stateMu.RLock()
+	websiteState, ok := p.states[websiteID]
+	targets := make(map[string]TargetState)
+	for key, state := range websiteState.Targets {
+		if key != targetKey && strings.HasPrefix(key, prefix) {
+			targets[key] = state
+		}
+	}
+	rotated := append([]TargetState(nil), websiteState.RotatedTargets[target.SourceID]...)
+	p.stateMu.RUnlock()
+	if !ok {
+		return TargetState{}, false
+	}
+
+	for otherKey, other := range targets {
+		if !other.claims(meta.Device, meta.Inode, head) {
+			continue
+		}
+		otherTarget := source.TargetRef{
+			WebsiteID: target.WebsiteID,
+			SourceID:  target.SourceID,
+			Key:       strings.TrimPrefix(otherKey, prefix),
+		}
+		if targetStillAtKey(ctx, src, otherTarget, other) {
+			continue
+		}
+		p.deleteTargetState(websiteID, otherKey)
+		return other, true
+	}
+
+	for i, candidate := range rotated {
+		if !candidate.claims(meta.Device, meta.Inode, head) {
+			continue
+		}
+		p.stateMu.Lock()
+		websiteState = p.ensureWebsiteState(websiteID)
+		if websiteState.RotatedTargets == nil {
+			websiteState.RotatedTargets = make(map[string][]TargetState)
+		}
+		websiteState.RotatedTargets[target.SourceID] = append(rotated[:i:i], rotated[i+1:]...)
+		p.states[websiteID] = websiteState
+		p.stateMu.Unlock()
+		return candidate, true
+	}
+	return TargetState{}, false
+}
@@ -0,0 +1,157 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+// defaultScanWorkers 未配置 system.scanWorkers 时同时扫描的网站/来源数
+const defaultScanWorkers = 4
+
+// scanJob 一次扫描中的最小调度单元：未配置 sources 的网站整体为一个单元，
+// 配置了 sources 时每个来源为一个单元
+type scanJob struct {
+	index     int // 所属网站在结果中的位置
+	websiteID string
+	website   config.WebsiteConfig
+	source    *config.SourceConfig // 为空表示扫描 logPath
+}
+
+type scanJobResult struct {
+	ParserResult
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// lockScan 锁定网站（sourceID 为空时）或网站下的单个来源，返回值用于解锁。定时扫描、回填（只处理 logPath 文件，
+// 按网站加锁）与流式来源的跟随/增量扫描持有该锁，同一把锁下依次读取文件并推进 FileState/TargetState，
+// 不同网站与来源之间互不等待。推送写入（syslog、fluent、Loki、ES、OTLP、agent）不读取文件，不取该锁，
+// 可与同一网站的扫描、回填同时进行，其 TargetState 经 updateTargetState 在 stateMu 内更新。
+func (p *LogParser) lockScan(websiteID, sourceID string) func() {
+	key := websiteID
+	if sourceID != "" {
+		key = websiteID + ":" + sourceID
+	}
+	p.scanLocksMu.Lock()
+	mu, ok := p.scanLocks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		p.scanLocks[key] = mu
+	}
+	p.scanLocksMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (p *LogParser) scanNginxLogsInternal(websiteIDs []string) []ParserResult {
+	setParsingTotalBytes(p.calculateTotalBytesToScan(websiteIDs))
+	parserResults := make([]ParserResult, len(websiteIDs))
+
+	jobsByWebsite := make([][]scanJob, len(websiteIDs))
+	for i, id := range websiteIDs {
+		website, _ := config.GetWebsiteByID(id)
+		parserResults[i] = EmptyParserResult(website.Name, id)
+		if len(website.Sources) == 0 {
+			jobsByWebsite[i] = []scanJob{{index: i, websiteID: id, website: website}}
+			continue
+		}
+		for j := range website.Sources {
+			jobsByWebsite[i] = append(jobsByWebsite[i], scanJob{
+				index:     i,
+				websiteID: id,
+				website:   website,
+				source:    &website.Sources[j],
+			})
+		}
+	}
+
+	// 各网站的来源交替排队，来源较多的网站不会占满所有协程
+	var jobs []scanJob
+	for round := 0; ; round++ {
+		added := false
+		for _, websiteJobs := range jobsByWebsite {
+			if round < len(websiteJobs) {
+				jobs = append(jobs, websiteJobs[round])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	results := p.runScanJobs(jobs)
+
+	startedAt := make([]time.Time, len(websiteIDs))
+	finishedAt := make([]time.Time, len(websiteIDs))
+	for i, job := range jobs {
+		result := results[i]
+		merged := &parserResults[job.index]
+		merged.TotalEntries += result.TotalEntries
+		if !result.Success {
+			merged.Success = false
+			merged.Error = result.Error
+		}
+		if startedAt[job.index].IsZero() || result.startedAt.Before(startedAt[job.index]) {
+			startedAt[job.index] = result.startedAt
+		}
+		if result.finishedAt.After(finishedAt[job.index]) {
+			finishedAt[job.index] = result.finishedAt
+		}
+	}
+	for i := range parserResults {
+		parserResults[i].Duration = finishedAt[i].Sub(startedAt[i])
+	}
+
+	p.updateState()
+	return parserResults
+}
+
+// runScanJobs 以 p.scanWorkers 个协程并发执行扫描单元，返回与 jobs 一一对应的结果
+func (p *LogParser) runScanJobs(jobs []scanJob) []scanJobResult {
+	results := make([]scanJobResult, len(jobs))
+	workers := min(p.scanWorkers, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = p.runScanJob(jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+func (p *LogParser) runScanJob(job scanJob) scanJobResult {
+	sourceID := ""
+	if job.source != nil {
+		sourceID = job.source.ID
+	}
+	unlock := p.lockScan(job.websiteID, sourceID)
+	defer unlock()
+
+	result := scanJobResult{
+		ParserResult: EmptyParserResult(job.website.Name, job.websiteID),
+		startedAt:    time.Now(),
+	}
+	if job.source != nil {
+		p.scanSource(job.websiteID, *job.source, &result.ParserResult)
+	} else {
+		p.scanLogPath(job.websiteID, job.website, &result.ParserResult)
+	}
+
+	p.refreshWebsiteRanges(job.websiteID)
+	p.updateState()
+	result.finishedAt = time.Now()
+	return result
+}
@@ -38,9 +38,10 @@ func (s *AgentSource) OpenRange(ctx context.Context, target TargetRef, start, en
 	return nil, ErrRangeNotSupported
 }
 
-func (s *AgentSource) OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error) {
+func (s *AgentSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
 	_ = ctx
 	_ = target
+	_ = start
 	return nil, ErrStreamNotSupported
 }
 
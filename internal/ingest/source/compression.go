@@ -0,0 +1,204 @@
+package source
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec 日志文件的压缩格式
+type Codec string
+
+const (
+	CodecNone  Codec = ""
+	CodecGzip  Codec = "gzip"
+	CodecZstd  Codec = "zstd"
+	CodecBzip2 Codec = "bzip2"
+	CodecXz    Codec = "xz"
+)
+
+// codecMagicLen 识别压缩格式需要读取的文件头字节数
+const codecMagicLen = 6
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// Compressed 是否为压缩文件
+func (c Codec) Compressed() bool {
+	return c != CodecNone
+}
+
+// CodecByName 按扩展名识别压缩格式，无法识别时返回 CodecNone
+func CodecByName(name string) Codec {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".gzip"):
+		return CodecGzip
+	case strings.HasSuffix(lower, ".zst"), strings.HasSuffix(lower, ".zstd"):
+		return CodecZstd
+	case strings.HasSuffix(lower, ".bz2"):
+		return CodecBzip2
+	case strings.HasSuffix(lower, ".xz"):
+		return CodecXz
+	default:
+		return CodecNone
+	}
+}
+
+// DetectCodec 按文件头的魔数识别压缩格式
+func DetectCodec(head []byte) Codec {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CodecGzip
+	case bytes.HasPrefix(head, zstdMagic):
+		return CodecZstd
+	case bytes.HasPrefix(head, xzMagic):
+		return CodecXz
+	case len(head) >= 4 && bytes.HasPrefix(head, bzip2Magic) && head[3] >= '1' && head[3] <= '9':
+		return CodecBzip2
+	default:
+		return CodecNone
+	}
+}
+
+// ParseCompression 解析 compression 配置，auto 或留空时返回 false，表示需要按扩展名与文件头识别
+func ParseCompression(value string) (Codec, bool, error) {
+	switch normalizeCompression(value) {
+	case "", "auto":
+		return CodecNone, false, nil
+	case "none":
+		return CodecNone, true, nil
+	case "gz", "gzip":
+		return CodecGzip, true, nil
+	case "zst", "zstd":
+		return CodecZstd, true, nil
+	case "bz2", "bzip2":
+		return CodecBzip2, true, nil
+	case "xz":
+		return CodecXz, true, nil
+	default:
+		return CodecNone, false, ErrUnsupportedCompression
+	}
+}
+
+// NewDecompressor 返回解压后的内容，关闭时不会关闭 reader 本身
+func NewDecompressor(codec Codec, reader io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case CodecNone:
+		return io.NopCloser(reader), nil
+	case CodecGzip:
+		return gzip.NewReader(reader)
+	case CodecZstd:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CodecBzip2:
+		return io.NopCloser(bzip2.NewReader(reader)), nil
+	case CodecXz:
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
+
+// FileCodec 识别本地文件的压缩格式：先看扩展名，无法识别时读取文件头判断
+func FileCodec(path string) Codec {
+	if codec := CodecByName(path); codec.Compressed() {
+		return codec
+	}
+	codec, _ := sniffCodec(func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+	return codec
+}
+
+// sniffCodec 读取文件头识别压缩格式，无法读取时返回 false
+func sniffCodec(open func() (io.ReadCloser, error)) (Codec, bool) {
+	reader, err := open()
+	if err != nil {
+		return CodecNone, false
+	}
+	defer reader.Close()
+
+	head := make([]byte, codecMagicLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return CodecNone, false
+	}
+	return DetectCodec(head[:n]), true
+}
+
+// codecResolver 按来源的 compression 配置确定目标的压缩格式。
+// 远端目标读取文件头需要额外请求，识别结果按目标缓存。
+type codecResolver struct {
+	codec  Codec
+	forced bool
+
+	mu      sync.Mutex
+	sniffed map[string]Codec
+}
+
+func newCodecResolver(compression string) *codecResolver {
+	codec, forced, _ := ParseCompression(compression)
+	return &codecResolver{codec: codec, forced: forced, sniffed: make(map[string]Codec)}
+}
+
+// local 识别本地文件，读取文件头的代价很低，不做缓存
+func (r *codecResolver) local(path string) Codec {
+	if r.forced {
+		return r.codec
+	}
+	return FileCodec(path)
+}
+
+// remote 识别远端目标，open 用于读取目标开头的内容
+func (r *codecResolver) remote(key string, open func() (io.ReadCloser, error)) Codec {
+	if r.forced {
+		return r.codec
+	}
+	if codec := CodecByName(key); codec.Compressed() {
+		return codec
+	}
+
+	r.mu.Lock()
+	codec, ok := r.sniffed[key]
+	r.mu.Unlock()
+	if ok {
+		return codec
+	}
+	codec, ok = sniffCodec(open)
+	if !ok {
+		// 读取失败不缓存，下次列举时重试
+		return CodecNone
+	}
+	r.mu.Lock()
+	r.sniffed[key] = codec
+	r.mu.Unlock()
+	return codec
+}
+
+// headOpener 返回读取目标开头内容的函数，只读取前几个字节后即关闭
+func headOpener(ctx context.Context, src LogSource, target TargetRef) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return src.OpenRange(ctx, target, 0, -1)
+	}
+}
@@ -0,0 +1,132 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DockerSource 读取 Docker json-file 日志驱动写入的容器日志，
+// 按容器名或标签从 config.v2.json 中发现容器，读取 <id>-json.log 及轮转出的 <id>-json.log.N。
+// 文件的读取、跟随与轮转识别与本地文件相同，外层 JSON 由解析阶段拆开。
+type DockerSource struct {
+	*LocalSource
+	root      string
+	container string
+	labels    map[string]string
+}
+
+func NewDockerSource(websiteID, id, root, container string, labels map[string]string, pollInterval time.Duration) *DockerSource {
+	return &DockerSource{
+		LocalSource: NewLocalSource(websiteID, id, "", "", "auto", pollInterval),
+		root:        root,
+		container:   strings.TrimPrefix(strings.TrimSpace(container), "/"),
+		labels:      labels,
+	}
+}
+
+func (s *DockerSource) Type() SourceType {
+	return SourceDocker
+}
+
+// dockerContainerConfig config.v2.json 中用到的字段
+type dockerContainerConfig struct {
+	ID     string `json:"ID"`
+	Name   string `json:"Name"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+func (s *DockerSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
+	_ = ctx
+	configs, err := filepath.Glob(filepath.Join(s.root, "containers", "*", "config.v2.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []TargetRef
+	for _, configPath := range configs {
+		container, err := readDockerContainerConfig(configPath)
+		if err != nil || !s.matches(container) {
+			continue
+		}
+		for _, logPath := range dockerLogFiles(filepath.Dir(configPath), container.ID) {
+			info, err := os.Stat(logPath)
+			if err != nil {
+				continue
+			}
+			targets = append(targets, TargetRef{
+				WebsiteID: s.websiteID,
+				SourceID:  s.id,
+				Key:       logPath,
+				Meta:      s.meta(logPath, info),
+			})
+		}
+	}
+	return targets, nil
+}
+
+func readDockerContainerConfig(configPath string) (dockerContainerConfig, error) {
+	var container dockerContainerConfig
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return container, err
+	}
+	if err := json.Unmarshal(data, &container); err != nil {
+		return container, err
+	}
+	if container.ID == "" {
+		container.ID = filepath.Base(filepath.Dir(configPath))
+	}
+	return container, nil
+}
+
+// matches 判断容器是否符合 container 与 labels 条件，两者都配置时需同时满足
+func (s *DockerSource) matches(container dockerContainerConfig) bool {
+	if s.container != "" {
+		name := strings.TrimPrefix(container.Name, "/")
+		if ok, _ := path.Match(s.container, name); !ok && !strings.HasPrefix(container.ID, s.container) {
+			return false
+		}
+	}
+	for key, pattern := range s.labels {
+		value, ok := container.Config.Labels[key]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerLogFiles 返回容器的当前日志及轮转出的历史日志，历史日志按从旧到新排列
+func dockerLogFiles(dir, id string) []string {
+	current := filepath.Join(dir, id+"-json.log")
+	rotated, _ := filepath.Glob(current + ".*")
+	sort.Slice(rotated, func(i, j int) bool {
+		return dockerRotationIndex(rotated[i], current) > dockerRotationIndex(rotated[j], current)
+	})
+	return append(rotated, current)
+}
+
+// dockerRotationIndex 返回 <id>-json.log.N(.gz) 中的 N，数字越大越旧
+func dockerRotationIndex(name, current string) int {
+	suffix := strings.TrimPrefix(name, current+".")
+	suffix = strings.TrimSuffix(suffix, ".gz")
+	index := 0
+	for _, ch := range suffix {
+		if ch < '0' || ch > '9' {
+			return 0
+		}
+		index = index*10 + int(ch-'0')
+	}
+	return index
+}
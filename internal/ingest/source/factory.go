@@ -3,6 +3,7 @@ package source
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/likaia/nginxpulse/internal/config"
 )
@@ -10,7 +11,7 @@ import (
 func NewFromConfig(websiteID string, cfg config.SourceConfig) (LogSource, error) {
 	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
 	case string(SourceLocal):
-		return NewLocalSource(websiteID, cfg.ID, cfg.Path, cfg.Pattern, cfg.Compression), nil
+		return NewLocalSource(websiteID, cfg.ID, cfg.Path, cfg.Pattern, cfg.Compression, PollInterval(cfg)), nil
 	case string(SourceSFTP):
 		keyFile := ""
 		password := ""
@@ -29,6 +30,7 @@ func NewFromConfig(websiteID string, cfg config.SourceConfig) (LogSource, error)
 			cfg.Path,
 			cfg.Pattern,
 			cfg.Compression,
+			PollInterval(cfg),
 		), nil
 	case string(SourceHTTP):
 		var index *HTTPIndex
@@ -48,6 +50,7 @@ func NewFromConfig(websiteID string, cfg config.SourceConfig) (LogSource, error)
 			normalizeRangePolicy(cfg.RangePolicy),
 			index,
 			cfg.Compression,
+			PollInterval(cfg),
 		), nil
 	case string(SourceS3):
 		return NewS3Source(
@@ -64,7 +67,32 @@ func NewFromConfig(websiteID string, cfg config.SourceConfig) (LogSource, error)
 		)
 	case string(SourceAgent):
 		return NewAgentSource(websiteID, cfg.ID), nil
+	case string(SourceSyslog):
+		return NewSyslogSource(websiteID, cfg.ID), nil
+	case string(SourceFluent):
+		return NewFluentSource(websiteID, cfg.ID), nil
+	case string(SourceDocker):
+		return NewDockerSource(websiteID, cfg.ID, config.DockerRoot(&cfg), cfg.Container, cfg.Labels, PollInterval(cfg)), nil
 	default:
 		return nil, fmt.Errorf("unsupported source type: %s", cfg.Type)
 	}
 }
+
+const (
+	defaultLocalPollInterval  = time.Second
+	defaultRemotePollInterval = 5 * time.Second
+)
+
+// PollInterval 返回来源的轮询间隔，未配置或格式无效时本地文件（含 docker）默认 1s，远端来源默认 5s
+func PollInterval(cfg config.SourceConfig) time.Duration {
+	if raw := strings.TrimSpace(cfg.PollInterval); raw != "" {
+		if parsed, err := time.ParseDuration(raw); err == nil && parsed > 0 {
+			return parsed
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case string(SourceLocal), string(SourceDocker):
+		return defaultLocalPollInterval
+	}
+	return defaultRemotePollInterval
+}
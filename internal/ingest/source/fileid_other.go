@@ -0,0 +1,11 @@
+//go:build !unix
+
+package source
+
+import "os"
+
+// FileID 当前平台无法获取 inode，返回 0 表示未知
+func FileID(info os.FileInfo) (uint64, uint64) {
+	_ = info
+	return 0, 0
+}
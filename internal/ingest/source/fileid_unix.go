@@ -0,0 +1,17 @@
+//go:build unix
+
+package source
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileID 返回文件的设备号与 inode，用于识别改名后的同一文件
+func FileID(info os.FileInfo) (uint64, uint64) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -0,0 +1,53 @@
+package source
+
+import (
+	"context"
+	"io"
+)
+
+// FluentSource 由 fluent 接收器推送日志，不支持主动拉取
+type FluentSource struct {
+	websiteID string
+	id        string
+}
+
+func NewFluentSource(websiteID, id string) *FluentSource {
+	return &FluentSource{
+		websiteID: websiteID,
+		id:        id,
+	}
+}
+
+func (s *FluentSource) ID() string {
+	return s.id
+}
+
+func (s *FluentSource) Type() SourceType {
+	return SourceFluent
+}
+
+func (s *FluentSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
+	_ = ctx
+	return nil, nil
+}
+
+func (s *FluentSource) OpenRange(ctx context.Context, target TargetRef, start, end int64) (io.ReadCloser, error) {
+	_ = ctx
+	_ = target
+	_ = start
+	_ = end
+	return nil, ErrRangeNotSupported
+}
+
+func (s *FluentSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
+	_ = ctx
+	_ = target
+	_ = start
+	return nil, ErrStreamNotSupported
+}
+
+func (s *FluentSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, error) {
+	_ = ctx
+	_ = target
+	return TargetMeta{}, ErrStreamNotSupported
+}
@@ -0,0 +1,146 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// followMissingTimeout 跟随中的文件被删除后等待重新创建的时间，超时后结束跟随
+	followMissingTimeout = time.Minute
+	// followRotateGrace 检测到文件被替换后继续读取旧文件的时间，
+	// 覆盖 nginx 收到 USR1 重新打开日志之前仍写入旧文件的窗口
+	followRotateGrace = 3 * time.Second
+)
+
+// fileWatcher 在文件可能发生变化时唤醒跟随读取
+type fileWatcher interface {
+	// Wait 阻塞到文件可能有变化或超过 timeout，ctx 结束时返回 ctx.Err()
+	Wait(ctx context.Context, timeout time.Duration) error
+	Close() error
+}
+
+// pollWatcher 不监听文件事件，仅按超时时间轮询
+type pollWatcher struct{}
+
+func (pollWatcher) Wait(ctx context.Context, timeout time.Duration) error {
+	return sleepContext(ctx, timeout)
+}
+
+func (pollWatcher) Close() error {
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// missingTracker 记录目标持续不存在的时间
+type missingTracker struct {
+	since time.Time
+}
+
+func (m *missingTracker) expired() bool {
+	if m.since.IsZero() {
+		m.since = time.Now()
+	}
+	return time.Since(m.since) > followMissingTimeout
+}
+
+func (m *missingTracker) reset() {
+	m.since = time.Time{}
+}
+
+// localFollower 持续读取本地文件的新写入，类似 tail -F
+type localFollower struct {
+	ctx          context.Context
+	path         string
+	file         *os.File
+	offset       int64
+	watcher      fileWatcher
+	pollInterval time.Duration
+	missing      missingTracker
+	replacedAt   time.Time
+}
+
+func (f *localFollower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.file.Read(p)
+		if n > 0 {
+			f.offset += int64(n)
+			if !f.replacedAt.IsZero() {
+				f.replacedAt = time.Now()
+			}
+			return n, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if f.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+
+		replaced, truncated, err := f.checkRotation()
+		if err != nil {
+			return 0, err
+		}
+		if truncated {
+			return 0, ErrStreamRotated
+		}
+		if replaced {
+			// 旧文件一段时间内没有新写入后再切换到新文件
+			if f.replacedAt.IsZero() {
+				f.replacedAt = time.Now()
+			} else if time.Since(f.replacedAt) >= followRotateGrace {
+				return 0, ErrStreamRotated
+			}
+		}
+
+		if err := f.watcher.Wait(f.ctx, f.pollInterval); err != nil {
+			if f.ctx.Err() != nil {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+	}
+}
+
+// checkRotation 判断路径是否已指向新文件（mv 轮转）或文件被截断（copytruncate）。
+// 文件暂时不存在时继续等待新文件创建，长时间不存在则返回 io.EOF 结束跟随。
+func (f *localFollower) checkRotation() (bool, bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, false, err
+		}
+		if f.missing.expired() {
+			return false, false, io.EOF
+		}
+		return false, false, nil
+	}
+	f.missing.reset()
+
+	current, err := f.file.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	if !os.SameFile(info, current) {
+		return true, false, nil
+	}
+	return false, info.Size() < f.offset, nil
+}
+
+func (f *localFollower) Close() error {
+	f.watcher.Close()
+	return f.file.Close()
+}
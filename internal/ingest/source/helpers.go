@@ -9,17 +9,6 @@ func normalizeCompression(value string) string {
 	return strings.ToLower(strings.TrimSpace(value))
 }
 
-func isCompressedByName(name string, compression string) bool {
-	switch normalizeCompression(compression) {
-	case "gz":
-		return true
-	case "none":
-		return false
-	default:
-		return strings.HasSuffix(strings.ToLower(name), ".gz")
-	}
-}
-
 func normalizeRangePolicy(value string) RangePolicy {
 	switch strings.ToLower(strings.TrimSpace(value)) {
 	case string(RangeForce):
@@ -3,6 +3,7 @@ package source
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,14 +13,16 @@ import (
 )
 
 type HTTPSource struct {
-	websiteID   string
-	id          string
-	url         string
-	headers     map[string]string
-	rangePolicy RangePolicy
-	index       *HTTPIndex
-	compression string
-	client      *http.Client
+	websiteID    string
+	id           string
+	url          string
+	headers      map[string]string
+	rangePolicy  RangePolicy
+	index        *HTTPIndex
+	codecs       *codecResolver
+	client       *http.Client
+	streamClient *http.Client // 不设置整体超时，分块传输的响应可以长时间保持
+	pollInterval time.Duration
 }
 
 type HTTPIndex struct {
@@ -29,17 +32,19 @@ type HTTPIndex struct {
 	JSONMap map[string]string
 }
 
-func NewHTTPSource(websiteID, id, url string, headers map[string]string, rangePolicy RangePolicy, index *HTTPIndex, compression string) *HTTPSource {
+func NewHTTPSource(websiteID, id, url string, headers map[string]string, rangePolicy RangePolicy, index *HTTPIndex, compression string, pollInterval time.Duration) *HTTPSource {
 	client := &http.Client{Timeout: 30 * time.Second}
 	return &HTTPSource{
-		websiteID:   websiteID,
-		id:          id,
-		url:         url,
-		headers:     headers,
-		rangePolicy: rangePolicy,
-		index:       index,
-		compression: compression,
-		client:      client,
+		websiteID:    websiteID,
+		id:           id,
+		url:          url,
+		headers:      headers,
+		rangePolicy:  rangePolicy,
+		index:        index,
+		codecs:       newCodecResolver(compression),
+		client:       client,
+		streamClient: &http.Client{},
+		pollInterval: pollInterval,
 	}
 }
 
@@ -53,14 +58,13 @@ func (s *HTTPSource) Type() SourceType {
 
 func (s *HTTPSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 	if s.index == nil {
-		return []TargetRef{{
+		target := TargetRef{
 			WebsiteID: s.websiteID,
 			SourceID:  s.id,
 			Key:       s.url,
-			Meta: TargetMeta{
-				Compressed: isCompressedByName(s.url, s.compression),
-			},
-		}}, nil
+		}
+		target.Meta.Codec = s.codecs.remote(s.url, headOpener(ctx, s, target))
+		return []TargetRef{target}, nil
 	}
 
 	indexURL := s.index.URL
@@ -120,9 +124,7 @@ func (s *HTTPSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 			continue
 		}
 
-		meta := TargetMeta{
-			Compressed: isCompressedByName(path, s.compression),
-		}
+		meta := TargetMeta{}
 		if sizeValue, ok := obj[sizeField]; ok {
 			meta.Size = parseInt64(sizeValue)
 		}
@@ -132,23 +134,38 @@ func (s *HTTPSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 		if mtimeValue, ok := obj[mtimeField]; ok {
 			meta.ModTime = parseTimeValue(mtimeValue)
 		}
-		if compressedValue, ok := obj[compressedField]; ok {
-			if parsed, ok := compressedValue.(bool); ok {
-				meta.Compressed = parsed
-			}
-		}
-
-		targets = append(targets, TargetRef{
+		target := TargetRef{
 			WebsiteID: s.websiteID,
 			SourceID:  s.id,
 			Key:       path,
 			Meta:      meta,
-		})
+		}
+		target.Meta.Codec = s.indexCodec(ctx, target, obj[compressedField])
+		targets = append(targets, target)
 	}
 
 	return targets, nil
 }
 
+// indexCodec 确定索引中目标的压缩格式，索引的 compressed 字段可以是布尔值或压缩格式名称
+func (s *HTTPSource) indexCodec(ctx context.Context, target TargetRef, value interface{}) Codec {
+	switch compressed := value.(type) {
+	case bool:
+		if !compressed {
+			return CodecNone
+		}
+		if codec := CodecByName(target.Key); codec.Compressed() {
+			return codec
+		}
+		return CodecGzip
+	case string:
+		if codec, forced, err := ParseCompression(compressed); err == nil && forced {
+			return codec
+		}
+	}
+	return s.codecs.remote(target.Key, headOpener(ctx, s, target))
+}
+
 func (s *HTTPSource) OpenRange(ctx context.Context, target TargetRef, start, end int64) (io.ReadCloser, error) {
 	if s.rangePolicy == RangeFull && start > 0 {
 		return nil, ErrRangeNotSupported
@@ -191,10 +208,23 @@ func (s *HTTPSource) OpenRange(ctx context.Context, target TargetRef, start, end
 	return nil, fmt.Errorf("http status %d", resp.StatusCode)
 }
 
-func (s *HTTPSource) OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error) {
-	_ = ctx
-	_ = target
-	return nil, ErrStreamNotSupported
+// OpenStream 以 Range 请求从 start 读取目标：服务端保持分块响应时持续读取，
+// 响应结束后按 pollInterval 从新的偏移再次请求
+func (s *HTTPSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
+	follower := &httpFollower{
+		ctx:          ctx,
+		source:       s,
+		url:          target.Key,
+		offset:       start,
+		pollInterval: s.pollInterval,
+	}
+	// 首次请求同步完成，以便地址错误、鉴权失败等问题直接返回给调用方
+	body, err := follower.open()
+	if err != nil && !errors.Is(err, errNoNewData) {
+		return nil, err
+	}
+	follower.body = body
+	return follower, nil
 }
 
 func (s *HTTPSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, error) {
@@ -213,7 +243,7 @@ func (s *HTTPSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, er
 	defer resp.Body.Close()
 
 	meta := TargetMeta{
-		Compressed: isCompressedByName(target.Key, s.compression),
+		Codec: s.codecs.remote(target.Key, headOpener(ctx, s, target)),
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -286,3 +316,142 @@ func parseTimeValue(value interface{}) time.Time {
 	}
 	return time.Time{}
 }
+
+// errNoNewData 目标没有超出当前偏移的新数据
+var errNoNewData = errors.New("no new data")
+
+// httpFollower 持续读取 HTTP 目标的新增内容
+type httpFollower struct {
+	ctx          context.Context
+	source       *HTTPSource
+	url          string
+	offset       int64
+	body         io.ReadCloser
+	pollInterval time.Duration
+}
+
+func (f *httpFollower) Read(p []byte) (int, error) {
+	for {
+		if f.body != nil {
+			n, err := f.body.Read(p)
+			if n > 0 {
+				f.offset += int64(n)
+				return n, nil
+			}
+			if err == nil {
+				continue
+			}
+			f.body.Close()
+			f.body = nil
+			if f.ctx.Err() != nil {
+				return 0, io.EOF
+			}
+			if !errors.Is(err, io.EOF) {
+				return 0, err
+			}
+		}
+
+		if err := sleepContext(f.ctx, f.pollInterval); err != nil {
+			return 0, io.EOF
+		}
+		body, err := f.open()
+		if err != nil {
+			if errors.Is(err, errNoNewData) {
+				continue
+			}
+			if f.ctx.Err() != nil {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		f.body = body
+	}
+}
+
+// open 请求 offset 之后的内容；不支持 Range 的服务端返回完整内容时跳过已读部分
+func (f *httpFollower) open() (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.source.headers {
+		req.Header.Set(k, v)
+	}
+	if f.offset > 0 && f.source.rangePolicy != RangeFull {
+		req.Header.Set("Range", buildRangeHeader(f.offset, -1))
+	}
+
+	resp, err := f.source.streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if total := parseContentRangeTotal(resp.Header.Get("Content-Range")); total >= 0 && total < f.offset {
+			resp.Body.Close()
+			return nil, ErrStreamRotated
+		}
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		if total := parseContentRangeTotal(resp.Header.Get("Content-Range")); total >= 0 && total < f.offset {
+			return nil, ErrStreamRotated
+		}
+		return nil, errNoNewData
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if f.offset == 0 {
+			return resp.Body, nil
+		}
+		if resp.ContentLength >= 0 && resp.ContentLength < f.offset {
+			resp.Body.Close()
+			return nil, ErrStreamRotated
+		}
+		if resp.ContentLength == f.offset {
+			resp.Body.Close()
+			return nil, errNoNewData
+		}
+		if err := skipBytes(resp.Body, f.offset); err != nil {
+			resp.Body.Close()
+			if errors.Is(err, io.EOF) {
+				return nil, ErrStreamRotated
+			}
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("http status %d", resp.StatusCode)
+	}
+}
+
+func (f *httpFollower) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// parseContentRangeTotal 解析 "bytes 0-99/1234" 或 "bytes */1234" 中的总长度，未知时返回 -1
+func parseContentRangeTotal(value string) int64 {
+	idx := strings.LastIndexByte(value, '/')
+	if idx < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(value[idx+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+func skipBytes(reader io.Reader, n int64) error {
+	copied, err := io.CopyN(io.Discard, reader, n)
+	if err != nil {
+		return err
+	}
+	if copied < n {
+		return io.EOF
+	}
+	return nil
+}
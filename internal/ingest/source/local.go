@@ -5,23 +5,26 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type LocalSource struct {
-	websiteID   string
-	id          string
-	path        string
-	pattern     string
-	compression string
+	websiteID    string
+	id           string
+	path         string
+	pattern      string
+	codecs       *codecResolver
+	pollInterval time.Duration
 }
 
-func NewLocalSource(websiteID, id, path, pattern, compression string) *LocalSource {
+func NewLocalSource(websiteID, id, path, pattern, compression string, pollInterval time.Duration) *LocalSource {
 	return &LocalSource{
-		websiteID:   websiteID,
-		id:          id,
-		path:        path,
-		pattern:     pattern,
-		compression: compression,
+		websiteID:    websiteID,
+		id:           id,
+		path:         path,
+		pattern:      pattern,
+		codecs:       newCodecResolver(compression),
+		pollInterval: pollInterval,
 	}
 }
 
@@ -56,11 +59,7 @@ func (s *LocalSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 			WebsiteID: s.websiteID,
 			SourceID:  s.id,
 			Key:       path,
-			Meta: TargetMeta{
-				Size:       info.Size(),
-				ModTime:    info.ModTime(),
-				Compressed: isCompressedByName(path, s.compression),
-			},
+			Meta:      s.meta(path, info),
 		})
 	}
 	return targets, nil
@@ -88,10 +87,38 @@ func (s *LocalSource) OpenRange(ctx context.Context, target TargetRef, start, en
 	return file, nil
 }
 
-func (s *LocalSource) OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error) {
-	_ = ctx
-	_ = target
-	return nil, ErrStreamNotSupported
+func (s *LocalSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
+	file, err := os.Open(target.Key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if start > info.Size() {
+		file.Close()
+		return nil, ErrStreamRotated
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// 不支持 inotify 时退化为按轮询间隔检查文件变化
+	watcher, err := newFileWatcher(target.Key)
+	if err != nil {
+		watcher = pollWatcher{}
+	}
+	return &localFollower{
+		ctx:          ctx,
+		path:         target.Key,
+		file:         file,
+		offset:       start,
+		watcher:      watcher,
+		pollInterval: s.pollInterval,
+	}, nil
 }
 
 func (s *LocalSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, error) {
@@ -100,9 +127,16 @@ func (s *LocalSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, e
 	if err != nil {
 		return TargetMeta{}, err
 	}
+	return s.meta(target.Key, info), nil
+}
+
+func (s *LocalSource) meta(path string, info os.FileInfo) TargetMeta {
+	device, inode := FileID(info)
 	return TargetMeta{
-		Size:       info.Size(),
-		ModTime:    info.ModTime(),
-		Compressed: isCompressedByName(target.Key, s.compression),
-	}, nil
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Codec:   s.codecs.local(path),
+		Device:  device,
+		Inode:   inode,
+	}
 }
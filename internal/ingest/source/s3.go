@@ -14,17 +14,17 @@ import (
 )
 
 type S3Source struct {
-	websiteID   string
-	id          string
-	endpoint    string
-	region      string
-	bucket      string
-	prefix      string
-	pattern     string
-	accessKey   string
-	secretKey   string
-	compression string
-	client      *s3.Client
+	websiteID string
+	id        string
+	endpoint  string
+	region    string
+	bucket    string
+	prefix    string
+	pattern   string
+	accessKey string
+	secretKey string
+	codecs    *codecResolver
+	client    *s3.Client
 }
 
 func NewS3Source(websiteID, id, endpoint, region, bucket, prefix, pattern, accessKey, secretKey, compression string) (*S3Source, error) {
@@ -66,17 +66,17 @@ func NewS3Source(websiteID, id, endpoint, region, bucket, prefix, pattern, acces
 	})
 
 	return &S3Source{
-		websiteID:   websiteID,
-		id:          id,
-		endpoint:    endpoint,
-		region:      region,
-		bucket:      bucket,
-		prefix:      prefix,
-		pattern:     pattern,
-		accessKey:   accessKey,
-		secretKey:   secretKey,
-		compression: compression,
-		client:      client,
+		websiteID: websiteID,
+		id:        id,
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    bucket,
+		prefix:    prefix,
+		pattern:   pattern,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		codecs:    newCodecResolver(compression),
+		client:    client,
 	}, nil
 }
 
@@ -112,18 +112,18 @@ func (s *S3Source) ListTargets(ctx context.Context) ([]TargetRef, error) {
 			if obj.LastModified != nil {
 				modTime = *obj.LastModified
 			}
-			meta := TargetMeta{
-				Size:       aws.ToInt64(obj.Size),
-				ModTime:    modTime,
-				ETag:       strings.Trim(aws.ToString(obj.ETag), "\""),
-				Compressed: isCompressedByName(key, s.compression),
-			}
-			targets = append(targets, TargetRef{
+			target := TargetRef{
 				WebsiteID: s.websiteID,
 				SourceID:  s.id,
 				Key:       key,
-				Meta:      meta,
-			})
+				Meta: TargetMeta{
+					Size:    aws.ToInt64(obj.Size),
+					ModTime: modTime,
+					ETag:    strings.Trim(aws.ToString(obj.ETag), "\""),
+				},
+			}
+			target.Meta.Codec = s.codecs.remote(key, headOpener(ctx, s, target))
+			targets = append(targets, target)
 		}
 
 		if aws.ToBool(resp.IsTruncated) && resp.NextContinuationToken != nil {
@@ -153,9 +153,10 @@ func (s *S3Source) OpenRange(ctx context.Context, target TargetRef, start, end i
 	return resp.Body, nil
 }
 
-func (s *S3Source) OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error) {
+func (s *S3Source) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
 	_ = ctx
 	_ = target
+	_ = start
 	return nil, ErrStreamNotSupported
 }
 
@@ -173,10 +174,10 @@ func (s *S3Source) Stat(ctx context.Context, target TargetRef) (TargetMeta, erro
 		modTime = *resp.LastModified
 	}
 	return TargetMeta{
-		Size:       aws.ToInt64(resp.ContentLength),
-		ModTime:    modTime,
-		ETag:       strings.Trim(aws.ToString(resp.ETag), "\""),
-		Compressed: isCompressedByName(target.Key, s.compression),
+		Size:    aws.ToInt64(resp.ContentLength),
+		ModTime: modTime,
+		ETag:    strings.Trim(aws.ToString(resp.ETag), "\""),
+		Codec:   s.codecs.remote(target.Key, headOpener(ctx, s, target)),
 	}, nil
 }
 
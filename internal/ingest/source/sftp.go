@@ -2,6 +2,7 @@ package source
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -15,30 +16,32 @@ import (
 )
 
 type SFTPSource struct {
-	websiteID   string
-	id          string
-	host        string
-	port        int
-	user        string
-	keyFile     string
-	password    string
-	path        string
-	pattern     string
-	compression string
+	websiteID    string
+	id           string
+	host         string
+	port         int
+	user         string
+	keyFile      string
+	password     string
+	path         string
+	pattern      string
+	codecs       *codecResolver
+	pollInterval time.Duration
 }
 
-func NewSFTPSource(websiteID, id, host string, port int, user, keyFile, password, pathValue, pattern, compression string) *SFTPSource {
+func NewSFTPSource(websiteID, id, host string, port int, user, keyFile, password, pathValue, pattern, compression string, pollInterval time.Duration) *SFTPSource {
 	return &SFTPSource{
-		websiteID:   websiteID,
-		id:          id,
-		host:        host,
-		port:        port,
-		user:        user,
-		keyFile:     keyFile,
-		password:    password,
-		path:        pathValue,
-		pattern:     pattern,
-		compression: compression,
+		websiteID:    websiteID,
+		id:           id,
+		host:         host,
+		port:         port,
+		user:         user,
+		keyFile:      keyFile,
+		password:     password,
+		path:         pathValue,
+		pattern:      pattern,
+		codecs:       newCodecResolver(compression),
+		pollInterval: pollInterval,
 	}
 }
 
@@ -74,16 +77,17 @@ func (s *SFTPSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 				continue
 			}
 			fullPath := path.Join(dir, entry.Name())
-			targets = append(targets, TargetRef{
+			target := TargetRef{
 				WebsiteID: s.websiteID,
 				SourceID:  s.id,
 				Key:       fullPath,
 				Meta: TargetMeta{
-					Size:       entry.Size(),
-					ModTime:    entry.ModTime(),
-					Compressed: isCompressedByName(fullPath, s.compression),
+					Size:    entry.Size(),
+					ModTime: entry.ModTime(),
 				},
-			})
+			}
+			target.Meta.Codec = s.codecs.remote(fullPath, headOpener(ctx, s, target))
+			targets = append(targets, target)
 		}
 		return targets, nil
 	}
@@ -95,16 +99,17 @@ func (s *SFTPSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
 	if err != nil {
 		return nil, err
 	}
-	targets = append(targets, TargetRef{
+	target := TargetRef{
 		WebsiteID: s.websiteID,
 		SourceID:  s.id,
 		Key:       s.path,
 		Meta: TargetMeta{
-			Size:       info.Size(),
-			ModTime:    info.ModTime(),
-			Compressed: isCompressedByName(s.path, s.compression),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
 		},
-	})
+	}
+	target.Meta.Codec = s.codecs.remote(s.path, headOpener(ctx, s, target))
+	targets = append(targets, target)
 	return targets, nil
 }
 
@@ -139,10 +144,48 @@ func (s *SFTPSource) OpenRange(ctx context.Context, target TargetRef, start, end
 	return newReadCloser(reader, closer), nil
 }
 
-func (s *SFTPSource) OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error) {
-	_ = ctx
-	_ = target
-	return nil, ErrStreamNotSupported
+// OpenStream 保持一个 SFTP 连接，按 pollInterval 检查远端文件是否有新写入
+func (s *SFTPSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
+	client, sshClient, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	closeAll := func() {
+		client.Close()
+		sshClient.Close()
+	}
+
+	file, err := client.Open(target.Key)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		closeAll()
+		return nil, err
+	}
+	if start > info.Size() {
+		file.Close()
+		closeAll()
+		return nil, ErrStreamRotated
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		closeAll()
+		return nil, err
+	}
+
+	return &sftpFollower{
+		ctx:          ctx,
+		client:       client,
+		file:         file,
+		closer:       multiCloser{file, client, sshClient},
+		path:         target.Key,
+		offset:       start,
+		pollInterval: s.pollInterval,
+	}, nil
 }
 
 func (s *SFTPSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, error) {
@@ -158,9 +201,9 @@ func (s *SFTPSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, er
 		return TargetMeta{}, err
 	}
 	return TargetMeta{
-		Size:       info.Size(),
-		ModTime:    info.ModTime(),
-		Compressed: isCompressedByName(target.Key, s.compression),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Codec:   s.codecs.remote(target.Key, headOpener(ctx, s, target)),
 	}, nil
 }
 
@@ -219,3 +262,72 @@ func (m multiCloser) Close() error {
 	}
 	return nil
 }
+
+// sftpFollower 通过同一个 SFTP 连接持续读取远端文件，读到末尾后按 pollInterval 重试
+type sftpFollower struct {
+	ctx          context.Context
+	client       *sftp.Client
+	file         *sftp.File
+	closer       io.Closer
+	path         string
+	offset       int64
+	pollInterval time.Duration
+	missing      missingTracker
+}
+
+func (f *sftpFollower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.file.Read(p)
+		if n > 0 {
+			f.offset += int64(n)
+			return n, nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if f.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+
+		rotated, err := f.checkRotation()
+		if err != nil {
+			return 0, err
+		}
+		if rotated {
+			return 0, ErrStreamRotated
+		}
+		if err := sleepContext(f.ctx, f.pollInterval); err != nil {
+			return 0, io.EOF
+		}
+	}
+}
+
+// checkRotation 远端无法比较 inode：路径上的文件比已读位置小（截断），
+// 或比已打开的文件大（已打开的文件不再增长而路径指向了新文件）时视为轮转
+func (f *sftpFollower) checkRotation() (bool, error) {
+	// 先查路径再查已打开的文件，避免两次查询之间的写入被误判为轮转
+	pathInfo, err := f.client.Stat(f.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		if f.missing.expired() {
+			return false, io.EOF
+		}
+		return false, nil
+	}
+	f.missing.reset()
+	if pathInfo.Size() < f.offset {
+		return true, nil
+	}
+
+	openInfo, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	return pathInfo.Size() > openInfo.Size(), nil
+}
+
+func (f *sftpFollower) Close() error {
+	return f.closer.Close()
+}
@@ -0,0 +1,53 @@
+package source
+
+import (
+	"context"
+	"io"
+)
+
+// SyslogSource 由 syslog 接收器推送日志，不支持主动拉取
+type SyslogSource struct {
+	websiteID string
+	id        string
+}
+
+func NewSyslogSource(websiteID, id string) *SyslogSource {
+	return &SyslogSource{
+		websiteID: websiteID,
+		id:        id,
+	}
+}
+
+func (s *SyslogSource) ID() string {
+	return s.id
+}
+
+func (s *SyslogSource) Type() SourceType {
+	return SourceSyslog
+}
+
+func (s *SyslogSource) ListTargets(ctx context.Context) ([]TargetRef, error) {
+	_ = ctx
+	return nil, nil
+}
+
+func (s *SyslogSource) OpenRange(ctx context.Context, target TargetRef, start, end int64) (io.ReadCloser, error) {
+	_ = ctx
+	_ = target
+	_ = start
+	_ = end
+	return nil, ErrRangeNotSupported
+}
+
+func (s *SyslogSource) OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error) {
+	_ = ctx
+	_ = target
+	_ = start
+	return nil, ErrStreamNotSupported
+}
+
+func (s *SyslogSource) Stat(ctx context.Context, target TargetRef) (TargetMeta, error) {
+	_ = ctx
+	_ = target
+	return TargetMeta{}, ErrStreamNotSupported
+}
@@ -10,11 +10,14 @@ import (
 type SourceType string
 
 const (
-	SourceLocal SourceType = "local"
-	SourceSFTP  SourceType = "sftp"
-	SourceHTTP  SourceType = "http"
-	SourceS3    SourceType = "s3"
-	SourceAgent SourceType = "agent"
+	SourceLocal  SourceType = "local"
+	SourceSFTP   SourceType = "sftp"
+	SourceHTTP   SourceType = "http"
+	SourceS3     SourceType = "s3"
+	SourceAgent  SourceType = "agent"
+	SourceSyslog SourceType = "syslog"
+	SourceDocker SourceType = "docker"
+	SourceFluent SourceType = "fluent"
 )
 
 type RangePolicy string
@@ -26,8 +29,11 @@ const (
 )
 
 var (
-	ErrRangeNotSupported  = errors.New("range not supported")
-	ErrStreamNotSupported = errors.New("stream not supported")
+	ErrRangeNotSupported      = errors.New("range not supported")
+	ErrStreamNotSupported     = errors.New("stream not supported")
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+	// ErrStreamRotated 跟随中的目标被轮转或截断，调用方应从头重新打开
+	ErrStreamRotated = errors.New("stream target rotated")
 )
 
 type TargetRef struct {
@@ -38,10 +44,12 @@ type TargetRef struct {
 }
 
 type TargetMeta struct {
-	Size       int64
-	ModTime    time.Time
-	ETag       string
-	Compressed bool
+	Size    int64
+	ModTime time.Time
+	ETag    string
+	Codec   Codec
+	Device  uint64 // 仅本地文件，0 表示未知
+	Inode   uint64
 }
 
 type LogSource interface {
@@ -49,6 +57,8 @@ type LogSource interface {
 	Type() SourceType
 	ListTargets(ctx context.Context) ([]TargetRef, error)
 	OpenRange(ctx context.Context, target TargetRef, start, end int64) (io.ReadCloser, error)
-	OpenStream(ctx context.Context, target TargetRef) (io.ReadCloser, error)
+	// OpenStream 从 start 开始持续跟随目标的新写入：没有新数据时阻塞等待，
+	// 目标轮转/截断时返回 ErrStreamRotated，ctx 结束或目标长时间不存在时返回 io.EOF
+	OpenStream(ctx context.Context, target TargetRef, start int64) (io.ReadCloser, error)
 	Stat(ctx context.Context, target TargetRef) (TargetMeta, error)
 }
@@ -0,0 +1,97 @@
+//go:build linux
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWaitSlice 单次 poll 的最长等待时间，用于及时响应 ctx 结束
+const inotifyWaitSlice = 500 * time.Millisecond
+
+// inotifyWatcher 监听日志文件所在目录，被跟随的文件写入、创建、移动或删除时唤醒。
+// 监听目录而不是文件本身，轮转后新建的同名文件同样能被感知。
+type inotifyWatcher struct {
+	fd   int
+	name string
+	buf  []byte
+}
+
+func newFileWatcher(path string) (fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+		unix.IN_MOVED_TO | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB)
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &inotifyWatcher{
+		fd:   fd,
+		name: filepath.Base(path),
+		buf:  make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1)),
+	}, nil
+}
+
+func (w *inotifyWatcher) Wait(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > inotifyWaitSlice {
+			remaining = inotifyWaitSlice
+		}
+		fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(remaining/time.Millisecond)+1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		if n > 0 && w.drain() {
+			return nil
+		}
+	}
+}
+
+// drain 读出全部待处理事件，返回其中是否有与被跟随文件相关的事件
+func (w *inotifyWatcher) drain() bool {
+	matched := false
+	for {
+		n, err := unix.Read(w.fd, w.buf)
+		if err != nil || n < unix.SizeofInotifyEvent {
+			return matched
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&w.buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(w.buf[nameStart:nameEnd], "\x00"))
+			if name == w.name || event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				matched = true
+			}
+			offset = nameEnd
+		}
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	return unix.Close(w.fd)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package source
+
+// newFileWatcher 非 Linux 平台不使用文件事件，按轮询间隔检查文件变化
+func newFileWatcher(path string) (fileWatcher, error) {
+	_ = path
+	return pollWatcher{}, nil
+}
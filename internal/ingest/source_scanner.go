@@ -1,7 +1,6 @@
 package ingest
 
 import (
-	"compress/gzip"
 	"context"
 	"errors"
 	"strings"
@@ -12,40 +11,39 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func (p *LogParser) scanSources(websiteID string, website config.WebsiteConfig, parserResult *ParserResult) {
+// scanSource 扫描网站的单个来源，同一来源的目标共用解析器，依次读取
+func (p *LogParser) scanSource(websiteID string, srcCfg config.SourceConfig, parserResult *ParserResult) {
 	ctx := context.Background()
-	for _, srcCfg := range website.Sources {
-		if _, err := p.getLineParserForSource(websiteID, srcCfg.ID); err != nil {
-			parserResult.Success = false
-			parserResult.Error = err
-			continue
-		}
-		src, err := source.NewFromConfig(websiteID, srcCfg)
-		if err != nil {
-			parserResult.Success = false
-			parserResult.Error = err
-			continue
-		}
+	if _, err := p.getLineParserForSource(websiteID, srcCfg.ID); err != nil {
+		parserResult.Success = false
+		parserResult.Error = err
+		return
+	}
+	src, err := source.NewFromConfig(websiteID, srcCfg)
+	if err != nil {
+		parserResult.Success = false
+		parserResult.Error = err
+		return
+	}
 
-		mode := strings.ToLower(strings.TrimSpace(srcCfg.Mode))
-		if mode == "" {
-			mode = "poll"
-		}
-		if mode == "stream" {
-			continue
-		}
+	mode := strings.ToLower(strings.TrimSpace(srcCfg.Mode))
+	if mode == "" {
+		mode = "poll"
+	}
+	if mode == "stream" {
+		return
+	}
 
-		targets, err := src.ListTargets(ctx)
-		if err != nil {
+	targets, err := src.ListTargets(ctx)
+	if err != nil {
+		parserResult.Success = false
+		parserResult.Error = err
+		return
+	}
+	for _, target := range targets {
+		if err := p.scanTarget(ctx, websiteID, src, target, parserResult); err != nil {
 			parserResult.Success = false
 			parserResult.Error = err
-			continue
-		}
-		for _, target := range targets {
-			if err := p.scanTarget(ctx, websiteID, src, target, parserResult); err != nil {
-				parserResult.Success = false
-				parserResult.Error = err
-			}
 		}
 	}
 }
@@ -73,6 +71,12 @@ func (p *LogParser) scanTarget(
 		state.RecentCutoffTs = time.Now().AddDate(0, 0, -recentLogWindowDays).Unix()
 	}
 
+	// 只有本地文件能拿到 inode，远端目标读取文件头的代价较高，仍按大小与 ETag 判断轮转
+	var head *headFingerprint
+	if meta.Inode != 0 {
+		head = targetHeadFingerprint(ctx, src, target, meta.Codec)
+	}
+
 	reset := false
 	if ok {
 		if meta.Size > 0 && state.LastSize > 0 && meta.Size < state.LastSize {
@@ -84,13 +88,35 @@ func (p *LogParser) scanTarget(
 		if state.LastOffset > 0 && meta.Size > 0 && state.LastOffset > meta.Size {
 			reset = true
 		}
+		if head != nil && !state.sameFile(meta.Device, meta.Inode, head) {
+			reset = true
+		}
 	}
 	if reset {
+		if head != nil {
+			p.retireTargetState(ctx, websiteID, src, target, state, parserResult)
+		}
 		state = TargetState{RecentCutoffTs: state.RecentCutoffTs}
 		ok = false
 	}
 
-	needsFullScan := meta.Compressed
+	// 改名或压缩后的轮转文件从原文件读取到的位置继续，压缩归档按解压后的偏移跳过
+	var skipDecompressed int64
+	if !ok && head != nil {
+		if inherited, found := p.adoptRotatedTargetState(ctx, websiteID, src, target, meta, head); found {
+			logrus.Infof("网站 %s 的日志文件 %s 由已扫描的文件轮转而来，从上次位置继续扫描", websiteID, target.Key)
+			state = inherited
+			ok = true
+			if meta.Codec.Compressed() {
+				skipDecompressed = inherited.LastOffset
+				state.LastSize = 0
+				state.LastETag = ""
+				state.LastModTime = 0
+			}
+		}
+	}
+
+	needsFullScan := meta.Codec.Compressed()
 	if needsFullScan && ok {
 		sameETag := meta.ETag != "" && meta.ETag == state.LastETag
 		sameMod := meta.ETag == "" && meta.Size == state.LastSize && meta.ModTime.Unix() == state.LastModTime
@@ -108,6 +134,9 @@ func (p *LogParser) scanTarget(
 		state.LastSize = meta.Size
 		state.LastETag = meta.ETag
 		state.LastModTime = meta.ModTime.Unix()
+		if head != nil {
+			state.refresh(meta.Device, meta.Inode, meta.Size, head)
+		}
 		p.setTargetState(websiteID, targetKey, state)
 		return nil
 	}
@@ -137,6 +166,16 @@ func (p *LogParser) scanTarget(
 		window = parseWindow{minTs: state.RecentCutoffTs}
 	}
 
+	parser, err := p.getLineParserForSource(websiteID, target.SourceID)
+	if err != nil {
+		return err
+	}
+	if startOffset > 0 {
+		parser.RestoreFields(state.Fields)
+	} else {
+		parser.RestoreFields(nil)
+	}
+
 	var (
 		entriesCount int
 		bytesRead    int64
@@ -145,14 +184,22 @@ func (p *LogParser) scanTarget(
 	)
 
 	if needsFullScan {
-		gzReader, err := gzip.NewReader(reader)
+		decompressed, err := source.NewDecompressor(meta.Codec, reader)
 		if err != nil {
 			return err
 		}
-		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(gzReader, websiteID, target.SourceID, parserResult, window)
-		gzReader.Close()
+		if skipDecompressed > 0 {
+			if err := skipReaderBytes(decompressed, skipDecompressed); err != nil {
+				decompressed.Close()
+				return err
+			}
+		}
+		origin := lineOrigin{sourceID: target.SourceID, target: target.Key, offset: skipDecompressed}
+		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(decompressed, websiteID, origin, parserResult, window)
+		decompressed.Close()
 	} else {
-		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(reader, websiteID, target.SourceID, parserResult, window)
+		origin := lineOrigin{sourceID: target.SourceID, target: target.Key, offset: startOffset}
+		entriesCount, bytesRead, minTs, maxTs = p.parseLogLines(reader, websiteID, origin, parserResult, window)
 	}
 
 	updateTargetParsedRange(&state, minTs, maxTs)
@@ -170,6 +217,10 @@ func (p *LogParser) scanTarget(
 	state.LastSize = meta.Size
 	state.LastETag = meta.ETag
 	state.LastModTime = meta.ModTime.Unix()
+	state.Fields = parser.CurrentFields()
+	if head != nil {
+		state.refresh(meta.Device, meta.Inode, meta.Size, head)
+	}
 	p.setTargetState(websiteID, targetKey, state)
 
 	if entriesCount > 0 {
@@ -0,0 +1,388 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/source"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	streamFlushInterval  = time.Second
+	streamRelistInterval = 30 * time.Second
+	streamRetryInterval  = 5 * time.Second
+	streamReadBufferSize = 64 * 1024
+)
+
+// errStreamReset 跟随期间扫描状态被重置（如重新解析），需要从头读取目标
+var errStreamReset = errors.New("stream state reset")
+
+type streamLine struct {
+	text string
+	size int64
+}
+
+// RunStreams 为 mode=stream 的来源持续跟随日志写入：本地文件通过 inotify 感知写入，
+// HTTP 目标按 Range 持续读取，SFTP 按 pollInterval 轮询；S3 对象与压缩归档无法追加，
+// 按 pollInterval 增量扫描。每个目标在独立的 goroutine 中运行，直到 ctx 结束。
+func (p *LogParser) RunStreams(ctx context.Context) {
+	if p.demoMode {
+		return
+	}
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(websiteID)
+		if !ok {
+			continue
+		}
+		for _, srcCfg := range website.Sources {
+			if !isStreamSource(srcCfg) {
+				continue
+			}
+			go p.runSourceStream(ctx, websiteID, srcCfg)
+		}
+	}
+}
+
+func isStreamSource(cfg config.SourceConfig) bool {
+	if strings.ToLower(strings.TrimSpace(cfg.Mode)) != "stream" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case string(source.SourceAgent), string(source.SourceSyslog), string(source.SourceFluent):
+		// 推送型来源收到即入库，无需跟随
+		return false
+	default:
+		return true
+	}
+}
+
+// runSourceStream 定期列举来源的目标，为新出现的目标启动跟随
+func (p *LogParser) runSourceStream(ctx context.Context, websiteID string, srcCfg config.SourceConfig) {
+	src, err := source.NewFromConfig(websiteID, srcCfg)
+	if err != nil {
+		logrus.WithError(err).Errorf("网站 %s 的来源 %s 无法启动流式读取", websiteID, srcCfg.ID)
+		return
+	}
+	if _, err := p.getLineParserForSource(websiteID, srcCfg.ID); err != nil {
+		logrus.WithError(err).Errorf("网站 %s 的来源 %s 无法启动流式读取", websiteID, srcCfg.ID)
+		return
+	}
+
+	followable := src.Type() != source.SourceS3
+	relistInterval := streamRelistInterval
+	if !followable {
+		relistInterval = source.PollInterval(srcCfg)
+	}
+	logrus.Infof("网站 %s 的来源 %s 已启用流式读取", websiteID, srcCfg.ID)
+
+	var (
+		followingMu sync.Mutex
+		following   = make(map[string]bool)
+	)
+	ticker := time.NewTicker(relistInterval)
+	defer ticker.Stop()
+	for {
+		targets, err := src.ListTargets(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warnf("列举网站 %s 的来源 %s 失败", websiteID, srcCfg.ID)
+		}
+		for _, target := range targets {
+			if !followable || target.Meta.Codec.Compressed() {
+				p.scanStreamTarget(ctx, websiteID, src, target)
+				continue
+			}
+			followingMu.Lock()
+			if following[target.Key] {
+				followingMu.Unlock()
+				continue
+			}
+			following[target.Key] = true
+			followingMu.Unlock()
+
+			go func(target source.TargetRef) {
+				p.followTarget(ctx, websiteID, src, target)
+				followingMu.Lock()
+				delete(following, target.Key)
+				followingMu.Unlock()
+			}(target)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanStreamTarget 对无法跟随的目标做一次增量扫描
+func (p *LogParser) scanStreamTarget(ctx context.Context, websiteID string, src source.LogSource, target source.TargetRef) {
+	unlock := p.lockScan(websiteID, target.SourceID)
+	defer unlock()
+
+	result := EmptyParserResult("", websiteID)
+	if err := p.scanTarget(ctx, websiteID, src, target, &result); err != nil && ctx.Err() == nil {
+		logrus.WithError(err).Warnf("扫描网站 %s 的目标 %s 失败", websiteID, target.Key)
+	}
+	if result.TotalEntries > 0 {
+		p.refreshWebsiteRanges(websiteID)
+	}
+	p.updateState()
+}
+
+// followTarget 跟随单个目标，轮转后从头读取新文件，出错时等待后重试
+func (p *LogParser) followTarget(ctx context.Context, websiteID string, src source.LogSource, target source.TargetRef) {
+	targetKey := buildTargetStateKey(target.SourceID, target.Key)
+	for ctx.Err() == nil {
+		unlock := p.lockScan(websiteID, target.SourceID)
+		state, ok := p.prepareStreamState(ctx, websiteID, src, target)
+		unlock()
+
+		// 首次跟随与定时扫描一致，只导入最近窗口内的日志
+		window := parseWindow{}
+		if !ok {
+			window = parseWindow{minTs: time.Now().AddDate(0, 0, -recentLogWindowDays).Unix()}
+		}
+
+		err := p.consumeStream(ctx, websiteID, src, target, state.LastOffset, window)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, source.ErrStreamRotated):
+			logrus.Infof("网站 %s 的日志 %s 已轮转，从头读取新文件", websiteID, target.Key)
+			unlock := p.lockScan(websiteID, target.SourceID)
+			if state, ok := p.getTargetState(websiteID, targetKey); ok {
+				p.retireStreamTarget(ctx, websiteID, src, target, state)
+				p.setTargetState(websiteID, targetKey, TargetState{RecentCutoffTs: state.RecentCutoffTs})
+			}
+			p.updateState()
+			unlock()
+		case errors.Is(err, errStreamReset):
+			continue
+		case err == nil, errors.Is(err, io.EOF), errors.Is(err, os.ErrNotExist):
+			// 目标已不存在，重新出现时由下一轮列举重新启动
+			return
+		default:
+			logrus.WithError(err).Warnf("网站 %s 的日志 %s 流式读取失败，%s 后重试", websiteID, target.Key, streamRetryInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(streamRetryInterval):
+			}
+		}
+	}
+}
+
+// prepareStreamState 返回目标开始跟随时的状态：路径上已是新文件时先处理旧文件的状态，
+// 新出现的目标若由已跟随的文件轮转而来则继承其读取位置
+func (p *LogParser) prepareStreamState(
+	ctx context.Context, websiteID string, src source.LogSource, target source.TargetRef) (TargetState, bool) {
+	targetKey := buildTargetStateKey(target.SourceID, target.Key)
+	state, ok := p.getTargetState(websiteID, targetKey)
+
+	meta, err := src.Stat(ctx, target)
+	if err != nil || meta.Inode == 0 {
+		return state, ok
+	}
+	head := targetHeadFingerprint(ctx, src, target, meta.Codec)
+	if ok && (meta.Size < state.LastOffset || !state.sameFile(meta.Device, meta.Inode, head)) {
+		logrus.Infof("检测到网站 %s 的日志文件 %s 已被轮转，从头开始读取", websiteID, target.Key)
+		p.retireStreamTarget(ctx, websiteID, src, target, state)
+		state, ok = TargetState{}, false
+	}
+	if !ok {
+		if inherited, found := p.adoptRotatedTargetState(ctx, websiteID, src, target, meta, head); found {
+			logrus.Infof("网站 %s 的日志文件 %s 由已扫描的文件轮转而来，从上次位置继续读取", websiteID, target.Key)
+			p.setTargetState(websiteID, targetKey, inherited)
+			return inherited, true
+		}
+	}
+	return state, ok
+}
+
+// retireStreamTarget 跟随模式下旧文件的内容已由跟随读取，转移或暂存其状态即可（改名后仍有未读内容时会一并读取）
+func (p *LogParser) retireStreamTarget(
+	ctx context.Context, websiteID string, src source.LogSource, target source.TargetRef, state TargetState) {
+	result := EmptyParserResult("", websiteID)
+	p.retireTargetState(ctx, websiteID, src, target, state, &result)
+}
+
+// consumeStream 从 start 开始读取目标，按批次大小或定时刷新写入，返回读取结束的原因
+func (p *LogParser) consumeStream(
+	ctx context.Context,
+	websiteID string,
+	src source.LogSource,
+	target source.TargetRef,
+	start int64,
+	window parseWindow,
+) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	reader, err := src.OpenStream(streamCtx, target, start)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	lines := make(chan streamLine, p.parseBatchSize)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		buffered := bufio.NewReaderSize(reader, streamReadBufferSize)
+		for {
+			raw, err := buffered.ReadString('\n')
+			// 不完整的行等到写完整后再读取；轮转时旧文件末尾的行不会再有后续内容
+			if raw != "" && (err == nil || errors.Is(err, source.ErrStreamRotated)) {
+				select {
+				case lines <- streamLine{text: strings.TrimRight(raw, "\r\n"), size: int64(len(raw))}:
+				case <-streamCtx.Done():
+					readErr <- streamCtx.Err()
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		for range lines {
+		}
+		reader.Close()
+	}()
+
+	var envelope *dockerEnvelope
+	if parser, err := p.getLineParserForSource(websiteID, target.SourceID); err == nil {
+		envelope = parser.envelope
+	}
+
+	var (
+		pending      []string
+		pendingSizes []int64
+		offset       = start
+	)
+	flush := func() error {
+		count := len(pending)
+		if envelope != nil {
+			// docker 长行的分段等最后一段读到后一起入库
+			count -= envelope.partialTail(pending)
+		}
+		if count == 0 {
+			return nil
+		}
+		var size int64
+		for _, lineSize := range pendingSizes[:count] {
+			size += lineSize
+		}
+		if err := p.ingestStreamLines(ctx, websiteID, src, target, pending[:count], offset, offset+size, window); err != nil {
+			return err
+		}
+		offset += size
+		pending = append(pending[:0], pending[count:]...)
+		pendingSizes = append(pendingSizes[:0], pendingSizes[count:]...)
+		return nil
+	}
+
+	ticker := time.NewTicker(streamFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+				return <-readErr
+			}
+			pending = append(pending, line.text)
+			pendingSizes = append(pendingSizes, line.size)
+			if len(pending) >= p.parseBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			flush()
+			return ctx.Err()
+		}
+	}
+}
+
+// ingestStreamLines 解析入库一批跟随读取到的行，并把目标偏移推进到 endOffset
+func (p *LogParser) ingestStreamLines(
+	ctx context.Context,
+	websiteID string,
+	src source.LogSource,
+	target source.TargetRef,
+	lines []string,
+	startOffset, endOffset int64,
+	window parseWindow,
+) error {
+	unlock := p.lockScan(websiteID, target.SourceID)
+	defer unlock()
+
+	targetKey := buildTargetStateKey(target.SourceID, target.Key)
+	state, ok := p.getTargetState(websiteID, targetKey)
+	if !ok {
+		if startOffset > 0 {
+			return errStreamReset
+		}
+		state.RecentCutoffTs = time.Now().AddDate(0, 0, -recentLogWindowDays).Unix()
+	}
+
+	parser, err := p.getLineParserForSource(websiteID, target.SourceID)
+	if err != nil {
+		return err
+	}
+	parser.RestoreFields(state.Fields)
+
+	result := EmptyParserResult("", websiteID)
+	origin := lineOrigin{sourceID: target.SourceID, target: target.Key, offset: startOffset}
+	entries, _, minTs, maxTs := p.parseLogLines(strings.NewReader(strings.Join(lines, "\n")), websiteID, origin, &result, window)
+
+	updateTargetParsedRange(&state, minTs, maxTs)
+	state.LastOffset = endOffset
+	if endOffset > state.LastSize {
+		state.LastSize = endOffset
+	}
+	state.LastModTime = time.Now().Unix()
+	state.BackfillDone = true
+	state.Fields = parser.CurrentFields()
+	p.refreshStreamIdentity(ctx, src, target, &state)
+	p.setTargetState(websiteID, targetKey, state)
+
+	if entries > 0 {
+		p.refreshWebsiteRanges(websiteID)
+	}
+	p.updateState()
+	return nil
+}
+
+// refreshStreamIdentity 在指纹覆盖完整之前随跟随读取更新目标的 inode 与指纹。
+// 路径已指向其他文件（轮转后旧文件仍在读取）时不更新，以免记录成新文件的身份。
+func (p *LogParser) refreshStreamIdentity(ctx context.Context, src source.LogSource, target source.TargetRef, state *TargetState) {
+	if state.Inode != 0 && state.FingerprintLen >= fingerprintSize {
+		return
+	}
+	meta, err := src.Stat(ctx, target)
+	if err != nil || meta.Inode == 0 {
+		return
+	}
+	if state.Inode != 0 && (state.Device != meta.Device || state.Inode != meta.Inode) {
+		return
+	}
+	state.refresh(meta.Device, meta.Inode, meta.Size, targetHeadFingerprint(ctx, src, target, meta.Codec))
+}
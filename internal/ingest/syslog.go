@@ -0,0 +1,165 @@
+package ingest
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogMessage 去掉 syslog 信封后的消息
+type syslogMessage struct {
+	hostname string
+	tag      string
+	body     string
+}
+
+// parseSyslogMessage 解析 RFC 5424 与 RFC 3164 格式的 syslog 消息。
+// 缺少 PRI 的消息整体视为消息体，便于兼容直接转发原始日志行的发送端。
+func parseSyslogMessage(raw string) syslogMessage {
+	raw = strings.TrimRight(raw, "\r\n\x00")
+	rest, ok := stripSyslogPRI(raw)
+	if !ok {
+		return syslogMessage{body: raw}
+	}
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(rest[2:])
+	}
+	return parseRFC3164(rest)
+}
+
+// stripSyslogPRI 去掉 "<PRI>" 前缀，PRI 为 1~3 位数字
+func stripSyslogPRI(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, "<") {
+		return raw, false
+	}
+	end := strings.IndexByte(raw, '>')
+	if end < 2 || end > 4 {
+		return raw, false
+	}
+	if _, err := strconv.Atoi(raw[1:end]); err != nil {
+		return raw, false
+	}
+	return raw[end+1:], true
+}
+
+// parseRFC5424 解析 "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG"
+func parseRFC5424(rest string) syslogMessage {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		idx := strings.IndexByte(rest, ' ')
+		if idx < 0 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+		fields = append(fields, rest[:idx])
+		rest = rest[idx+1:]
+	}
+	for len(fields) < 5 {
+		fields = append(fields, "-")
+	}
+
+	msg := syslogMessage{
+		hostname: syslogNil(fields[1]),
+		tag:      syslogNil(fields[2]),
+	}
+
+	// STRUCTURED-DATA 为 "-" 或若干个 [id key="value"]，value 中的 "]" 以反斜杠转义
+	switch {
+	case strings.HasPrefix(rest, "-"):
+		rest = rest[1:]
+	case strings.HasPrefix(rest, "["):
+		for strings.HasPrefix(rest, "[") {
+			end := structuredDataEnd(rest)
+			if end < 0 {
+				rest = ""
+				break
+			}
+			rest = rest[end+1:]
+		}
+	}
+	rest = strings.TrimPrefix(rest, " ")
+	msg.body = strings.TrimPrefix(rest, "\ufeff")
+	return msg
+}
+
+func structuredDataEnd(value string) int {
+	inQuote := false
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		case ']':
+			if !inQuote {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func syslogNil(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// parseRFC3164 解析 "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG"。
+// nginx 配置 nohostname 时不带 HOSTNAME，部分发送端使用 RFC 3339 时间戳。
+func parseRFC3164(rest string) syslogMessage {
+	if len(rest) >= len(time.Stamp) {
+		if _, err := time.Parse(time.Stamp, rest[:len(time.Stamp)]); err == nil {
+			rest = strings.TrimPrefix(rest[len(time.Stamp):], " ")
+		} else if idx := strings.IndexByte(rest, ' '); idx > 0 {
+			if _, err := time.Parse(time.RFC3339Nano, rest[:idx]); err == nil {
+				rest = rest[idx+1:]
+			}
+		}
+	}
+
+	msg := syslogMessage{}
+	first, remainder := splitSyslogToken(rest)
+	if tag, ok := syslogTag(first); ok {
+		msg.tag = tag
+		msg.body = strings.TrimPrefix(remainder, " ")
+		return msg
+	}
+
+	second, afterSecond := splitSyslogToken(strings.TrimPrefix(remainder, " "))
+	if tag, ok := syslogTag(second); ok {
+		msg.hostname = first
+		msg.tag = tag
+		msg.body = strings.TrimPrefix(afterSecond, " ")
+		return msg
+	}
+
+	// 没有 TAG 时无法可靠区分主机名与消息体，整体作为消息体
+	msg.body = rest
+	return msg
+}
+
+func splitSyslogToken(value string) (string, string) {
+	idx := strings.IndexByte(value, ' ')
+	if idx < 0 {
+		return value, ""
+	}
+	return value[:idx], value[idx:]
+}
+
+// syslogTag 识别 "tag:" 或 "tag[pid]:" 形式的 TAG
+func syslogTag(token string) (string, bool) {
+	if !strings.HasSuffix(token, ":") || len(token) < 2 {
+		return "", false
+	}
+	tag := strings.TrimSuffix(token, ":")
+	if idx := strings.IndexByte(tag, '['); idx > 0 && strings.HasSuffix(tag, "]") {
+		tag = tag[:idx]
+	}
+	if tag == "" || strings.ContainsAny(tag, "\"[]") {
+		return "", false
+	}
+	return tag, true
+}
@@ -0,0 +1,314 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	syslogQueueSize     = 10000
+	syslogFlushInterval = time.Second
+	syslogMaxMessage    = 64 * 1024
+)
+
+// syslogRoute 将 syslog 消息分发到站点来源，tag/hostname 为空表示不过滤
+type syslogRoute struct {
+	websiteID string
+	sourceID  string
+	tag       string
+	hostname  string
+}
+
+func (r syslogRoute) matches(msg syslogMessage) bool {
+	if r.tag != "" && !strings.EqualFold(r.tag, msg.tag) {
+		return false
+	}
+	if r.hostname != "" && !strings.EqualFold(r.hostname, msg.hostname) {
+		return false
+	}
+	return true
+}
+
+type syslogListener struct {
+	network string
+	address string
+	routes  []syslogRoute
+}
+
+type syslogEnvelope struct {
+	routes []syslogRoute
+	msg    syslogMessage
+}
+
+// RunSyslogReceivers 按配置中的 syslog 来源监听 UDP/TCP 端口，
+// 去掉 syslog 信封后按 tag/hostname 分发到站点，批量交给 IngestLines 解析入库
+func (p *LogParser) RunSyslogReceivers(ctx context.Context) {
+	listeners := buildSyslogListeners()
+	if len(listeners) == 0 {
+		return
+	}
+
+	queue := make(chan syslogEnvelope, syslogQueueSize)
+	var wg sync.WaitGroup
+	for _, listener := range listeners {
+		listener := listener
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if listener.network == "udp" {
+				err = serveSyslogUDP(ctx, listener, queue)
+			} else {
+				err = serveSyslogTCP(ctx, listener, queue)
+			}
+			if err != nil {
+				logrus.WithError(err).Errorf("syslog 监听 %s %s 失败", listener.network, listener.address)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(queue)
+	}()
+	p.dispatchSyslog(queue)
+}
+
+// buildSyslogListeners 汇总所有站点的 syslog 来源，同一协议与地址只监听一次
+func buildSyslogListeners() []*syslogListener {
+	var (
+		listeners []*syslogListener
+		index     = make(map[string]*syslogListener)
+	)
+	for _, websiteID := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(websiteID)
+		if !ok {
+			continue
+		}
+		for _, src := range website.Sources {
+			if strings.ToLower(strings.TrimSpace(src.Type)) != "syslog" {
+				continue
+			}
+			address := net.JoinHostPort(strings.TrimSpace(src.Host), strconv.Itoa(src.Port))
+			route := syslogRoute{
+				websiteID: websiteID,
+				sourceID:  strings.TrimSpace(src.ID),
+				tag:       strings.TrimSpace(src.Tag),
+				hostname:  strings.TrimSpace(src.Hostname),
+			}
+			for _, network := range syslogNetworks(src.Protocol) {
+				key := network + "|" + address
+				listener, ok := index[key]
+				if !ok {
+					listener = &syslogListener{network: network, address: address}
+					index[key] = listener
+					listeners = append(listeners, listener)
+				}
+				listener.routes = append(listener.routes, route)
+			}
+		}
+	}
+	return listeners
+}
+
+func syslogNetworks(protocol string) []string {
+	switch strings.ToLower(strings.TrimSpace(protocol)) {
+	case "tcp":
+		return []string{"tcp"}
+	case "both":
+		return []string{"udp", "tcp"}
+	default:
+		return []string{"udp"}
+	}
+}
+
+func serveSyslogUDP(ctx context.Context, listener *syslogListener, queue chan<- syslogEnvelope) error {
+	conn, err := net.ListenPacket("udp", listener.address)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("syslog 接收器已监听 udp %s", listener.address)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, syslogMaxMessage)
+	dropped := 0
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logrus.WithError(err).Warnf("读取 syslog udp %s 失败", listener.address)
+			continue
+		}
+		// 单个数据报可能包含多条以换行分隔的消息
+		for _, raw := range strings.Split(string(buf[:n]), "\n") {
+			if strings.TrimSpace(raw) == "" {
+				continue
+			}
+			envelope := syslogEnvelope{routes: listener.routes, msg: parseSyslogMessage(raw)}
+			select {
+			case queue <- envelope:
+			default:
+				// UDP 本身不保证送达，队列满时丢弃而不是阻塞读取
+				dropped++
+				if dropped == 1 || dropped%1000 == 0 {
+					logrus.Warnf("syslog 队列已满，已丢弃 %d 条 udp 消息", dropped)
+				}
+			}
+		}
+	}
+}
+
+func serveSyslogTCP(ctx context.Context, listener *syslogListener, queue chan<- syslogEnvelope) error {
+	ln, err := net.Listen("tcp", listener.address)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("syslog 接收器已监听 tcp %s", listener.address)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logrus.WithError(err).Warnf("接受 syslog tcp 连接失败: %s", listener.address)
+			continue
+		}
+		go handleSyslogConn(ctx, conn, listener.routes, queue)
+	}
+}
+
+// handleSyslogConn 读取 TCP 连接上的消息，支持 RFC 6587 的长度前缀与换行分隔两种分帧方式
+func handleSyslogConn(ctx context.Context, conn net.Conn, routes []syslogRoute, queue chan<- syslogEnvelope) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, syslogMaxMessage)
+	for {
+		raw, err := readSyslogFrame(reader)
+		if raw != "" {
+			select {
+			case queue <- syslogEnvelope{routes: routes, msg: parseSyslogMessage(raw)}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+				logrus.WithError(err).Warnf("读取 syslog tcp 连接 %s 失败", conn.RemoteAddr())
+			}
+			return
+		}
+	}
+}
+
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	if _, err := reader.Peek(1); err != nil {
+		return "", err
+	}
+	if octetCounted(reader) {
+		lengthText, err := reader.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(lengthText))
+		if err != nil || length <= 0 || length > syslogMaxMessage {
+			return "", errors.New("syslog 消息长度无效: " + strings.TrimSpace(lengthText))
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	}
+
+	line, err := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n\x00"), err
+}
+
+// octetCounted 判断下一帧是否为 RFC 6587 的长度前缀：以非 0 数字开头、数字后紧跟一个空格。
+// 不带 PRI 的访问日志行（如 "1.2.3.4 - - ..."）同样以数字开头，按换行分帧。
+func octetCounted(reader *bufio.Reader) bool {
+	maxDigits := len(strconv.Itoa(syslogMaxMessage))
+	for i := 1; i <= maxDigits+1; i++ {
+		peeked, err := reader.Peek(i)
+		if err != nil {
+			return false
+		}
+		c := peeked[i-1]
+		switch {
+		case c == ' ':
+			return i > 1
+		case c < '0' || c > '9' || i == 1 && c == '0':
+			return false
+		}
+	}
+	return false
+}
+
+// dispatchSyslog 按路由缓存消息体，达到批次大小或定时刷新时写入
+func (p *LogParser) dispatchSyslog(queue <-chan syslogEnvelope) {
+	pending := make(map[syslogRoute][]string)
+	flush := func(route syslogRoute) {
+		lines := pending[route]
+		if len(lines) == 0 {
+			return
+		}
+		delete(pending, route)
+		if _, _, err := p.IngestLines(route.websiteID, route.sourceID, lines); err != nil {
+			logrus.WithError(err).Errorf("写入网站 %s 的 syslog 日志失败", route.websiteID)
+		}
+	}
+
+	ticker := time.NewTicker(syslogFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case envelope, ok := <-queue:
+			if !ok {
+				for route := range pending {
+					flush(route)
+				}
+				return
+			}
+			if strings.TrimSpace(envelope.msg.body) == "" {
+				continue
+			}
+			for _, route := range envelope.routes {
+				if !route.matches(envelope.msg) {
+					continue
+				}
+				pending[route] = append(pending[route], envelope.msg.body)
+				if len(pending[route]) >= p.parseBatchSize {
+					flush(route)
+				}
+			}
+		case <-ticker.C:
+			for route := range pending {
+				flush(route)
+			}
+		}
+	}
+}
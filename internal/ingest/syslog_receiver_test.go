@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadSyslogFrame(t *testing.T) {
+	tooLong := strconv.Itoa(syslogMaxMessage+1) + " <34>1 hello"
+
+	tests := []struct {
+		name       string
+		input      string
+		wantFrames []string
+		wantErr    bool
+	}{
+		{
+			name:       "octet counted",
+			input:      "11 <34>1 hello",
+			wantFrames: []string{"<34>1 hello"},
+		},
+		{
+			name:       "octet counted sequence",
+			input:      "11 <34>1 hello11 <34>1 world",
+			wantFrames: []string{"<34>1 hello", "<34>1 world"},
+		},
+		{
+			name:       "octet counted with trailing newline in frame",
+			input:      "12 <34>1 hello\n5 abcde",
+			wantFrames: []string{"<34>1 hello", "abcde"},
+		},
+		{
+			name:       "access log line starting with digits",
+			input:      "1.2.3.4 - - [18/Oct/2026:10:00:00 +0000] \"GET / HTTP/1.1\" 200 0\n",
+			wantFrames: []string{"1.2.3.4 - - [18/Oct/2026:10:00:00 +0000] \"GET / HTTP/1.1\" 200 0"},
+		},
+		{
+			name:       "newline delimited",
+			input:      "<34>1 hello\r\n<34>1 world\n",
+			wantFrames: []string{"<34>1 hello", "<34>1 world"},
+		},
+		{
+			name:       "leading zero is not a length",
+			input:      "0 hello\n",
+			wantFrames: []string{"0 hello"},
+		},
+		{
+			name:       "digits without space at eof",
+			input:      "123",
+			wantFrames: []string{"123"},
+		},
+		{
+			name:       "mixed framing",
+			input:      "11 <34>1 hello<34>1 world\n",
+			wantFrames: []string{"<34>1 hello", "<34>1 world"},
+		},
+		{
+			name:    "length beyond limit",
+			input:   tooLong,
+			wantErr: true,
+		},
+		{
+			name:    "truncated octet frame",
+			input:   "20 <34>1 hello",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(tt.input), syslogMaxMessage)
+			var frames []string
+			for {
+				frame, err := readSyslogFrame(reader)
+				if frame != "" {
+					frames = append(frames, frame)
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if !tt.wantErr {
+						t.Fatalf("readSyslogFrame 返回错误: %v", err)
+					}
+					return
+				}
+			}
+			if tt.wantErr {
+				t.Fatalf("期望返回错误，实际得到 %q", frames)
+			}
+			if strings.Join(frames, "|") != strings.Join(tt.wantFrames, "|") || len(frames) != len(tt.wantFrames) {
+				t.Fatalf("frames = %q, want %q", frames, tt.wantFrames)
+			}
+		})
+	}
+}
@@ -28,7 +28,8 @@ func accessKeyMiddleware() gin.HandlerFunc {
 	}
 
 	return func(c *gin.Context) {
-		if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		// /v1/logs 为 OTLP/HTTP 的默认路径，同样需要访问密钥
+		if !strings.HasPrefix(c.Request.URL.Path, "/api/") && c.Request.URL.Path != "/v1/logs" {
 			c.Next()
 			return
 		}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// latencyBinBounds 延迟直方图的分桶上界（毫秒），第 i 个桶覆盖 [bounds[i-1], bounds[i])，
+// 最后一个桶覆盖 >= 最大上界的请求。与 PostgreSQL width_bucket(value, thresholds) 的编号一致。
+var latencyBinBounds = []float64{
+	1, 2, 5, 10, 20, 50, 75, 100, 150, 200, 300, 500, 750,
+	1000, 1500, 2000, 3000, 5000, 10000, 30000, 60000,
+}
+
+// LatencyBinBounds 返回延迟直方图的分桶上界（毫秒）
+func LatencyBinBounds() []float64 {
+	return append([]float64(nil), latencyBinBounds...)
+}
+
+// latencyBin 返回延迟值所属的直方图桶编号
+func latencyBin(ms float64) int {
+	return sort.Search(len(latencyBinBounds), func(i int) bool {
+		return latencyBinBounds[i] > ms
+	})
+}
+
+// latencyBoundsArraySQL 生成 width_bucket 使用的阈值数组字面量
+func latencyBoundsArraySQL() string {
+	parts := make([]string, 0, len(latencyBinBounds))
+	for _, bound := range latencyBinBounds {
+		parts = append(parts, strconv.FormatFloat(bound, 'f', -1, 64))
+	}
+	return "ARRAY[" + strings.Join(parts, ", ") + "]::double precision[]"
+}
+
+// latencyValue 返回用于延迟统计的耗时：优先使用 request_time，缺失时回退到 upstream_response_time
+func latencyValue(log NginxLogRecord) (float64, bool) {
+	if log.RequestTime != nil {
+		return *log.RequestTime, true
+	}
+	if log.UpstreamTime != nil {
+		return *log.UpstreamTime, true
+	}
+	return 0, false
+}
+
+func nullableFloat(value *float64) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+// ensureLatencyColumns 为已存在的日志表与小时聚合表补齐延迟相关字段
+func ensureLatencyColumns(execer sqlExecer, websiteID string) error {
+	stmts := []string{
+		fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN IF NOT EXISTS request_time REAL`, websiteID),
+		fmt.Sprintf(`ALTER TABLE "%s_nginx_logs" ADD COLUMN IF NOT EXISTS upstream_time REAL`, websiteID),
+	}
+	for _, stmt := range stmts {
+		if _, err := execer.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addLatency(counts *aggCounts, log NginxLogRecord) {
+	if counts == nil {
+		return
+	}
+	if value, ok := latencyValue(log); ok {
+		counts.latencyCount++
+		counts.latencySum += value
+		if value > counts.latencyMax {
+			counts.latencyMax = value
+		}
+	}
+	if log.UpstreamTime != nil {
+		counts.upstreamCount++
+		counts.upstreamSum += *log.UpstreamTime
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/likaia/nginxpulse/internal/sqlutil"
+)
+
+const (
+	// RejectRetentionLimit 每个站点隔离表最多保留的解析失败行数，超出后删除最旧的记录
+	RejectRetentionLimit = 10000
+	// rejectLineMaxBytes 单行最多保存的字节数，避免异常超长行撑大隔离表
+	rejectLineMaxBytes = 8192
+)
+
+// RejectedLine 解析失败被隔离的日志行
+type RejectedLine struct {
+	ID        int64  `json:"id"`
+	CreatedAt int64  `json:"created_at"`
+	SourceID  string `json:"source_id"`
+	Target    string `json:"target"` // 文件路径或远端目标
+	Offset    int64  `json:"offset"` // 行在目标中的字节偏移（压缩文件为解压后偏移），-1 表示无法定位
+	Reason    string `json:"reason"`
+	Line      string `json:"line"`
+}
+
+func createRejectTable(execer sqlExecer, websiteID string) error {
+	stmts := []string{
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_ingest_rejects" (
+                id BIGSERIAL PRIMARY KEY,
+                created_at BIGINT NOT NULL,
+                source_id TEXT NOT NULL DEFAULT '',
+                target TEXT NOT NULL DEFAULT '',
+                line_offset BIGINT NOT NULL DEFAULT -1,
+                reason TEXT NOT NULL,
+                line TEXT NOT NULL
+            )`, websiteID,
+		),
+		fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS idx_%s_ingest_rejects_source ON "%s_ingest_rejects"(source_id, id)`,
+			websiteID, websiteID,
+		),
+	}
+	for _, stmt := range stmts {
+		if _, err := execer.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertRejectsForWebsite 写入解析失败的日志行，并裁剪隔离表至 RejectRetentionLimit 行
+func (r *Repository) InsertRejectsForWebsite(websiteID string, rejects []RejectedLine) (err error) {
+	if len(rejects) == 0 {
+		return nil
+	}
+	table := fmt.Sprintf("%s_ingest_rejects", websiteID)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (created_at, source_id, target, line_offset, reason, line) VALUES (?, ?, ?, ?, ?, ?)`,
+		table,
+	)))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, reject := range rejects {
+		createdAt := reject.CreatedAt
+		if createdAt == 0 {
+			createdAt = now
+		}
+		if _, err = stmt.Exec(
+			createdAt,
+			sanitizeUTF8(reject.SourceID),
+			sanitizeUTF8(reject.Target),
+			reject.Offset,
+			sanitizeRejectText(reject.Reason),
+			sanitizeRejectText(truncateRejectLine(reject.Line)),
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%[1]s"
+         WHERE id <= (SELECT id FROM "%[1]s" ORDER BY id DESC OFFSET ? LIMIT 1)`,
+		table,
+	)), RejectRetentionLimit); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListRejectsForWebsite 按时间倒序分页查询隔离的日志行，sourceID 为空时返回全部来源
+func (r *Repository) ListRejectsForWebsite(
+	websiteID, sourceID string, page, pageSize int) ([]RejectedLine, int, error) {
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 100
+	}
+	table := fmt.Sprintf("%s_ingest_rejects", websiteID)
+	where, args := rejectFilter(sourceID)
+
+	var total int
+	if err := r.db.QueryRow(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT COUNT(*) FROM "%s"%s`, table, where,
+	)), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]RejectedLine, 0)
+	if total == 0 {
+		return results, 0, nil
+	}
+
+	queryArgs := append(args, pageSize, (page-1)*pageSize)
+	rows, err := r.db.Query(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`SELECT id, created_at, source_id, target, line_offset, reason, line
+         FROM "%s"%s
+         ORDER BY id DESC
+         LIMIT ? OFFSET ?`, table, where,
+	)), queryArgs...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item RejectedLine
+		if err := rows.Scan(
+			&item.ID, &item.CreatedAt, &item.SourceID, &item.Target,
+			&item.Offset, &item.Reason, &item.Line,
+		); err != nil {
+			return nil, 0, err
+		}
+		results = append(results, item)
+	}
+	return results, total, rows.Err()
+}
+
+// ClearRejectsForWebsite 清空隔离的日志行，sourceID 为空时清空全部来源，返回删除的行数
+func (r *Repository) ClearRejectsForWebsite(websiteID, sourceID string) (int64, error) {
+	where, args := rejectFilter(sourceID)
+	result, err := r.db.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`DELETE FROM "%s_ingest_rejects"%s`, websiteID, where,
+	)), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func rejectFilter(sourceID string) (string, []any) {
+	sourceID = strings.TrimSpace(sourceID)
+	if sourceID == "" {
+		return "", nil
+	}
+	return " WHERE source_id = ?", []any{sourceID}
+}
+
+// truncateRejectLine 按字节截断超长行，保证截断位置不破坏 UTF-8 字符
+func truncateRejectLine(line string) string {
+	if len(line) <= rejectLineMaxBytes {
+		return line
+	}
+	cut := rejectLineMaxBytes
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut]
+}
+
+// sanitizeRejectText PostgreSQL 的 TEXT 不允许 NUL 字符，二进制垃圾行需要替换
+func sanitizeRejectText(s string) string {
+	return strings.ReplaceAll(sanitizeUTF8(s), "\x00", "?")
+}
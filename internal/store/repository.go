@@ -14,26 +14,13 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest/parse"
 	"github.com/likaia/nginxpulse/internal/sqlutil"
 	"github.com/sirupsen/logrus"
 )
 
-type NginxLogRecord struct {
-	ID               int64     `json:"id"`
-	IP               string    `json:"ip"`
-	PageviewFlag     int       `json:"pageview_flag"`
-	Timestamp        time.Time `json:"timestamp"`
-	Method           string    `json:"method"`
-	Url              string    `json:"url"`
-	Status           int       `json:"status"`
-	BytesSent        int       `json:"bytes_sent"`
-	Referer          string    `json:"referer"`
-	UserBrowser      string    `json:"user_browser"`
-	UserOs           string    `json:"user_os"`
-	UserDevice       string    `json:"user_device"`
-	DomesticLocation string    `json:"domestic_location"`
-	GlobalLocation   string    `json:"global_location"`
-}
+// NginxLogRecord 访问日志记录，字段定义见 parse.Record，agent 解析结果可直接入库
+type NginxLogRecord = parse.Record
 
 func sanitizeUTF8(s string) string {
 	if s == "" || utf8.ValidString(s) {
@@ -649,8 +636,9 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 	stmtNginx, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(`
         INSERT INTO "%s" (
         ip_id, pageview_flag, timestamp, method, url_id, 
-        status_code, bytes_sent, referer_id, ua_id, location_id)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        status_code, bytes_sent, referer_id, ua_id, location_id,
+        request_time, upstream_time)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `, logTable)))
 	if err != nil {
 		return err
@@ -709,6 +697,7 @@ func (r *Repository) batchInsertLogsForWebsiteOnce(websiteID string, logs []Ngin
 		_, err = stmtNginx.Exec(
 			ipID, log.PageviewFlag, log.Timestamp.Unix(), log.Method, urlID,
 			log.Status, log.BytesSent, refererID, uaID, locationID,
+			nullableFloat(log.RequestTime), nullableFloat(log.UpstreamTime),
 		)
 		if err != nil {
 			return err
@@ -857,6 +846,9 @@ func (r *Repository) ClearLogsForWebsite(websiteID string) error {
 	if err := r.clearSessionAggTablesForWebsite(websiteID); err != nil {
 		return fmt.Errorf("清空网站会话聚合表失败: %w", err)
 	}
+	if _, err := r.ClearRejectsForWebsite(websiteID, ""); err != nil {
+		return fmt.Errorf("清空网站解析失败隔离表失败: %w", err)
+	}
 	return nil
 }
 
@@ -1138,10 +1130,11 @@ type dimCaches struct {
 }
 
 type aggStatements struct {
-	upsertHourly   *sql.Stmt
-	upsertDaily    *sql.Stmt
-	insertHourlyIP *sql.Stmt
-	insertDailyIP  *sql.Stmt
+	upsertHourly        *sql.Stmt
+	upsertDaily         *sql.Stmt
+	insertHourlyIP      *sql.Stmt
+	insertDailyIP       *sql.Stmt
+	upsertHourlyLatency *sql.Stmt
 }
 
 type sessionStatements struct {
@@ -1161,13 +1154,21 @@ type aggCounts struct {
 	s4xx    int64
 	s5xx    int64
 	other   int64
+
+	// 延迟统计仅写入小时聚合表
+	latencyCount  int64
+	latencySum    float64
+	latencyMax    float64
+	upstreamCount int64
+	upstreamSum   float64
 }
 
 type aggBatch struct {
-	hourly    map[int64]*aggCounts
-	daily     map[string]*aggCounts
-	hourlyIPs map[int64]map[int64]struct{}
-	dailyIPs  map[string]map[int64]struct{}
+	hourly        map[int64]*aggCounts
+	daily         map[string]*aggCounts
+	hourlyIPs     map[int64]map[int64]struct{}
+	dailyIPs      map[string]map[int64]struct{}
+	hourlyLatency map[int64]map[int]int64
 }
 
 type sessionState struct {
@@ -1189,10 +1190,11 @@ func newDimCaches() dimCaches {
 
 func newAggBatch() *aggBatch {
 	return &aggBatch{
-		hourly:    make(map[int64]*aggCounts),
-		daily:     make(map[string]*aggCounts),
-		hourlyIPs: make(map[int64]map[int64]struct{}),
-		dailyIPs:  make(map[string]map[int64]struct{}),
+		hourly:        make(map[int64]*aggCounts),
+		daily:         make(map[string]*aggCounts),
+		hourlyIPs:     make(map[int64]map[int64]struct{}),
+		dailyIPs:      make(map[string]map[int64]struct{}),
+		hourlyLatency: make(map[int64]map[int]int64),
 	}
 }
 
@@ -1224,6 +1226,7 @@ func (a *aggStatements) Close() {
 	closeStmt(a.upsertDaily)
 	closeStmt(a.insertHourlyIP)
 	closeStmt(a.insertDailyIP)
+	closeStmt(a.upsertHourlyLatency)
 }
 
 func (s *sessionStatements) Close() {
@@ -1376,18 +1379,25 @@ func prepareAggStatements(tx *sql.Tx, websiteID string) (*aggStatements, error)
 	dailyTable := fmt.Sprintf("%s_agg_daily", websiteID)
 	hourlyIPTable := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
 	dailyIPTable := fmt.Sprintf("%s_agg_daily_ip", websiteID)
+	hourlyLatencyTable := fmt.Sprintf("%s_agg_hourly_latency", websiteID)
 
 	upsertHourly, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
-         VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`INSERT INTO "%[1]s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other,
+             latency_count, latency_sum, latency_max, upstream_count, upstream_sum)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
          ON CONFLICT(bucket) DO UPDATE SET
-             pv = "%s".pv + excluded.pv,
-             traffic = "%s".traffic + excluded.traffic,
-             s2xx = "%s".s2xx + excluded.s2xx,
-             s3xx = "%s".s3xx + excluded.s3xx,
-             s4xx = "%s".s4xx + excluded.s4xx,
-             s5xx = "%s".s5xx + excluded.s5xx,
-             other = "%s".other + excluded.other`, hourlyTable, hourlyTable, hourlyTable, hourlyTable, hourlyTable, hourlyTable, hourlyTable, hourlyTable,
+             pv = "%[1]s".pv + excluded.pv,
+             traffic = "%[1]s".traffic + excluded.traffic,
+             s2xx = "%[1]s".s2xx + excluded.s2xx,
+             s3xx = "%[1]s".s3xx + excluded.s3xx,
+             s4xx = "%[1]s".s4xx + excluded.s4xx,
+             s5xx = "%[1]s".s5xx + excluded.s5xx,
+             other = "%[1]s".other + excluded.other,
+             latency_count = "%[1]s".latency_count + excluded.latency_count,
+             latency_sum = "%[1]s".latency_sum + excluded.latency_sum,
+             latency_max = GREATEST("%[1]s".latency_max, excluded.latency_max),
+             upstream_count = "%[1]s".upstream_count + excluded.upstream_count,
+             upstream_sum = "%[1]s".upstream_sum + excluded.upstream_sum`, hourlyTable,
 	)))
 	if err != nil {
 		return nil, err
@@ -1429,11 +1439,24 @@ func prepareAggStatements(tx *sql.Tx, websiteID string) (*aggStatements, error)
 		return nil, err
 	}
 
+	upsertHourlyLatency, err := tx.Prepare(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%[1]s" (bucket, bin, hits) VALUES (?, ?, ?)
+         ON CONFLICT(bucket, bin) DO UPDATE SET hits = "%[1]s".hits + excluded.hits`, hourlyLatencyTable,
+	)))
+	if err != nil {
+		insertDailyIP.Close()
+		insertHourlyIP.Close()
+		upsertDaily.Close()
+		upsertHourly.Close()
+		return nil, err
+	}
+
 	return &aggStatements{
-		upsertHourly:   upsertHourly,
-		upsertDaily:    upsertDaily,
-		insertHourlyIP: insertHourlyIP,
-		insertDailyIP:  insertDailyIP,
+		upsertHourly:        upsertHourly,
+		upsertDaily:         upsertDaily,
+		insertHourlyIP:      insertHourlyIP,
+		insertDailyIP:       insertDailyIP,
+		upsertHourlyLatency: upsertHourlyLatency,
 	}, nil
 }
 
@@ -1562,12 +1585,38 @@ func applyAggUpdates(aggs *aggStatements, batch *aggBatch) error {
 				counts.s4xx,
 				counts.s5xx,
 				counts.other,
+				counts.latencyCount,
+				counts.latencySum,
+				counts.latencyMax,
+				counts.upstreamCount,
+				counts.upstreamSum,
 			); err != nil {
 				return err
 			}
 		}
 	}
 
+	if len(batch.hourlyLatency) > 0 {
+		buckets := make([]int64, 0, len(batch.hourlyLatency))
+		for bucket := range batch.hourlyLatency {
+			buckets = append(buckets, bucket)
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+		for _, bucket := range buckets {
+			hist := batch.hourlyLatency[bucket]
+			bins := make([]int, 0, len(hist))
+			for bin := range hist {
+				bins = append(bins, bin)
+			}
+			sort.Ints(bins)
+			for _, bin := range bins {
+				if _, err := aggs.upsertHourlyLatency.Exec(bucket, bin, hist[bin]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	if len(batch.daily) > 0 {
 		days := make([]string, 0, len(batch.daily))
 		for day := range batch.daily {
@@ -1804,6 +1853,13 @@ func (b *aggBatch) add(log NginxLogRecord, ipID int64) {
 
 	addCounts(hourCounts, log)
 	addCounts(dayCounts, log)
+	addLatency(hourCounts, log)
+	if value, ok := latencyValue(log); ok {
+		if b.hourlyLatency[hour] == nil {
+			b.hourlyLatency[hour] = make(map[int]int64)
+		}
+		b.hourlyLatency[hour][latencyBin(value)]++
+	}
 
 	if log.PageviewFlag == 1 {
 		if b.hourlyIPs[hour] == nil {
@@ -2036,6 +2092,9 @@ func (r *Repository) ensureWebsiteSchema(websiteID string) error {
 	if err != nil {
 		return err
 	}
+	if err := createRejectTable(r.db, websiteID); err != nil {
+		return err
+	}
 
 	if !exists {
 		if err := createDimTables(r.db, websiteID); err != nil {
@@ -2080,6 +2139,10 @@ func (r *Repository) ensureWebsiteSchema(websiteID string) error {
 		return r.migrateLegacyLogs(websiteID)
 	}
 
+	if err := ensureLatencyColumns(r.db, websiteID); err != nil {
+		return err
+	}
+
 	if err := createDimTables(r.db, websiteID); err != nil {
 		return err
 	}
@@ -2354,6 +2417,8 @@ func createLogTable(execer sqlExecer, tableName string) error {
             referer_id BIGINT NOT NULL,
             ua_id BIGINT NOT NULL,
             location_id BIGINT NOT NULL,
+            request_time REAL,
+            upstream_time REAL,
             PRIMARY KEY (id, timestamp)
         ) PARTITION BY RANGE (timestamp)`, tableName,
 	)
@@ -2404,7 +2469,25 @@ func createAggTables(execer sqlExecer, websiteID string) error {
                 s3xx BIGINT NOT NULL DEFAULT 0,
                 s4xx BIGINT NOT NULL DEFAULT 0,
                 s5xx BIGINT NOT NULL DEFAULT 0,
-                other BIGINT NOT NULL DEFAULT 0
+                other BIGINT NOT NULL DEFAULT 0,
+                latency_count BIGINT NOT NULL DEFAULT 0,
+                latency_sum DOUBLE PRECISION NOT NULL DEFAULT 0,
+                latency_max REAL NOT NULL DEFAULT 0,
+                upstream_count BIGINT NOT NULL DEFAULT 0,
+                upstream_sum DOUBLE PRECISION NOT NULL DEFAULT 0
+            )`, websiteID,
+		),
+		fmt.Sprintf(`ALTER TABLE "%s_agg_hourly" ADD COLUMN IF NOT EXISTS latency_count BIGINT NOT NULL DEFAULT 0`, websiteID),
+		fmt.Sprintf(`ALTER TABLE "%s_agg_hourly" ADD COLUMN IF NOT EXISTS latency_sum DOUBLE PRECISION NOT NULL DEFAULT 0`, websiteID),
+		fmt.Sprintf(`ALTER TABLE "%s_agg_hourly" ADD COLUMN IF NOT EXISTS latency_max REAL NOT NULL DEFAULT 0`, websiteID),
+		fmt.Sprintf(`ALTER TABLE "%s_agg_hourly" ADD COLUMN IF NOT EXISTS upstream_count BIGINT NOT NULL DEFAULT 0`, websiteID),
+		fmt.Sprintf(`ALTER TABLE "%s_agg_hourly" ADD COLUMN IF NOT EXISTS upstream_sum DOUBLE PRECISION NOT NULL DEFAULT 0`, websiteID),
+		fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS "%s_agg_hourly_latency" (
+                bucket BIGINT NOT NULL,
+                bin SMALLINT NOT NULL,
+                hits BIGINT NOT NULL DEFAULT 0,
+                PRIMARY KEY(bucket, bin)
             )`, websiteID,
 		),
 		fmt.Sprintf(
@@ -2614,6 +2697,7 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 	aggHourlyIP := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
 	aggDaily := fmt.Sprintf("%s_agg_daily", websiteID)
 	aggDailyIP := fmt.Sprintf("%s_agg_daily_ip", websiteID)
+	aggHourlyLatency := fmt.Sprintf("%s_agg_hourly_latency", websiteID)
 
 	logrus.WithField("website", websiteID).Info("开始回填聚合数据")
 
@@ -2639,9 +2723,13 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s"`, aggDailyIP)); err != nil {
 		return err
 	}
+	if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s"`, aggHourlyLatency)); err != nil {
+		return err
+	}
 
 	if _, err = tx.Exec(fmt.Sprintf(
-		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
+		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other,
+             latency_count, latency_sum, latency_max, upstream_count, upstream_sum)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
              SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
@@ -2650,7 +2738,12 @@ func (r *Repository) backfillAggregates(websiteID string) error {
              SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
              SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
              SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other,
+             COUNT(COALESCE(request_time, upstream_time)) AS latency_count,
+             COALESCE(SUM(COALESCE(request_time, upstream_time)), 0) AS latency_sum,
+             COALESCE(MAX(COALESCE(request_time, upstream_time)), 0) AS latency_max,
+             COUNT(upstream_time) AS upstream_count,
+             COALESCE(SUM(upstream_time), 0) AS upstream_sum
          FROM "%s"
          GROUP BY bucket`, aggHourly, logTable,
 	)); err != nil {
@@ -2670,6 +2763,19 @@ func (r *Repository) backfillAggregates(websiteID string) error {
 		return err
 	}
 
+	if _, err = tx.Exec(fmt.Sprintf(
+		`INSERT INTO "%s" (bucket, bin, hits)
+         SELECT
+             (timestamp / 3600) * 3600 AS bucket,
+             width_bucket(COALESCE(request_time, upstream_time)::double precision, %s) AS bin,
+             COUNT(*) AS hits
+         FROM "%s"
+         WHERE COALESCE(request_time, upstream_time) IS NOT NULL
+         GROUP BY bucket, bin`, aggHourlyLatency, latencyBoundsArraySQL(), logTable,
+	)); err != nil {
+		return err
+	}
+
 	if _, err = tx.Exec(fmt.Sprintf(
 		`INSERT INTO "%s" (day, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
          SELECT
@@ -2901,6 +3007,7 @@ func (r *Repository) cleanupAggregates(websiteID string, cutoff time.Time) error
 	aggHourlyIP := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
 	aggDaily := fmt.Sprintf("%s_agg_daily", websiteID)
 	aggDailyIP := fmt.Sprintf("%s_agg_daily_ip", websiteID)
+	aggHourlyLatency := fmt.Sprintf("%s_agg_hourly_latency", websiteID)
 
 	hasAgg, err := r.tableExists(aggHourly)
 	if err != nil || !hasAgg {
@@ -2922,6 +3029,12 @@ func (r *Repository) cleanupAggregates(websiteID string, cutoff time.Time) error
 	); err != nil {
 		return err
 	}
+	if _, err := r.db.Exec(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE bucket < ?`, aggHourlyLatency)),
+		cutoffHour,
+	); err != nil {
+		return err
+	}
 	if _, err := r.db.Exec(
 		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE day < ?`, aggDaily)),
 		cutoffDay,
@@ -3085,6 +3198,7 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 	logTable := fmt.Sprintf("%s_nginx_logs", websiteID)
 	aggHourly := fmt.Sprintf("%s_agg_hourly", websiteID)
 	aggHourlyIP := fmt.Sprintf("%s_agg_hourly_ip", websiteID)
+	aggHourlyLatency := fmt.Sprintf("%s_agg_hourly_latency", websiteID)
 
 	start := bucket
 	end := bucket + 3600
@@ -3111,9 +3225,16 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 	); err != nil {
 		return err
 	}
+	if _, err = tx.Exec(
+		sqlutil.ReplacePlaceholders(fmt.Sprintf(`DELETE FROM "%s" WHERE bucket = ?`, aggHourlyLatency)),
+		bucket,
+	); err != nil {
+		return err
+	}
 
 	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
-		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other)
+		`INSERT INTO "%s" (bucket, pv, traffic, s2xx, s3xx, s4xx, s5xx, other,
+             latency_count, latency_sum, latency_max, upstream_count, upstream_sum)
          SELECT
              (timestamp / 3600) * 3600 AS bucket,
              SUM(CASE WHEN pageview_flag = 1 THEN 1 ELSE 0 END) AS pv,
@@ -3122,7 +3243,12 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
              SUM(CASE WHEN status_code >= 300 AND status_code < 400 THEN 1 ELSE 0 END) AS s3xx,
              SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END) AS s4xx,
              SUM(CASE WHEN status_code >= 500 AND status_code < 600 THEN 1 ELSE 0 END) AS s5xx,
-             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other
+             SUM(CASE WHEN status_code < 200 OR status_code >= 600 THEN 1 ELSE 0 END) AS other,
+             COUNT(COALESCE(request_time, upstream_time)) AS latency_count,
+             COALESCE(SUM(COALESCE(request_time, upstream_time)), 0) AS latency_sum,
+             COALESCE(MAX(COALESCE(request_time, upstream_time)), 0) AS latency_max,
+             COUNT(upstream_time) AS upstream_count,
+             COALESCE(SUM(upstream_time), 0) AS upstream_sum
          FROM "%s"
          WHERE timestamp >= ? AND timestamp < ?
          GROUP BY bucket`, aggHourly, logTable,
@@ -3143,6 +3269,19 @@ func (r *Repository) rebuildHourlyAggregate(websiteID string, bucket int64) erro
 		return err
 	}
 
+	if _, err = tx.Exec(sqlutil.ReplacePlaceholders(fmt.Sprintf(
+		`INSERT INTO "%s" (bucket, bin, hits)
+         SELECT
+             (timestamp / 3600) * 3600 AS bucket,
+             width_bucket(COALESCE(request_time, upstream_time)::double precision, %s) AS bin,
+             COUNT(*) AS hits
+         FROM "%s"
+         WHERE COALESCE(request_time, upstream_time) IS NOT NULL AND timestamp >= ? AND timestamp < ?
+         GROUP BY bucket, bin`, aggHourlyLatency, latencyBoundsArraySQL(), logTable,
+	)), start, end); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -3218,6 +3357,7 @@ func (r *Repository) clearAggregateTablesForWebsite(websiteID string) error {
 	aggTables := []string{
 		fmt.Sprintf("%s_agg_hourly", websiteID),
 		fmt.Sprintf("%s_agg_hourly_ip", websiteID),
+		fmt.Sprintf("%s_agg_hourly_latency", websiteID),
 		fmt.Sprintf("%s_agg_daily", websiteID),
 		fmt.Sprintf("%s_agg_daily_ip", websiteID),
 	}
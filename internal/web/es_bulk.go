@@ -0,0 +1,160 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// esCompatVersion 返回给 Filebeat / Vector 的 Elasticsearch 版本号，用于选择 bulk 请求格式
+const esCompatVersion = "8.11.0"
+
+// esMessageFields 按顺序查找文档中的日志行字段
+var esMessageFields = []string{"message", "event.original", "log"}
+
+// esBulkOp bulk 请求中的一个操作
+type esBulkOp struct {
+	action string
+	index  string
+	id     string
+	doc    json.RawMessage
+}
+
+// esBulkItemResult bulk 响应中单个操作的结果
+type esBulkItemResult struct {
+	Index  string       `json:"_index"`
+	ID     string       `json:"_id,omitempty"`
+	Status int          `json:"status"`
+	Result string       `json:"result,omitempty"`
+	Error  *esBulkError `json:"error,omitempty"`
+}
+
+type esBulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// parseESBulk 解析 NDJSON 格式的 bulk 请求：每个操作一行元数据，index/create/update 后跟一行文档，delete 没有文档。
+// 元数据中未指定 _index 时使用 URL 中的索引。
+func parseESBulk(body []byte, defaultIndex string) ([]esBulkOp, error) {
+	var ops []esBulkOp
+	lines := bytes.Split(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+		var meta map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &meta); err != nil || len(meta) != 1 {
+			return nil, errors.New("无效的 bulk 操作行")
+		}
+		op := esBulkOp{}
+		for action, value := range meta {
+			op.action, op.index, op.id = action, value.Index, value.ID
+		}
+		if op.index == "" {
+			op.index = defaultIndex
+		}
+		switch op.action {
+		case "index", "create", "update":
+			i++
+			for i < len(lines) && len(bytes.TrimSpace(lines[i])) == 0 {
+				i++
+			}
+			if i >= len(lines) {
+				return nil, errors.New("bulk 操作缺少文档行")
+			}
+			op.doc = bytes.TrimSpace(lines[i])
+		case "delete":
+		default:
+			return nil, errors.New("不支持的 bulk 操作: " + op.action)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// resolveESIndex 将索引名映射到站点与来源：索引名为站点 ID 或名称时不指定来源，
+// 否则从右向左按 "." 切分，前半部分为站点、后半部分为来源 ID，例如 blog.nginx
+func resolveESIndex(index string) (ingestTarget, bool) {
+	if websiteID, ok := resolveIngestWebsite(index); ok {
+		return ingestTarget{websiteID: websiteID}, true
+	}
+	for i := strings.LastIndex(index, "."); i > 0; i = strings.LastIndex(index[:i], ".") {
+		if websiteID, ok := resolveIngestWebsite(index[:i]); ok {
+			return ingestTarget{websiteID: websiteID, sourceID: index[i+1:]}, true
+		}
+	}
+	return ingestTarget{}, false
+}
+
+// esDocMessage 取出文档中的日志行，支持 event.original 这类点号字段名与嵌套对象两种写法
+func esDocMessage(doc json.RawMessage) (string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return "", false
+	}
+	for _, name := range esMessageFields {
+		if value, ok := esDocField(fields, name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func esDocField(fields map[string]json.RawMessage, name string) (string, bool) {
+	if raw, ok := fields[name]; ok {
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil && strings.TrimSpace(value) != "" {
+			return value, true
+		}
+	}
+	parent, child, ok := strings.Cut(name, ".")
+	if !ok {
+		return "", false
+	}
+	var nested map[string]json.RawMessage
+	if err := json.Unmarshal(fields[parent], &nested); err != nil {
+		return "", false
+	}
+	return esDocField(nested, child)
+}
+
+// buildESBatch 汇总 bulk 操作中的日志行并生成每个操作的结果。
+// 无法写入的操作（索引无法映射到站点、缺少日志字段、update/delete）单独返回 4xx，不影响其他操作。
+func buildESBatch(ops []esBulkOp, defaultSourceID string) (*ingestBatch, []map[string]esBulkItemResult) {
+	batch := newIngestBatch()
+	items := make([]map[string]esBulkItemResult, 0, len(ops))
+	for _, op := range ops {
+		result := esBulkItemResult{Index: op.index, ID: op.id, Status: http.StatusCreated, Result: "created"}
+		fail := func(status int, errType, reason string) {
+			result.Status, result.Result = status, ""
+			result.Error = &esBulkError{Type: errType, Reason: reason}
+		}
+
+		target, ok := resolveESIndex(op.index)
+		switch {
+		case op.action == "update" || op.action == "delete":
+			fail(http.StatusBadRequest, "illegal_argument_exception", "不支持 "+op.action+" 操作")
+		case !ok:
+			fail(http.StatusNotFound, "index_not_found_exception", "索引无法映射到站点: "+op.index)
+		default:
+			line, found := esDocMessage(op.doc)
+			if !found {
+				fail(http.StatusBadRequest, "mapper_parsing_exception", "文档缺少 message 字段")
+				break
+			}
+			if target.sourceID == "" {
+				target.sourceID = defaultSourceID
+			}
+			batch.add(target, strings.TrimRight(line, "\r\n"))
+		}
+		items = append(items, map[string]esBulkItemResult{op.action: result})
+	}
+	return batch, items
+}
@@ -1,11 +1,13 @@
 package web
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/likaia/nginxpulse/internal/config"
 	"github.com/likaia/nginxpulse/internal/enrich"
 	"github.com/likaia/nginxpulse/internal/ingest"
+	"github.com/likaia/nginxpulse/internal/store"
 	"github.com/likaia/nginxpulse/internal/version"
 	"github.com/sirupsen/logrus"
 )
@@ -70,6 +73,8 @@ func SetupRoutes(
 			"migration_required":                      migrationRequired,
 			"setup_required":                          config.IsSetupMode(),
 			"config_readonly":                         config.ConfigReadOnly(),
+			"parse_failures":                          ingest.GetParseFailureStats(),
+			"agent_sources_silent":                    ingest.GetSilentAgentSources(),
 		})
 	})
 
@@ -97,8 +102,10 @@ func SetupRoutes(
 			return
 		}
 		result := config.ValidateConfig(cfg, config.ValidateOptions{
-			CheckPaths: true,
+			CheckPaths:   true,
+			ExtractsHost: ingest.ParserExtractsHost,
 		})
+		result.Errors = append(result.Errors, ingest.ValidateParseConfigs(cfg)...)
 		c.JSON(http.StatusOK, result)
 	})
 
@@ -119,8 +126,10 @@ func SetupRoutes(
 		}
 
 		result := config.ValidateConfig(cfg, config.ValidateOptions{
-			CheckPaths: true,
+			CheckPaths:   true,
+			ExtractsHost: ingest.ParserExtractsHost,
 		})
+		result.Errors = append(result.Errors, ingest.ValidateParseConfigs(cfg)...)
 		if len(result.Errors) > 0 {
 			c.JSON(http.StatusBadRequest, result)
 			return
@@ -140,6 +149,99 @@ func SetupRoutes(
 		})
 	})
 
+	router.POST("/api/config/import-nginx", func(c *gin.Context) {
+		type importRequest struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		}
+
+		var req importRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+
+		// 只允许读取 system.nginxConfDir 下的配置与 include；
+		// 传入配置内容时 path 仅用于解析相对路径的 include 与 access_log
+		confDir := config.ReadConfig().System.NginxConfDir
+		result, err := config.ImportNginxConfigWithin(confDir, strings.TrimSpace(req.Path), req.Content)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("导入 nginx 配置失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	router.POST("/api/parse/preview", func(c *gin.Context) {
+		type previewRequest struct {
+			Lines     []string            `json:"lines"`
+			Parse     *config.ParseConfig `json:"parse"`
+			WebsiteID string              `json:"website_id"`
+			SourceID  string              `json:"source_id"`
+		}
+
+		var req previewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		if len(req.Lines) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "日志内容为空",
+			})
+			return
+		}
+		if len(req.Lines) > parsePreviewMaxLines {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("样例日志不能超过 %d 行", parsePreviewMaxLines),
+			})
+			return
+		}
+
+		var (
+			website   config.WebsiteConfig
+			sourceCfg *config.SourceConfig
+		)
+		switch {
+		case req.Parse != nil:
+			sourceCfg = &config.SourceConfig{Parse: req.Parse}
+		case strings.TrimSpace(req.WebsiteID) != "":
+			var ok bool
+			website, ok = config.GetWebsiteByID(strings.TrimSpace(req.WebsiteID))
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "站点不存在",
+				})
+				return
+			}
+			sourceID := strings.TrimSpace(req.SourceID)
+			for i := range website.Sources {
+				if sourceID != "" && strings.TrimSpace(website.Sources[i].ID) == sourceID {
+					sourceCfg = &website.Sources[i]
+					break
+				}
+			}
+		default:
+			c.JSON(http.StatusOK, ingest.DetectLogFormat(req.Lines))
+			return
+		}
+
+		result, err := ingest.PreviewParse(req.Lines, website, sourceCfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
 	router.POST("/api/system/restart", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -364,6 +466,8 @@ func SetupRoutes(
 		}
 	})
 
+	// 推送日志行：JSON 请求体 {website_id, source_id, lines}，
+	// 或 NDJSON / 纯文本请求体（站点与来源取自请求参数），逐行读取并分批写入
 	router.POST("/api/ingest/logs", func(c *gin.Context) {
 		if logParser == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{
@@ -371,6 +475,86 @@ func SetupRoutes(
 			})
 			return
 		}
+		release, ok := acquireIngestSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+
+		stream, ndjson := ingestStreamFormat(c.ContentType())
+		limit := int64(ingestMaxBodySize)
+		if stream {
+			limit = ingestMaxStreamSize
+		}
+		body, err := openIngestBody(c, limit)
+		if err != nil {
+			c.JSON(ingestBodyStatus(err), gin.H{
+				"error": fmt.Sprintf("读取请求失败: %v", err),
+			})
+			return
+		}
+		defer body.Close()
+
+		if stream {
+			scanner := newIngestLineScanner(body, ndjson, ingestTarget{
+				websiteID: strings.TrimSpace(c.Query("website_id")),
+				sourceID:  strings.TrimSpace(c.Query("source_id")),
+			})
+			batch, pending := newIngestBatch(), 0
+			total, accepted, deduped := 0, 0, 0
+			flush := func() error {
+				a, d, err := batch.ingest(logParser)
+				accepted += a
+				deduped += d
+				if !batch.empty() {
+					statsFactory.ClearCache()
+				}
+				batch, pending = newIngestBatch(), 0
+				return err
+			}
+			for scanner.next() {
+				batch.add(scanner.target, scanner.line)
+				pending++
+				total++
+				if pending < ingestStreamChunk {
+					continue
+				}
+				if err := flush(); err != nil {
+					// 已写入的行在客户端重试时会被去重
+					logrus.WithError(err).Error("日志推送解析失败")
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"error": fmt.Sprintf("解析失败: %v", err),
+					})
+					return
+				}
+			}
+			if err := scanner.err(); err != nil {
+				c.JSON(ingestBodyStatus(err), gin.H{
+					"error": fmt.Sprintf("读取请求失败: %v", err),
+				})
+				return
+			}
+			if total == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "日志内容为空",
+				})
+				return
+			}
+			if err := flush(); err != nil {
+				logrus.WithError(err).Error("日志推送解析失败")
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": fmt.Sprintf("解析失败: %v", err),
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success":  true,
+				"accepted": accepted,
+				"deduped":  deduped,
+			})
+			return
+		}
+
 		type ingestRequest struct {
 			WebsiteID string   `json:"website_id"`
 			SourceID  string   `json:"source_id"`
@@ -378,7 +562,13 @@ func SetupRoutes(
 		}
 
 		var req ingestRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			if errors.Is(err, errIngestBodyTooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+					"error": fmt.Sprintf("读取请求失败: %v", err),
+				})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "请求参数错误",
 			})
@@ -422,6 +612,416 @@ func SetupRoutes(
 		})
 	})
 
+	// 推送 agent 已解析的记录：records 直接写入，跳过正则解析；lines 为 agent 解析失败的原始行，按服务端规则解析
+	router.POST("/api/ingest/records", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		release, ok := acquireIngestSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+
+		body, err := readIngestBody(c)
+		if err != nil {
+			c.JSON(ingestBodyStatus(err), gin.H{
+				"error": fmt.Sprintf("读取请求失败: %v", err),
+			})
+			return
+		}
+		type recordsRequest struct {
+			WebsiteID string                 `json:"website_id"`
+			SourceID  string                 `json:"source_id"`
+			Records   []store.NginxLogRecord `json:"records"`
+			Lines     []string               `json:"lines"`
+		}
+		var req recordsRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+
+		websiteID := strings.TrimSpace(req.WebsiteID)
+		if websiteID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "缺少站点ID",
+			})
+			return
+		}
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+		if len(req.Records) == 0 && len(req.Lines) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "日志内容为空",
+			})
+			return
+		}
+
+		sourceID := strings.TrimSpace(req.SourceID)
+		accepted, deduped, err := logParser.IngestParsedRecords(websiteID, sourceID, req.Records)
+		if err == nil {
+			var a, d int
+			a, d, err = logParser.IngestLines(websiteID, sourceID, req.Lines)
+			accepted += a
+			deduped += d
+		}
+		if accepted > 0 {
+			statsFactory.ClearCache()
+		}
+		if err != nil {
+			logrus.WithError(err).Error("记录推送写入失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("写入失败: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":  true,
+			"accepted": accepted,
+			"deduped":  deduped,
+		})
+	})
+
+	// Loki push API：Promtail / Vector 的 loki 输出填写 /api/ingest 作为地址
+	router.POST("/api/ingest/loki/api/v1/push", func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		release, ok := acquireIngestSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+		body, err := readIngestBody(c)
+		if err != nil {
+			c.JSON(ingestBodyStatus(err), gin.H{
+				"error": fmt.Sprintf("读取请求失败: %v", err),
+			})
+			return
+		}
+		streams, err := parseLokiPush(c.ContentType(), body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("请求参数错误: %v", err),
+			})
+			return
+		}
+		batch, err := buildLokiBatch(streams, ingestTarget{
+			websiteID: c.Query("website_id"),
+			sourceID:  strings.TrimSpace(c.Query("source_id")),
+		})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if _, _, err := batch.ingest(logParser); err != nil {
+			logrus.WithError(err).Error("Loki 推送解析失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("解析失败: %v", err),
+			})
+			return
+		}
+		if !batch.empty() {
+			statsFactory.ClearCache()
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// Elasticsearch bulk API：Filebeat / Vector 的 elasticsearch 输出填写 /api/ingest/es 作为地址，
+	// 索引名映射到站点（及来源），文档的 message 字段作为日志行
+	esInfo := func(c *gin.Context) {
+		c.Header("X-Elastic-Product", "Elasticsearch")
+		c.JSON(http.StatusOK, gin.H{
+			"name":         "nginxpulse",
+			"cluster_name": "nginxpulse",
+			"version": gin.H{
+				"number":       esCompatVersion,
+				"build_flavor": "default",
+			},
+			"tagline": "You Know, for Search",
+		})
+	}
+	router.GET("/api/ingest/es", esInfo)
+	router.GET("/api/ingest/es/", esInfo)
+	router.GET("/api/ingest/es/_cluster/health", func(c *gin.Context) {
+		c.Header("X-Elastic-Product", "Elasticsearch")
+		c.JSON(http.StatusOK, gin.H{
+			"cluster_name": "nginxpulse",
+			"status":       "green",
+		})
+	})
+	esBulk := func(c *gin.Context) {
+		c.Header("X-Elastic-Product", "Elasticsearch")
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		release, ok := acquireIngestSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+		body, err := readIngestBody(c)
+		if err != nil {
+			c.JSON(ingestBodyStatus(err), gin.H{
+				"error": fmt.Sprintf("读取请求失败: %v", err),
+			})
+			return
+		}
+		ops, err := parseESBulk(body, c.Param("index"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("请求参数错误: %v", err),
+			})
+			return
+		}
+
+		start := time.Now()
+		batch, items := buildESBatch(ops, strings.TrimSpace(c.Query("source_id")))
+		if _, _, err := batch.ingest(logParser); err != nil {
+			// 整个请求返回 5xx，客户端重试时已写入的行会被去重
+			logrus.WithError(err).Error("bulk 推送解析失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("解析失败: %v", err),
+			})
+			return
+		}
+		if !batch.empty() {
+			statsFactory.ClearCache()
+		}
+
+		hasErrors := false
+		for _, item := range items {
+			for _, result := range item {
+				hasErrors = hasErrors || result.Error != nil
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"took":   time.Since(start).Milliseconds(),
+			"errors": hasErrors,
+			"items":  items,
+		})
+	}
+	router.POST("/api/ingest/es/_bulk", esBulk)
+	router.POST("/api/ingest/es/:index/_bulk", esBulk)
+
+	// OTLP/HTTP 日志接收：Collector 的 otlphttp 导出器填写服务地址（或 /api/ingest/otlp）作为 endpoint
+	otlpLogs := func(c *gin.Context) {
+		if logParser == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持日志解析",
+			})
+			return
+		}
+		release, ok := acquireIngestSlot(c)
+		if !ok {
+			return
+		}
+		defer release()
+		body, err := readIngestBody(c)
+		if err != nil {
+			c.JSON(ingestBodyStatus(err), gin.H{
+				"error": fmt.Sprintf("读取请求失败: %v", err),
+			})
+			return
+		}
+		logs, asJSON, err := parseOTLPLogs(c.ContentType(), body)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errOTLPContentType) {
+				status = http.StatusUnsupportedMediaType
+			}
+			c.JSON(status, gin.H{
+				"error": fmt.Sprintf("请求参数错误: %v", err),
+			})
+			return
+		}
+
+		batch, result := buildOTLPBatch(logs, ingestTarget{
+			websiteID: c.Query("website_id"),
+			sourceID:  strings.TrimSpace(c.Query("source_id")),
+		})
+		if _, _, err := batch.ingest(logParser); err != nil {
+			// OTLP 规范中 503 可重试，500 不会被重试
+			logrus.WithError(err).Error("OTLP 日志解析失败")
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": fmt.Sprintf("解析失败: %v", err),
+			})
+			return
+		}
+		if !batch.empty() {
+			statsFactory.ClearCache()
+		}
+
+		contentType := otlpContentTypeProto
+		if asJSON {
+			contentType = otlpContentTypeJSON
+		}
+		c.Data(http.StatusOK, contentType, encodeOTLPResponse(result, asJSON))
+	}
+	router.POST("/v1/logs", otlpLogs)
+	router.POST("/api/ingest/otlp/v1/logs", otlpLogs)
+
+	router.GET("/api/ingest/rejects", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持查询解析失败记录",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(c.Query("id"))
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "100"))
+		if pageSize > rejectMaxPageSize {
+			pageSize = rejectMaxPageSize
+		}
+
+		rejects, total, err := statsFactory.Repo().ListRejectsForWebsite(
+			websiteID, c.Query("source_id"), page, pageSize,
+		)
+		if err != nil {
+			logrus.WithError(err).Error("查询解析失败记录失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("查询失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"rejects": rejects,
+			"total":   total,
+			"limit":   store.RejectRetentionLimit,
+		})
+	})
+
+	router.GET("/api/ingest/rejects/download", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持导出解析失败记录",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(c.Query("id"))
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+
+		filename := fmt.Sprintf("nginxpulse_rejects_%s_%s.csv", websiteID, time.Now().Format("20060102_150405"))
+		c.Header("Content-Type", csvContentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusOK)
+
+		if err := exportRejectsCSV(c.Writer, statsFactory.Repo(), websiteID, c.Query("source_id")); err != nil {
+			logrus.WithError(err).Error("导出解析失败记录失败")
+		}
+	})
+
+	router.DELETE("/api/ingest/rejects", func(c *gin.Context) {
+		if statsFactory == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "初始化模式暂不支持清理解析失败记录",
+			})
+			return
+		}
+		websiteID := strings.TrimSpace(c.Query("id"))
+		if _, ok := config.GetWebsiteByID(websiteID); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "站点不存在",
+			})
+			return
+		}
+		deleted, err := statsFactory.Repo().ClearRejectsForWebsite(websiteID, c.Query("source_id"))
+		if err != nil {
+			logrus.WithError(err).Error("清理解析失败记录失败")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("清理失败: %v", err),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"deleted": deleted,
+		})
+	})
+
+	// agent 启动时注册，之后定期发送心跳上报推送进度
+	router.POST("/api/agents/register", func(c *gin.Context) {
+		var req ingest.AgentRegistration
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		interval, err := ingest.RegisterAgent(req, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		logrus.Infof("agent %s（%s）已注册", req.AgentID, req.Hostname)
+		c.JSON(http.StatusOK, gin.H{
+			"success":            true,
+			"heartbeat_interval": int64(interval / time.Second),
+			"content_encodings":  ingestContentEncodings,
+		})
+	})
+
+	router.POST("/api/agents/heartbeat", func(c *gin.Context) {
+		var req ingest.AgentHeartbeat
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误",
+			})
+			return
+		}
+		if err := ingest.RecordAgentHeartbeat(req, c.ClientIP()); err != nil {
+			// agent 收到 404 后重新注册
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+		})
+	})
+
+	router.GET("/api/agents", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"agents": ingest.GetAgentStatuses(),
+		})
+	})
+
 	// 查询接口
 	router.GET("/api/stats/:type", func(c *gin.Context) {
 		if statsFactory == nil {
@@ -462,6 +1062,10 @@ func SetupRoutes(
 
 }
 
+const (
+	parsePreviewMaxLines = 1000
+)
+
 func bindConfigPayload(c *gin.Context) (*config.Config, error) {
 	payload := struct {
 		Config config.Config `json:"config"`
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/likaia/nginxpulse/internal/config"
+	"github.com/likaia/nginxpulse/internal/ingest"
+)
+
+const (
+	// ingestMaxBodySize 推送接口请求体（解压后）的长度上限
+	ingestMaxBodySize = 64 << 20
+	// ingestRetryAfter 写入名额用完时建议客户端等待的秒数
+	ingestRetryAfter = 1
+)
+
+// ingestContentEncodings openIngestBody 支持的 Content-Encoding，在 agent 注册响应中声明
+var ingestContentEncodings = []string{"gzip", "zstd"}
+
+var (
+	errIngestBodyTooLarge = errors.New("请求体过大")
+	errIngestEncoding     = errors.New("不支持的 Content-Encoding")
+)
+
+// ingestSlots 同时写入的推送请求数上限
+var ingestSlots = make(chan struct{}, max(2, runtime.NumCPU()))
+
+// ingestTarget 日志写入的站点与来源
+type ingestTarget struct {
+	websiteID string
+	sourceID  string
+}
+
+// ingestBatch 按站点与来源汇总一次请求中的日志行与结构化记录，按首次出现的顺序写入
+type ingestBatch struct {
+	targets []ingestTarget
+	lines   map[ingestTarget][]string
+	records map[ingestTarget][]ingest.IngestRecord
+}
+
+func newIngestBatch() *ingestBatch {
+	return &ingestBatch{
+		lines:   make(map[ingestTarget][]string),
+		records: make(map[ingestTarget][]ingest.IngestRecord),
+	}
+}
+
+func (b *ingestBatch) add(target ingestTarget, line string) {
+	b.track(target)
+	b.lines[target] = append(b.lines[target], line)
+}
+
+func (b *ingestBatch) addRecord(target ingestTarget, record ingest.IngestRecord) {
+	b.track(target)
+	b.records[target] = append(b.records[target], record)
+}
+
+func (b *ingestBatch) track(target ingestTarget) {
+	_, hasLines := b.lines[target]
+	_, hasRecords := b.records[target]
+	if !hasLines && !hasRecords {
+		b.targets = append(b.targets, target)
+	}
+}
+
+func (b *ingestBatch) empty() bool {
+	return len(b.targets) == 0
+}
+
+// ingest 逐组交给 IngestLines / IngestRecords，任一组失败即返回，已写入的行在重试时会被去重
+func (b *ingestBatch) ingest(logParser *ingest.LogParser) (int, int, error) {
+	accepted, deduped := 0, 0
+	for _, target := range b.targets {
+		a, d, err := logParser.IngestLines(target.websiteID, target.sourceID, b.lines[target])
+		accepted += a
+		deduped += d
+		if err != nil {
+			return accepted, deduped, err
+		}
+		a, d, err = logParser.IngestRecords(target.websiteID, target.sourceID, b.records[target])
+		accepted += a
+		deduped += d
+		if err != nil {
+			return accepted, deduped, err
+		}
+	}
+	return accepted, deduped, nil
+}
+
+// resolveIngestWebsite 按站点 ID 或站点名称（不区分大小写）查找站点
+func resolveIngestWebsite(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", false
+	}
+	if _, ok := config.GetWebsiteByID(value); ok {
+		return value, true
+	}
+	for _, id := range config.GetAllWebsiteIDs() {
+		website, ok := config.GetWebsiteByID(id)
+		if ok && strings.EqualFold(strings.TrimSpace(website.Name), value) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// ingestBody 解压后的请求体，读取超过上限时返回 errIngestBodyTooLarge
+type ingestBody struct {
+	reader    io.Reader
+	remaining int64 // 上限 + 1，读到第 limit+1 个字节即判定超限
+	close     func()
+}
+
+func (b *ingestBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.reader.Read(p)
+	b.remaining -= int64(n)
+	var maxErr *http.MaxBytesError
+	if b.remaining <= 0 || errors.As(err, &maxErr) {
+		return n, errIngestBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *ingestBody) Close() error {
+	if b.close != nil {
+		b.close()
+	}
+	return nil
+}
+
+// openIngestBody 打开推送接口的请求体，支持 gzip、zstd 的 Content-Encoding，
+// 压缩前与解压后的长度都不能超过 limit
+func openIngestBody(c *gin.Context, limit int64) (io.ReadCloser, error) {
+	var reader io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	body := &ingestBody{remaining: limit + 1}
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))) {
+	case "", "identity":
+	case "gzip":
+		gzReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		body.close = func() { gzReader.Close() }
+		reader = gzReader
+	case "zstd":
+		// 限制窗口大小，避免构造的数据帧占用过多内存
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(ingestMaxBodySize))
+		if err != nil {
+			return nil, err
+		}
+		body.close = decoder.Close
+		reader = decoder
+	default:
+		return nil, errIngestEncoding
+	}
+	body.reader = reader
+	return body, nil
+}
+
+// readIngestBody 读取整个请求体，解压后超过 ingestMaxBodySize 时返回 errIngestBodyTooLarge
+func readIngestBody(c *gin.Context) ([]byte, error) {
+	body, err := openIngestBody(c, ingestMaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// acquireIngestSlot 占用一个写入名额，名额用完时直接返回 429 与 Retry-After，
+// 由客户端稍后重试，避免请求堆积在服务端
+func acquireIngestSlot(c *gin.Context) (func(), bool) {
+	select {
+	case ingestSlots <- struct{}{}:
+		return func() { <-ingestSlots }, true
+	default:
+		c.Header("Retry-After", strconv.Itoa(ingestRetryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "写入队列已满，请稍后重试",
+		})
+		return nil, false
+	}
+}
+
+// ingestBodyStatus 读取请求体失败时返回的状态码
+func ingestBodyStatus(err error) int {
+	switch {
+	case errors.Is(err, errIngestBodyTooLarge), errors.Is(err, errIngestLineTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errIngestEncoding):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
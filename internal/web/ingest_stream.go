@@ -0,0 +1,157 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/likaia/nginxpulse/internal/config"
+)
+
+const (
+	// ingestMaxStreamSize NDJSON / 纯文本请求体（解压后）的长度上限，逐行读取，不整体驻留内存
+	ingestMaxStreamSize = 1 << 30
+	// ingestMaxLineSize 逐行读取时单行的长度上限
+	ingestMaxLineSize = 1 << 20
+	// ingestStreamChunk 逐行读取时每累计多少行写入一次
+	ingestStreamChunk = 1000
+)
+
+var errIngestLineTooLong = errors.New("单行日志过长")
+
+// ingestStreamFormat 按 Content-Type 判断请求体是否逐行读取，ndjson 为 true 表示每行是 JSON
+func ingestStreamFormat(contentType string) (stream bool, ndjson bool) {
+	switch strings.ToLower(contentType) {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true, true
+	case "text/plain":
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// ingestStreamLine NDJSON 中的一行，站点与来源缺省时使用请求参数中的值
+type ingestStreamLine struct {
+	WebsiteID string `json:"website_id"`
+	SourceID  string `json:"source_id"`
+	Line      string `json:"line"`
+}
+
+// ingestLineScanner 逐行读取 NDJSON / 纯文本请求体，跳过空行
+type ingestLineScanner struct {
+	scanner  *bufio.Scanner
+	ndjson   bool
+	fallback ingestTarget
+	websites map[string]bool
+	lineNo   int
+	failure  error
+
+	target ingestTarget
+	line   string
+}
+
+// failedReader 记录读取请求体时的错误
+type failedReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *failedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func newIngestLineScanner(body io.Reader, ndjson bool, fallback ingestTarget) *ingestLineScanner {
+	failed := &failedReader{reader: body}
+	scanner := bufio.NewScanner(failed)
+	scanner.Buffer(make([]byte, 64*1024), ingestMaxLineSize)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		// 读取出错（如超过长度上限）时最后一行可能不完整，不写入
+		if atEOF && failed.err != nil && bytes.IndexByte(data, '\n') < 0 {
+			return 0, nil, failed.err
+		}
+		return bufio.ScanLines(data, atEOF)
+	})
+	return &ingestLineScanner{
+		scanner:  scanner,
+		ndjson:   ndjson,
+		fallback: fallback,
+		websites: make(map[string]bool),
+	}
+}
+
+// next 读取下一行，结束或出错时返回 false，错误由 err 返回
+func (s *ingestLineScanner) next() bool {
+	for s.failure == nil && s.scanner.Scan() {
+		s.lineNo++
+		text := s.scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		target, line := s.fallback, text
+		if s.ndjson {
+			var item ingestStreamLine
+			trimmed := strings.TrimSpace(text)
+			var err error
+			if strings.HasPrefix(trimmed, `"`) {
+				err = json.Unmarshal([]byte(trimmed), &item.Line)
+			} else {
+				err = json.Unmarshal([]byte(trimmed), &item)
+			}
+			if err != nil {
+				s.failure = fmt.Errorf("第 %d 行: %w", s.lineNo, err)
+				return false
+			}
+			if strings.TrimSpace(item.Line) == "" {
+				continue
+			}
+			if websiteID := strings.TrimSpace(item.WebsiteID); websiteID != "" {
+				target.websiteID = websiteID
+			}
+			if sourceID := strings.TrimSpace(item.SourceID); sourceID != "" {
+				target.sourceID = sourceID
+			}
+			line = item.Line
+		}
+		if err := s.checkWebsite(target.websiteID); err != nil {
+			s.failure = fmt.Errorf("第 %d 行: %w", s.lineNo, err)
+			return false
+		}
+		s.target, s.line = target, line
+		return true
+	}
+	return false
+}
+
+func (s *ingestLineScanner) checkWebsite(websiteID string) error {
+	if websiteID == "" {
+		return errors.New("缺少站点ID")
+	}
+	if s.websites[websiteID] {
+		return nil
+	}
+	if _, ok := config.GetWebsiteByID(websiteID); !ok {
+		return fmt.Errorf("站点不存在: %s", websiteID)
+	}
+	s.websites[websiteID] = true
+	return nil
+}
+
+func (s *ingestLineScanner) err() error {
+	if s.failure != nil {
+		return s.failure
+	}
+	err := s.scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("第 %d 行: %w", s.lineNo+1, errIngestLineTooLong)
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Loki 推送时标识站点与来源的标签，website 可填站点 ID 或名称
+var (
+	lokiWebsiteLabels = []string{"website_id", "website"}
+	lokiSourceLabels  = []string{"source_id", "source"}
+)
+
+// lokiStream Loki push 请求中的一个日志流
+type lokiStream struct {
+	labels map[string]string
+	lines  []string
+}
+
+// lokiPushJSON Loki push API 的 JSON 格式：values 的每一项为 [纳秒时间戳, 日志行, 可选的结构化元数据]
+type lokiPushJSON struct {
+	Streams []struct {
+		Stream map[string]string   `json:"stream"`
+		Values [][]json.RawMessage `json:"values"`
+	} `json:"streams"`
+}
+
+// parseLokiPush 按 Content-Type 解析 Loki push 请求：application/json 为 JSON，其余按 snappy 压缩的 protobuf 处理
+func parseLokiPush(contentType string, body []byte) ([]lokiStream, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/json" {
+		return parseLokiJSON(body)
+	}
+
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy 解压失败: %w", err)
+	}
+	if size > ingestMaxBodySize {
+		return nil, errIngestBodyTooLarge
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy 解压失败: %w", err)
+	}
+	return parseLokiProto(data)
+}
+
+func parseLokiJSON(body []byte) ([]lokiStream, error) {
+	var req lokiPushJSON
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	streams := make([]lokiStream, 0, len(req.Streams))
+	for _, item := range req.Streams {
+		stream := lokiStream{labels: item.Stream}
+		for _, value := range item.Values {
+			if len(value) < 2 {
+				return nil, errors.New("values 的每一项至少包含时间戳与日志行")
+			}
+			var line string
+			if err := json.Unmarshal(value[1], &line); err != nil {
+				return nil, err
+			}
+			stream.lines = append(stream.lines, line)
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// parseLokiProto 解析 logproto.PushRequest：
+// PushRequest{streams=1}，Stream{labels=1, entries=2}，Entry{timestamp=1, line=2}。
+// 日志行自带时间，忽略 Entry 中的时间戳与结构化元数据。
+func parseLokiProto(data []byte) ([]lokiStream, error) {
+	var streams []lokiStream
+	err := eachProtoField(data, func(field protoField) error {
+		if field.num != 1 || field.typ != protowire.BytesType {
+			return nil
+		}
+		var (
+			stream lokiStream
+			labels string
+		)
+		err := eachProtoField(field.bytes, func(field protoField) error {
+			if field.typ != protowire.BytesType {
+				return nil
+			}
+			switch field.num {
+			case 1:
+				labels = string(field.bytes)
+			case 2:
+				return eachProtoField(field.bytes, func(field protoField) error {
+					if field.num == 2 && field.typ == protowire.BytesType {
+						stream.lines = append(stream.lines, string(field.bytes))
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if stream.labels, err = parseLokiLabels(labels); err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+		return nil
+	})
+	return streams, err
+}
+
+// parseLokiLabels 解析 Prometheus 格式的标签：{job="nginx", website="blog"}
+func parseLokiLabels(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}")
+	labels := make(map[string]string)
+	for {
+		value = strings.TrimLeft(value, ", ")
+		if value == "" {
+			return labels, nil
+		}
+		name, rest, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的标签: %s", value)
+		}
+		rest = strings.TrimSpace(rest)
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, fmt.Errorf("无效的标签值: %s", rest)
+		}
+		unquoted, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("无效的标签值: %s", quoted)
+		}
+		labels[strings.TrimSpace(name)] = unquoted
+		value = rest[len(quoted):]
+	}
+}
+
+// lokiLabel 按顺序取第一个非空的标签值
+func lokiLabel(labels map[string]string, names []string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(labels[name]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// buildLokiBatch 按标签将日志流映射到站点与来源，标签缺失时使用 defaults（来自 URL 参数）。
+// 与 Loki 一致，任一日志流无法识别站点时整个请求都不写入。
+func buildLokiBatch(streams []lokiStream, defaults ingestTarget) (*ingestBatch, error) {
+	batch := newIngestBatch()
+	for _, stream := range streams {
+		website := lokiLabel(stream.labels, lokiWebsiteLabels)
+		if website == "" {
+			website = defaults.websiteID
+		}
+		if website == "" {
+			return nil, errors.New("日志流缺少 website_id 或 website 标签")
+		}
+		websiteID, ok := resolveIngestWebsite(website)
+		if !ok {
+			return nil, fmt.Errorf("站点不存在: %s", website)
+		}
+		sourceID := lokiLabel(stream.labels, lokiSourceLabels)
+		if sourceID == "" {
+			sourceID = defaults.sourceID
+		}
+		target := ingestTarget{websiteID: websiteID, sourceID: sourceID}
+		for _, line := range stream.lines {
+			line = strings.TrimRight(line, "\r\n")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			batch.add(target, line)
+		}
+	}
+	return batch, nil
+}
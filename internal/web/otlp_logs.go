@@ -0,0 +1,490 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/ingest"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	otlpContentTypeProto = "application/x-protobuf"
+	otlpContentTypeJSON  = "application/json"
+)
+
+// OTLP 资源属性中标识站点与来源的属性名，站点可填站点 ID 或名称，未设置时使用 service.name
+var (
+	otlpWebsiteAttributes = []string{"nginxpulse.website", "service.name"}
+	otlpSourceAttributes  = []string{"nginxpulse.source"}
+)
+
+// OTel HTTP 语义约定中的属性名，同时兼容旧版约定
+var (
+	otlpMethodAttributes  = []string{"http.request.method", "http.method"}
+	otlpStatusAttributes  = []string{"http.response.status_code", "http.status_code"}
+	otlpClientAttributes  = []string{"client.address", "http.client_ip", "network.peer.address"}
+	otlpUAAttributes      = []string{"user_agent.original", "http.user_agent"}
+	otlpRefererAttributes = []string{"http.request.header.referer"}
+	otlpBytesAttributes   = []string{"http.response.body.size", "http.response_content_length"}
+	otlpHostAttributes    = []string{"server.address", "http.host"}
+)
+
+var errOTLPContentType = errors.New("仅支持 application/x-protobuf 与 application/json")
+
+// otlpResourceLogs 同一资源下的日志记录，属性值为 string/bool/int64/float64/[]byte/[]interface{}/map[string]interface{}
+type otlpResourceLogs struct {
+	resource map[string]interface{}
+	records  []otlpLogRecord
+}
+
+type otlpLogRecord struct {
+	timeUnixNano         uint64
+	observedTimeUnixNano uint64
+	body                 interface{}
+	attributes           map[string]interface{}
+}
+
+// otlpResult 一次导出请求的处理结果，rejected 不为 0 时按 partial_success 返回
+type otlpResult struct {
+	rejected int64
+	reason   string
+}
+
+// parseOTLPLogs 按 Content-Type 解析 ExportLogsServiceRequest
+func parseOTLPLogs(contentType string, body []byte) ([]otlpResourceLogs, bool, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case otlpContentTypeProto:
+		logs, err := parseOTLPProto(body)
+		return logs, false, err
+	case otlpContentTypeJSON:
+		logs, err := parseOTLPJSON(body)
+		return logs, true, err
+	default:
+		return nil, false, errOTLPContentType
+	}
+}
+
+// parseOTLPProto 解析 protobuf 格式：
+// ExportLogsServiceRequest{resource_logs=1}，ResourceLogs{resource=1, scope_logs=2}，Resource{attributes=1}，
+// ScopeLogs{log_records=2}，LogRecord{time_unix_nano=1, body=5, attributes=6, observed_time_unix_nano=11}
+func parseOTLPProto(data []byte) ([]otlpResourceLogs, error) {
+	var logs []otlpResourceLogs
+	err := eachProtoField(data, func(field protoField) error {
+		if field.num != 1 || field.typ != protowire.BytesType {
+			return nil
+		}
+		resourceLogs := otlpResourceLogs{resource: make(map[string]interface{})}
+		err := eachProtoField(field.bytes, func(field protoField) error {
+			if field.typ != protowire.BytesType {
+				return nil
+			}
+			switch field.num {
+			case 1:
+				return eachProtoField(field.bytes, func(field protoField) error {
+					if field.num == 1 && field.typ == protowire.BytesType {
+						return decodeOTLPKeyValue(field.bytes, resourceLogs.resource)
+					}
+					return nil
+				})
+			case 2:
+				return eachProtoField(field.bytes, func(field protoField) error {
+					if field.num != 2 || field.typ != protowire.BytesType {
+						return nil
+					}
+					record, err := decodeOTLPLogRecord(field.bytes)
+					if err != nil {
+						return err
+					}
+					resourceLogs.records = append(resourceLogs.records, record)
+					return nil
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		logs = append(logs, resourceLogs)
+		return nil
+	})
+	return logs, err
+}
+
+func decodeOTLPLogRecord(data []byte) (otlpLogRecord, error) {
+	record := otlpLogRecord{attributes: make(map[string]interface{})}
+	err := eachProtoField(data, func(field protoField) error {
+		switch {
+		case field.num == 1 && field.typ == protowire.Fixed64Type:
+			record.timeUnixNano = field.scalar
+		case field.num == 11 && field.typ == protowire.Fixed64Type:
+			record.observedTimeUnixNano = field.scalar
+		case field.num == 5 && field.typ == protowire.BytesType:
+			value, err := decodeOTLPAnyValue(field.bytes)
+			if err != nil {
+				return err
+			}
+			record.body = value
+		case field.num == 6 && field.typ == protowire.BytesType:
+			return decodeOTLPKeyValue(field.bytes, record.attributes)
+		}
+		return nil
+	})
+	return record, err
+}
+
+// decodeOTLPKeyValue 解析 KeyValue{key=1, value=2} 并写入 target
+func decodeOTLPKeyValue(data []byte, target map[string]interface{}) error {
+	var (
+		key   string
+		value interface{}
+	)
+	err := eachProtoField(data, func(field protoField) error {
+		if field.typ != protowire.BytesType {
+			return nil
+		}
+		switch field.num {
+		case 1:
+			key = string(field.bytes)
+		case 2:
+			decoded, err := decodeOTLPAnyValue(field.bytes)
+			if err != nil {
+				return err
+			}
+			value = decoded
+		}
+		return nil
+	})
+	if err == nil && key != "" {
+		target[key] = value
+	}
+	return err
+}
+
+// decodeOTLPAnyValue 解析 AnyValue：string=1, bool=2, int=3, double=4, array=5, kvlist=6, bytes=7
+func decodeOTLPAnyValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := eachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case 1:
+			value = string(field.bytes)
+		case 2:
+			value = field.scalar != 0
+		case 3:
+			value = int64(field.scalar)
+		case 4:
+			value = math.Float64frombits(field.scalar)
+		case 5:
+			items := []interface{}{}
+			err := eachProtoField(field.bytes, func(field protoField) error {
+				if field.num != 1 || field.typ != protowire.BytesType {
+					return nil
+				}
+				item, err := decodeOTLPAnyValue(field.bytes)
+				items = append(items, item)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			value = items
+		case 6:
+			items := make(map[string]interface{})
+			err := eachProtoField(field.bytes, func(field protoField) error {
+				if field.num == 1 && field.typ == protowire.BytesType {
+					return decodeOTLPKeyValue(field.bytes, items)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			value = items
+		case 7:
+			value = append([]byte(nil), field.bytes...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// OTLP/JSON 格式，64 位整数按 proto3 JSON 规范编码为字符串，也兼容数字
+type otlpJSONRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpJSONKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []struct {
+				TimeUnixNano         otlpJSONInt        `json:"timeUnixNano"`
+				ObservedTimeUnixNano otlpJSONInt        `json:"observedTimeUnixNano"`
+				Body                 *otlpJSONAnyValue  `json:"body"`
+				Attributes           []otlpJSONKeyValue `json:"attributes"`
+			} `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpJSONKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpJSONAnyValue `json:"value"`
+}
+
+type otlpJSONAnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	BoolValue   *bool        `json:"boolValue"`
+	IntValue    *otlpJSONInt `json:"intValue"`
+	DoubleValue *float64     `json:"doubleValue"`
+	ArrayValue  *struct {
+		Values []otlpJSONAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpJSONKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+	BytesValue *string `json:"bytesValue"`
+}
+
+type otlpJSONInt string
+
+func (v *otlpJSONInt) UnmarshalJSON(data []byte) error {
+	*v = otlpJSONInt(strings.Trim(string(data), `"`))
+	return nil
+}
+
+func parseOTLPJSON(body []byte) ([]otlpResourceLogs, error) {
+	var req otlpJSONRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	logs := make([]otlpResourceLogs, 0, len(req.ResourceLogs))
+	for _, item := range req.ResourceLogs {
+		resourceLogs := otlpResourceLogs{resource: otlpJSONAttributes(item.Resource.Attributes)}
+		for _, scope := range item.ScopeLogs {
+			for _, log := range scope.LogRecords {
+				record := otlpLogRecord{attributes: otlpJSONAttributes(log.Attributes)}
+				record.timeUnixNano, _ = strconv.ParseUint(string(log.TimeUnixNano), 10, 64)
+				record.observedTimeUnixNano, _ = strconv.ParseUint(string(log.ObservedTimeUnixNano), 10, 64)
+				if log.Body != nil {
+					record.body = log.Body.value()
+				}
+				resourceLogs.records = append(resourceLogs.records, record)
+			}
+		}
+		logs = append(logs, resourceLogs)
+	}
+	return logs, nil
+}
+
+func otlpJSONAttributes(items []otlpJSONKeyValue) map[string]interface{} {
+	attributes := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		attributes[item.Key] = item.Value.value()
+	}
+	return attributes
+}
+
+func (v otlpJSONAnyValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		value, _ := strconv.ParseInt(string(*v.IntValue), 10, 64)
+		return value
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		items := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			items = append(items, item.value())
+		}
+		return items
+	case v.KvlistValue != nil:
+		return otlpJSONAttributes(v.KvlistValue.Values)
+	case v.BytesValue != nil:
+		value, _ := base64.StdEncoding.DecodeString(*v.BytesValue)
+		return value
+	default:
+		return nil
+	}
+}
+
+// buildOTLPBatch 按资源属性将日志记录映射到站点与来源，资源属性缺失时使用 defaults（来自 URL 参数）。
+// 属性符合 HTTP 语义约定的记录直接转为结构化记录，否则将字符串类型的 body 作为原始日志行解析；
+// 无法映射到站点或两种形式都不满足的记录计入 partial_success 的 rejected。
+func buildOTLPBatch(logs []otlpResourceLogs, defaults ingestTarget) (*ingestBatch, otlpResult) {
+	batch := newIngestBatch()
+	var result otlpResult
+	reject := func(count int, reason string) {
+		result.rejected += int64(count)
+		if result.reason == "" {
+			result.reason = reason
+		}
+	}
+
+	for _, resourceLogs := range logs {
+		website := otlpAttribute(resourceLogs.resource, otlpWebsiteAttributes)
+		if website == "" {
+			website = defaults.websiteID
+		}
+		websiteID, ok := resolveIngestWebsite(website)
+		if !ok {
+			reject(len(resourceLogs.records), fmt.Sprintf("资源属性 nginxpulse.website 无法映射到站点: %q", website))
+			continue
+		}
+		sourceID := otlpAttribute(resourceLogs.resource, otlpSourceAttributes)
+		if sourceID == "" {
+			sourceID = defaults.sourceID
+		}
+		target := ingestTarget{websiteID: websiteID, sourceID: sourceID}
+
+		for _, record := range resourceLogs.records {
+			if httpRecord, ok := otlpHTTPRecord(record); ok {
+				batch.addRecord(target, httpRecord)
+				continue
+			}
+			line, ok := record.body.(string)
+			if bytes, isBytes := record.body.([]byte); isBytes {
+				line, ok = string(bytes), true
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if !ok || strings.TrimSpace(line) == "" {
+				reject(1, "日志记录既没有字符串 body，也没有 http.request.method 等 HTTP 属性")
+				continue
+			}
+			batch.add(target, line)
+		}
+	}
+	return batch, result
+}
+
+// otlpHTTPRecord 将符合 HTTP 语义约定的日志属性转为结构化记录，缺少请求方法时返回 false
+func otlpHTTPRecord(record otlpLogRecord) (ingest.IngestRecord, bool) {
+	attributes := record.attributes
+	method := otlpAttribute(attributes, otlpMethodAttributes)
+	if method == "" {
+		return ingest.IngestRecord{}, false
+	}
+
+	result := ingest.IngestRecord{
+		Method:    strings.ToUpper(method),
+		URL:       otlpRequestURL(attributes),
+		IP:        otlpAttribute(attributes, otlpClientAttributes),
+		UserAgent: otlpAttribute(attributes, otlpUAAttributes),
+		Referer:   otlpAttribute(attributes, otlpRefererAttributes),
+		Host:      otlpAttribute(attributes, otlpHostAttributes),
+	}
+	result.Status, _ = otlpIntAttribute(attributes, otlpStatusAttributes)
+	result.BytesSent, _ = otlpIntAttribute(attributes, otlpBytesAttributes)
+
+	timestamp := record.timeUnixNano
+	if timestamp == 0 {
+		timestamp = record.observedTimeUnixNano
+	}
+	if timestamp > 0 && timestamp <= math.MaxInt64 {
+		result.Timestamp = time.Unix(0, int64(timestamp))
+	}
+	return result, true
+}
+
+// otlpRequestURL 优先使用 url.path 与 url.query，其次为旧版的 http.target，最后从 url.full 中取路径
+func otlpRequestURL(attributes map[string]interface{}) string {
+	if path := otlpAttribute(attributes, []string{"url.path"}); path != "" {
+		if query := otlpAttribute(attributes, []string{"url.query"}); query != "" {
+			return path + "?" + query
+		}
+		return path
+	}
+	if target := otlpAttribute(attributes, []string{"http.target"}); target != "" {
+		return target
+	}
+	if full := otlpAttribute(attributes, []string{"url.full", "http.url"}); full != "" {
+		if parsed, err := url.Parse(full); err == nil {
+			return parsed.RequestURI()
+		}
+	}
+	return ""
+}
+
+// otlpAttribute 按顺序取第一个非空的属性值；数组取第一个元素（请求头类属性为字符串数组）
+func otlpAttribute(attributes map[string]interface{}, names []string) string {
+	for _, name := range names {
+		value := attributes[name]
+		if items, ok := value.([]interface{}); ok {
+			if len(items) == 0 {
+				continue
+			}
+			value = items[0]
+		}
+		var text string
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		default:
+			text = fmt.Sprint(v)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+func otlpIntAttribute(attributes map[string]interface{}, names []string) (int, bool) {
+	for _, name := range names {
+		switch v := attributes[name].(type) {
+		case int64:
+			return int(v), true
+		case float64:
+			return int(v), true
+		case string:
+			if value, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+				return value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// encodeOTLPResponse 生成 ExportLogsServiceResponse，全部写入时为空消息
+func encodeOTLPResponse(result otlpResult, asJSON bool) []byte {
+	if asJSON {
+		if result.rejected == 0 {
+			return []byte("{}")
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"partialSuccess": map[string]interface{}{
+				"rejectedLogRecords": strconv.FormatInt(result.rejected, 10),
+				"errorMessage":       result.reason,
+			},
+		})
+		return data
+	}
+
+	if result.rejected == 0 {
+		return []byte{}
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(result.rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, result.reason)
+
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	return protowire.AppendBytes(data, partial)
+}
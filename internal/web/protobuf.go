@@ -0,0 +1,49 @@
+package web
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// protoField protobuf 消息中的一个字段：length-delimited 类型的值在 bytes 中，varint 与 fixed32/64 类型的值在 scalar 中
+type protoField struct {
+	num    protowire.Number
+	typ    protowire.Type
+	bytes  []byte
+	scalar uint64
+}
+
+// eachProtoField 依次遍历消息中的字段，group 等已废弃的类型直接跳过
+func eachProtoField(data []byte, fn func(field protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			field.scalar, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			field.scalar, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var value uint32
+			value, n = protowire.ConsumeFixed32(data)
+			field.scalar = uint64(value)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ == protowire.StartGroupType {
+			continue
+		}
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
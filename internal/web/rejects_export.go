@@ -0,0 +1,49 @@
+package web
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/likaia/nginxpulse/internal/store"
+)
+
+const rejectMaxPageSize = 1000
+
+func exportRejectsCSV(writer io.Writer, repo *store.Repository, websiteID, sourceID string) error {
+	if _, err := writer.Write([]byte("\ufeff")); err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	if err := csvWriter.Write([]string{"time", "source_id", "target", "offset", "reason", "line"}); err != nil {
+		return err
+	}
+
+	for page := 1; ; page++ {
+		rejects, total, err := repo.ListRejectsForWebsite(websiteID, sourceID, page, rejectMaxPageSize)
+		if err != nil {
+			return err
+		}
+		for _, reject := range rejects {
+			row := []string{
+				time.Unix(reject.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+				reject.SourceID,
+				reject.Target,
+				strconv.FormatInt(reject.Offset, 10),
+				reject.Reason,
+				reject.Line,
+			}
+			if err := csvWriter.Write(row); err != nil {
+				return err
+			}
+		}
+		if len(rejects) == 0 || page*rejectMaxPageSize >= total {
+			break
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"context"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -89,6 +90,8 @@ func generateDemoLogs(repo *store.Repository, rng *rand.Rand) {
 
 			timestamp := now.Add(-time.Duration(rng.Intn(60)) * time.Second)
 			pageviewFlag := enrich.ShouldCountAsPageView(status, path, ip)
+			// 对数正态分布的耗时，多数请求在几十毫秒内，少量长尾
+			requestTime := math.Round(math.Exp(3.5+rng.NormFloat64()*0.9)*10) / 10
 
 			batch = append(batch, store.NginxLogRecord{
 				IP:               ip,
@@ -104,6 +107,7 @@ func generateDemoLogs(repo *store.Repository, rng *rand.Rand) {
 				UserDevice:       device,
 				DomesticLocation: "",
 				GlobalLocation:   "",
+				RequestTime:      &requestTime,
 			})
 		}
 
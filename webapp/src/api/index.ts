@@ -1,14 +1,21 @@
 import type { AxiosResponse } from 'axios';
 import client from './client';
 import type {
+  AgentStatus,
+  AgentsResponse,
   AppStatusResponse,
   ApiResponse,
   ConfigPayload,
   ConfigResponse,
   ConfigSaveResponse,
   ConfigValidationResult,
+  LatencyStats,
+  NginxImportResult,
+  ParseConfig,
+  ParsePreviewResult,
   RealtimeStats,
   IPGeoAnomalyResponse,
+  IngestRejectsResponse,
   SimpleSeriesStats,
   TimeSeriesStats,
   WebsiteInfo,
@@ -57,6 +64,24 @@ export const saveConfig = async (config: ConfigPayload): Promise<ConfigSaveRespo
   return response.data;
 };
 
+export const importNginxConfig = async (payload: {
+  path?: string;
+  content?: string;
+}): Promise<NginxImportResult> => {
+  const response = await client.post<ApiResponse<NginxImportResult>>('/api/config/import-nginx', payload);
+  return response.data;
+};
+
+export const previewParse = async (payload: {
+  lines: string[];
+  parse?: ParseConfig;
+  website_id?: string;
+  source_id?: string;
+}): Promise<ParsePreviewResult> => {
+  const response = await client.post<ApiResponse<ParsePreviewResult>>('/api/parse/preview', payload);
+  return response.data;
+};
+
 export const restartSystem = async (): Promise<{ success: boolean }> => {
   const response = await client.post<ApiResponse<{ success: boolean }>>('/api/system/restart');
   return response.data;
@@ -88,6 +113,43 @@ export const repairIPGeoAnomaly = async (websiteId: string): Promise<void> => {
   });
 };
 
+export const fetchIngestRejects = async (
+  websiteId: string,
+  page = 1,
+  pageSize = 100,
+  sourceId?: string
+): Promise<IngestRejectsResponse> => {
+  const response = await client.get<ApiResponse<IngestRejectsResponse>>('/api/ingest/rejects', {
+    params: buildParams({ id: websiteId, page, pageSize, source_id: sourceId }),
+  });
+  return response.data;
+};
+
+export const fetchAgents = async (): Promise<AgentStatus[]> => {
+  const response = await client.get<ApiResponse<AgentsResponse>>('/api/agents');
+  return response.data.agents || [];
+};
+
+export const downloadIngestRejects = async (
+  websiteId: string,
+  sourceId?: string
+): Promise<AxiosResponse<Blob>> =>
+  client.get('/api/ingest/rejects/download', {
+    params: buildParams({ id: websiteId, source_id: sourceId }),
+    responseType: 'blob',
+  });
+
+export const purgeIngestRejects = async (
+  websiteId: string,
+  sourceId?: string
+): Promise<{ success: boolean; deleted: number }> => {
+  const response = await client.delete<ApiResponse<{ success: boolean; deleted: number }>>(
+    '/api/ingest/rejects',
+    { params: buildParams({ id: websiteId, source_id: sourceId }) }
+  );
+  return response.data;
+};
+
 const fetchStats = async <T>(type: string, params: Record<string, unknown> = {}): Promise<T> => {
   const response = await client.get<ApiResponse<T>>(`/api/stats/${type}`, {
     params: buildParams(params),
@@ -155,6 +217,13 @@ export const fetchRealtimeStats = (
   window: number
 ): Promise<RealtimeStats> => fetchStats('realtime', { id: websiteId, window });
 
+export const fetchLatencyStats = (
+  websiteId: string,
+  timeRange: string,
+  viewType: string,
+  limit = 10
+): Promise<LatencyStats> => fetchStats('latency', { id: websiteId, timeRange, viewType, limit });
+
 export const fetchLogs = (
   websiteId: string,
   page: number,
@@ -23,6 +23,18 @@ export interface AppStatusResponse {
   migration_required?: boolean;
   setup_required?: boolean;
   config_readonly?: boolean;
+  parse_failures?: SourceParseFailure[];
+  agent_sources_silent?: SilentAgentSource[];
+}
+
+export interface SourceParseFailure {
+  website_id: string;
+  source_id: string;
+  parsed: number;
+  failed: number;
+  failure_ratio: number;
+  last_error?: string;
+  last_failed_at?: number;
 }
 
 export interface SourceConfig {
@@ -38,6 +50,7 @@ export interface WebsiteConfig {
   logRegex?: string;
   timeLayout?: string;
   sources?: SourceConfig[];
+  catchAll?: boolean;
 }
 
 export interface SystemConfig {
@@ -87,6 +100,59 @@ export interface ConfigValidationResult {
   warnings: FieldError[];
 }
 
+export interface ParseConfig {
+  logType?: string;
+  logFormat?: string;
+  logRegex?: string;
+  timeLayout?: string;
+  fieldMap?: Record<string, string>;
+}
+
+export interface ParsedLogRecord {
+  ip: string;
+  timestamp: string;
+  method: string;
+  url: string;
+  status: number;
+  bytes_sent: number;
+  referer: string;
+  user_browser: string;
+  user_os: string;
+  user_device: string;
+  pageview_flag: number;
+  request_time?: number;
+  upstream_time?: number;
+  host?: string;
+}
+
+export interface ParsePreviewLine {
+  line: string;
+  record?: ParsedLogRecord;
+  error?: string;
+  warning?: string;
+  skipped?: boolean;
+}
+
+export interface ParsePreviewCandidate {
+  parse: ParseConfig;
+  matched: number;
+  failed: number;
+}
+
+export interface ParsePreviewResult {
+  parse: ParseConfig;
+  detected: boolean;
+  matched: number;
+  failed: number;
+  lines: ParsePreviewLine[];
+  candidates?: ParsePreviewCandidate[];
+}
+
+export interface NginxImportResult {
+  websites: WebsiteConfig[];
+  warnings: string[];
+}
+
 export interface ConfigResponse {
   config: ConfigPayload;
   readonly: boolean;
@@ -129,10 +195,87 @@ export interface RealtimeStats {
   locations: RealtimeSeriesItem[];
 }
 
+export interface SlowURLStat {
+  url: string;
+  requests: number;
+  avg: number;
+  p90: number;
+  max: number;
+}
+
+export interface LatencyStats {
+  labels: string[];
+  requests: number[];
+  avg: number[];
+  p50: number[];
+  p90: number[];
+  p99: number[];
+  slowestUrls: SlowURLStat[];
+}
+
 export interface IPGeoAnomalyResponse {
   has_issue: boolean;
   count: number;
   samples?: string[];
 }
 
+export interface RejectedLine {
+  id: number;
+  created_at: number;
+  source_id: string;
+  target: string;
+  offset: number;
+  reason: string;
+  line: string;
+}
+
+export interface IngestRejectsResponse {
+  rejects: RejectedLine[];
+  total: number;
+  limit: number;
+}
+
+export interface SilentAgentSource {
+  website_id: string;
+  source_id: string;
+  last_seen_at?: number;
+}
+
+export interface AgentInput {
+  website_id: string;
+  source_id: string;
+  paths: string[];
+}
+
+export interface AgentFileStatus {
+  path: string;
+  website_id: string;
+  source_id: string;
+  offset: number;
+  size: number;
+  lag: number;
+}
+
+export interface AgentStatus {
+  agent_id: string;
+  hostname: string;
+  version: string;
+  remote_addr: string;
+  inputs: AgentInput[];
+  files: AgentFileStatus[] | null;
+  lag: number;
+  spool_batches: number;
+  spool_bytes: number;
+  last_error?: string;
+  last_error_at?: number;
+  heartbeat_interval: number;
+  registered_at: number;
+  last_seen_at: number;
+  online: boolean;
+}
+
+export interface AgentsResponse {
+  agents: AgentStatus[];
+}
+
 export type ApiResponse<T> = T;
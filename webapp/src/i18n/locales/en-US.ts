@@ -151,6 +151,7 @@ const enUS = {
       logRegex: 'Log regex',
       timeLayout: 'Time layout',
       sourcesJson: 'Advanced sources (sources JSON)',
+      catchAll: 'Catch-all for shared logs',
       databaseDsn: 'Database DSN',
       dbMaxOpen: 'Max open conns',
       dbMaxIdle: 'Max idle conns',
@@ -179,7 +180,8 @@ const enUS = {
     },
     hints: {
       logPath: 'Use full path or glob patterns, must be accessible in container',
-      sourcesJson: 'Provide sources JSON for SFTP/HTTP/S3 advanced sources',
+      sourcesJson: 'Provide sources JSON for SFTP/HTTP/S3/syslog/Fluent/Docker advanced sources',
+      catchAll: 'When several sites share one log, records are routed by Host against each site\'s domains; enable to receive records that match no site',
       accessKeys: 'Separate multiple keys with commas',
     },
     review: {
@@ -151,6 +151,7 @@ const zhCN = {
       logRegex: '日志正则',
       timeLayout: '时间格式',
       sourcesJson: '高级来源 (sources JSON)',
+      catchAll: '共享日志兜底站点',
       databaseDsn: '数据库 DSN',
       dbMaxOpen: '最大连接数',
       dbMaxIdle: '最大空闲连接',
@@ -179,7 +180,8 @@ const zhCN = {
     },
     hints: {
       logPath: '支持完整路径或通配符，需在容器内可访问',
-      sourcesJson: '填写 sources 数组 JSON，用于 SFTP/HTTP/S3 等高级来源',
+      sourcesJson: '填写 sources 数组 JSON，用于 SFTP/HTTP/S3/syslog/Fluent/Docker 等高级来源',
+      catchAll: '多个站点共享同一份日志时按 Host 匹配域名列表分流，开启后未匹配任何站点的记录归入本站点',
       accessKeys: '多个密钥用逗号分隔',
     },
     review: {